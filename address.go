@@ -0,0 +1,417 @@
+package tapi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/rschio/mb-tapi/internal/keccak"
+)
+
+// Reasons of an AddressError, they can be compared with errors.Is.
+var (
+	ErrAddressFormat   = errors.New("invalid format")
+	ErrAddressChecksum = errors.New("invalid checksum")
+	ErrAddressVersion  = errors.New("unknown version or network")
+	ErrAddressLength   = errors.New("invalid length")
+	ErrDestinationTag  = errors.New("invalid destination tag")
+)
+
+// AddressError is returned when a withdrawal address is invalid.
+type AddressError struct {
+	Coin    Coin
+	Address string
+
+	// Err is the reason, one of the ErrAddress errors or
+	// ErrDestinationTag.
+	Err error
+
+	// Detail tells exactly what is wrong.
+	Detail string
+}
+
+func (e *AddressError) Error() string {
+	s := fmt.Sprintf("invalid %v address %q: %v", e.Coin, e.Address, e.Err)
+	if e.Detail != "" {
+		s += ": " + e.Detail
+	}
+	return s
+}
+
+// Unwrap returns the reason of the error.
+func (e *AddressError) Unwrap() error { return e.Err }
+
+// ValidateAddress checks offline the format and checksum of addr as an
+// address of coin. It accepts:
+//
+//	BTC: base58check P2PKH and P2SH, bech32 and bech32m (bc1).
+//	LTC: base58check P2PKH and P2SH, bech32 and bech32m (ltc1).
+//	BCH: CashAddr, with or without the bitcoincash: prefix, and legacy.
+//	ETH: 0x hex with the EIP-55 checksum, all lower or upper case
+//	hex has no checksum to check.
+//	XRP: classic addresses, X-addresses are rejected.
+func ValidateAddress(coin Coin, addr string) error {
+	var err error
+	switch coin {
+	case BTC:
+		err = validateBitcoinLike(addr, "bc", []byte{0x00}, []byte{0x05})
+	case LTC:
+		err = validateBitcoinLike(addr, "ltc", []byte{0x30}, []byte{0x32, 0x05})
+	case BCH:
+		err = validateBCH(addr)
+	case ETH:
+		err = validateETH(addr)
+	case XRP:
+		err = validateXRP(addr)
+	default:
+		err = bad(ErrAddressVersion, "coin has no addresses")
+	}
+	if err != nil {
+		e := err.(*AddressError)
+		e.Coin, e.Address = coin, addr
+		return e
+	}
+	return nil
+}
+
+// bad creates an AddressError, the caller sets its coin and address.
+func bad(err error, detail string) *AddressError {
+	return &AddressError{Err: err, Detail: detail}
+}
+
+var (
+	tagMu       sync.RWMutex
+	tagRequired = make(map[string]bool)
+)
+
+// RequireDestinationTag registers XRP addresses that only accept
+// payments with a destination tag, such as the deposit addresses that
+// an exchange shares among its customers. The ledger flag that marks
+// them can only be read online, so the known addresses must be
+// registered before withdrawing to them.
+func RequireDestinationTag(addrs ...string) {
+	tagMu.Lock()
+	defer tagMu.Unlock()
+	for _, addr := range addrs {
+		tagRequired[addr] = true
+	}
+}
+
+// validateDestinationTag checks that tag fits the uint32 XRP tags and
+// that it is set if addr requires one. The tag is always sent, so a
+// zero tag counts as missing.
+func validateDestinationTag(addr string, tag int) error {
+	if tag < 0 || int64(tag) > math.MaxUint32 {
+		return &AddressError{Coin: XRP, Address: addr, Err: ErrDestinationTag,
+			Detail: fmt.Sprintf("%d is out of the range 0 to %d", tag, uint32(math.MaxUint32))}
+	}
+	tagMu.RLock()
+	required := tagRequired[addr]
+	tagMu.RUnlock()
+	if required && tag == 0 {
+		return &AddressError{Coin: XRP, Address: addr, Err: ErrDestinationTag,
+			Detail: "the address requires a destination tag"}
+	}
+	return nil
+}
+
+func validateBitcoinLike(addr, hrp string, p2pkh, p2sh []byte) error {
+	if hrp != "" && strings.HasPrefix(strings.ToLower(addr), hrp+"1") {
+		return validateSegwit(addr, hrp)
+	}
+	payload, err := decodeBase58Check(addr, bitcoinAlphabet)
+	if err != nil {
+		return err
+	}
+	if len(payload) != 21 {
+		return bad(ErrAddressLength, fmt.Sprintf("payload has %d bytes, expected 21", len(payload)))
+	}
+	v := payload[0]
+	if bytes.IndexByte(p2pkh, v) < 0 && bytes.IndexByte(p2sh, v) < 0 {
+		return bad(ErrAddressVersion, fmt.Sprintf("version byte 0x%02x", v))
+	}
+	return nil
+}
+
+const (
+	bitcoinAlphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+	rippleAlphabet  = "rpshnaf39wBUDNEGHJKLM4PQRST7VWXYZ2bcdeCg65jkm8oFqi1tuvAxyz"
+)
+
+func decodeBase58(s, alphabet string) ([]byte, error) {
+	if s == "" {
+		return nil, bad(ErrAddressFormat, "empty address")
+	}
+	n := new(big.Int)
+	radix := big.NewInt(58)
+	for i, r := range s {
+		d := strings.IndexRune(alphabet, r)
+		if d < 0 {
+			return nil, bad(ErrAddressFormat, fmt.Sprintf("character %q at %d is not base58", r, i))
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(d)))
+	}
+	// Each leading zero digit is a zero byte.
+	zeros := 0
+	for zeros < len(s) && s[zeros] == alphabet[0] {
+		zeros++
+	}
+	return append(make([]byte, zeros), n.Bytes()...), nil
+}
+
+func decodeBase58Check(s, alphabet string) ([]byte, error) {
+	b, err := decodeBase58(s, alphabet)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 5 {
+		return nil, bad(ErrAddressLength, fmt.Sprintf("%d bytes is too short", len(b)))
+	}
+	payload, sum := b[:len(b)-4], b[len(b)-4:]
+	h := sha256.Sum256(payload)
+	h = sha256.Sum256(h[:])
+	if !bytes.Equal(h[:4], sum) {
+		return nil, bad(ErrAddressChecksum, "base58check checksum mismatch")
+	}
+	return payload, nil
+}
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+const (
+	bech32Const  = 1
+	bech32mConst = 0x2bc830a3
+)
+
+func bech32Polymod(values []byte) uint32 {
+	gen := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		b := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (b>>uint(i))&1 == 1 {
+				chk ^= gen[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HRPExpand(hrp string) []byte {
+	out := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		out = append(out, hrp[i]>>5)
+	}
+	out = append(out, 0)
+	for i := 0; i < len(hrp); i++ {
+		out = append(out, hrp[i]&31)
+	}
+	return out
+}
+
+// decodeCharset decodes s with the bech32 charset.
+func decodeCharset(s string) ([]byte, error) {
+	out := make([]byte, len(s))
+	for i := 0; i < len(s); i++ {
+		d := strings.IndexByte(bech32Charset, s[i])
+		if d < 0 {
+			return nil, bad(ErrAddressFormat, fmt.Sprintf("character %q at %d is not bech32", s[i], i))
+		}
+		out[i] = byte(d)
+	}
+	return out, nil
+}
+
+// convertBits regroups the bits of data from groups of from bits to
+// groups of to bits.
+func convertBits(data []byte, from, to uint, pad bool) ([]byte, bool) {
+	acc, nbits := uint(0), uint(0)
+	maxv := uint(1)<<to - 1
+	var out []byte
+	for _, v := range data {
+		acc = acc<<from | uint(v)
+		nbits += from
+		for nbits >= to {
+			nbits -= to
+			out = append(out, byte(acc>>nbits&maxv))
+		}
+	}
+	if pad {
+		if nbits > 0 {
+			out = append(out, byte(acc<<(to-nbits)&maxv))
+		}
+	} else if nbits >= from || acc<<(to-nbits)&maxv != 0 {
+		return nil, false
+	}
+	return out, true
+}
+
+func validateSegwit(addr, hrp string) error {
+	if strings.ToLower(addr) != addr && strings.ToUpper(addr) != addr {
+		return bad(ErrAddressFormat, "mixed case")
+	}
+	s := strings.ToLower(addr)
+	if len(s) > 90 {
+		return bad(ErrAddressLength, fmt.Sprintf("%d characters is longer than 90", len(s)))
+	}
+	data, err := decodeCharset(s[len(hrp)+1:])
+	if err != nil {
+		return err
+	}
+	if len(data) < 7 {
+		return bad(ErrAddressLength, "data part is too short")
+	}
+	version := data[0]
+	if version > 16 {
+		return bad(ErrAddressVersion, fmt.Sprintf("witness version %d", version))
+	}
+	want := uint32(bech32Const)
+	if version > 0 {
+		want = bech32mConst
+	}
+	chk := bech32Polymod(append(bech32HRPExpand(hrp), data...))
+	if chk != want {
+		if chk == bech32Const || chk == bech32mConst {
+			return bad(ErrAddressChecksum, fmt.Sprintf("wrong checksum variant for witness version %d", version))
+		}
+		return bad(ErrAddressChecksum, "bech32 checksum mismatch")
+	}
+	prog, ok := convertBits(data[1:len(data)-6], 5, 8, false)
+	if !ok {
+		return bad(ErrAddressFormat, "invalid padding")
+	}
+	if len(prog) < 2 || len(prog) > 40 {
+		return bad(ErrAddressLength, fmt.Sprintf("witness program has %d bytes", len(prog)))
+	}
+	if version == 0 && len(prog) != 20 && len(prog) != 32 {
+		return bad(ErrAddressLength, fmt.Sprintf("version 0 witness program has %d bytes, expected 20 or 32", len(prog)))
+	}
+	return nil
+}
+
+func cashAddrPolymod(values []byte) uint64 {
+	gen := [5]uint64{0x98f2bc8e61, 0x79b76d99e2, 0xf33e5fb3c4, 0xae2eabe2a8, 0x1e4f43e470}
+	c := uint64(1)
+	for _, d := range values {
+		c0 := byte(c >> 35)
+		c = (c&0x07ffffffff)<<5 ^ uint64(d)
+		for i := 0; i < 5; i++ {
+			if (c0>>uint(i))&1 == 1 {
+				c ^= gen[i]
+			}
+		}
+	}
+	return c ^ 1
+}
+
+func validateBCH(addr string) error {
+	s := strings.ToLower(addr)
+	if s == "" {
+		return bad(ErrAddressFormat, "empty address")
+	}
+	const prefix = "bitcoincash"
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		if s[:i] != prefix {
+			return bad(ErrAddressVersion, fmt.Sprintf("prefix %q", s[:i]))
+		}
+		s = s[i+1:]
+	} else if s[0] != 'q' && s[0] != 'p' {
+		// Legacy addresses share the Bitcoin format.
+		return validateBitcoinLike(addr, "", []byte{0x00}, []byte{0x05})
+	}
+	if strings.ToLower(addr) != addr && strings.ToUpper(addr) != addr {
+		return bad(ErrAddressFormat, "mixed case")
+	}
+	data, err := decodeCharset(s)
+	if err != nil {
+		return err
+	}
+	if len(data) < 9 {
+		return bad(ErrAddressLength, "data part is too short")
+	}
+	var pre []byte
+	for i := 0; i < len(prefix); i++ {
+		pre = append(pre, prefix[i]&31)
+	}
+	pre = append(pre, 0)
+	if cashAddrPolymod(append(pre, data...)) != 0 {
+		return bad(ErrAddressChecksum, "CashAddr checksum mismatch")
+	}
+	payload, ok := convertBits(data[:len(data)-8], 5, 8, false)
+	if !ok || len(payload) == 0 {
+		return bad(ErrAddressFormat, "invalid padding")
+	}
+	version := payload[0]
+	if version&0x80 != 0 {
+		return bad(ErrAddressVersion, fmt.Sprintf("version byte 0x%02x", version))
+	}
+	if typ := version >> 3; typ > 1 {
+		return bad(ErrAddressVersion, fmt.Sprintf("address type %d", typ))
+	}
+	sizes := []int{20, 24, 28, 32, 40, 48, 56, 64}
+	if size := sizes[version&7]; len(payload)-1 != size {
+		return bad(ErrAddressLength, fmt.Sprintf("hash has %d bytes, expected %d", len(payload)-1, size))
+	}
+	return nil
+}
+
+func validateETH(addr string) error {
+	if !strings.HasPrefix(addr, "0x") {
+		return bad(ErrAddressFormat, "missing 0x prefix")
+	}
+	h := addr[2:]
+	if len(h) != 40 {
+		return bad(ErrAddressLength, fmt.Sprintf("%d hex digits, expected 40", len(h)))
+	}
+	if _, err := hex.DecodeString(h); err != nil {
+		return bad(ErrAddressFormat, "not hexadecimal")
+	}
+	if strings.ToLower(h) == h || strings.ToUpper(h) == h {
+		return nil
+	}
+	lower := strings.ToLower(h)
+	sum := keccak.Sum256([]byte(lower))
+	for i := 0; i < 40; i++ {
+		c := h[i]
+		if c < 'a' && (c < 'A' || c > 'F') {
+			continue
+		}
+		nibble := sum[i/2] >> 4
+		if i%2 == 1 {
+			nibble = sum[i/2] & 0x0f
+		}
+		upper := c >= 'A' && c <= 'F'
+		if upper != (nibble >= 8) {
+			return bad(ErrAddressChecksum, fmt.Sprintf("EIP-55 case mismatch at hex digit %d", i))
+		}
+	}
+	return nil
+}
+
+func validateXRP(addr string) error {
+	if strings.HasPrefix(addr, "X") || strings.HasPrefix(addr, "T") {
+		return bad(ErrAddressFormat, "X-address, use the classic address and DestinationTag")
+	}
+	if !strings.HasPrefix(addr, "r") {
+		return bad(ErrAddressFormat, "classic addresses start with r")
+	}
+	payload, err := decodeBase58Check(addr, rippleAlphabet)
+	if err != nil {
+		return err
+	}
+	if len(payload) != 21 {
+		return bad(ErrAddressLength, fmt.Sprintf("payload has %d bytes, expected 21", len(payload)))
+	}
+	if payload[0] != 0 {
+		return bad(ErrAddressVersion, fmt.Sprintf("version byte 0x%02x", payload[0]))
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package tapi
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateAddress(t *testing.T) {
+	tests := []struct {
+		coin Coin
+		addr string
+		err  error
+	}{
+		{BTC, "1BpEi6DfDAUFd7GtittLSdBeYJvcoaVggu", nil},
+		{BTC, "3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy", nil},
+		{BTC, "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", nil},
+		{BTC, "BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", nil},
+		{BTC, "bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0", nil},
+		{BTC, "1BpEi6DfDAUFd7GtittLSdBeYJvcoaVggv", ErrAddressChecksum},
+		{BTC, "1BpEi6DfDAUFd7GtittLSdBeYJvcoaVgg0", ErrAddressFormat},
+		{BTC, "LW3ByJXVHpiJsuy3u2sdieFQkXHtuk93Yi", ErrAddressVersion},
+		{BTC, "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t5", ErrAddressChecksum},
+		{BTC, "bc1Qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", ErrAddressFormat},
+		{BTC, "bc1pqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqq5us4ke", ErrAddressChecksum},
+		{LTC, "LW3ByJXVHpiJsuy3u2sdieFQkXHtuk93Yi", nil},
+		{LTC, "MJiPwX84iBe4WnFDwsYGgtnz1XonPhUqhf", nil},
+		{LTC, "3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy", nil},
+		{LTC, "ltc1qw6syq5aa5z5ghkj3w7ux59wrk204txrnp208rt", nil},
+		{LTC, "ltc1pqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqzywff7", nil},
+		{LTC, "1BpEi6DfDAUFd7GtittLSdBeYJvcoaVggu", ErrAddressVersion},
+		{LTC, "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", ErrAddressFormat},
+		{BCH, "bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a", nil},
+		{BCH, "qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a", nil},
+		{BCH, "bitcoincash:ppm2qsznhks23z7629mms6s4cwef74vcwvn0h829pq", nil},
+		{BCH, "1BpEi6DfDAUFd7GtittLSdBeYJvcoaVggu", nil},
+		{BCH, "bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6c", ErrAddressChecksum},
+		{BCH, "bchtest:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a", ErrAddressVersion},
+		{ETH, "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", nil},
+		{ETH, "0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359", nil},
+		{ETH, "0xdbF03B407c01E7cD3CBea99509d93f8DDDC8C6FB", nil},
+		{ETH, "0xD1220A0cf47c7B9Be7A2E6BA89F429762e7b9aDb", nil},
+		{ETH, "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", nil},
+		{ETH, "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeD", ErrAddressChecksum},
+		{ETH, "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeA", ErrAddressLength},
+		{ETH, "5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", ErrAddressFormat},
+		{ETH, "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeg", ErrAddressFormat},
+		{XRP, "rHb9CJAWyB4rj91VRWn96DkukG4bwdtyTh", nil},
+		{XRP, "rPT1Sjq2YGrBMTttX4GZHjKu9dyfzbpAYe", nil},
+		{XRP, "rPT1Sjq2YGrBMTttX4GZHjKu9dyfzbpAYf", ErrAddressChecksum},
+		{XRP, "X7AcgcsBL6XDcUb289X4mJ8djcdyKaB5hJDWMArnXr61cqZ", ErrAddressFormat},
+		{XRP, "1BpEi6DfDAUFd7GtittLSdBeYJvcoaVggu", ErrAddressFormat},
+		{BRL, "001122", ErrAddressVersion},
+	}
+	for _, tt := range tests {
+		err := ValidateAddress(tt.coin, tt.addr)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%v %s: got error %v, expected %v", tt.coin, tt.addr, err, tt.err)
+		}
+		if err == nil {
+			continue
+		}
+		var ae *AddressError
+		if !errors.As(err, &ae) || ae.Coin != tt.coin || ae.Address != tt.addr {
+			t.Errorf("%v %s: got error %#v", tt.coin, tt.addr, err)
+		}
+	}
+}
+
+func TestValidateDestinationTag(t *testing.T) {
+	tests := []struct {
+		tag int
+		err error
+	}{
+		{0, nil},
+		{42, nil},
+		{4294967295, nil},
+		{4294967296, ErrDestinationTag},
+		{-1, ErrDestinationTag},
+	}
+	for _, tt := range tests {
+		err := validateDestinationTag("rPT1Sjq2YGrBMTttX4GZHjKu9dyfzbpAYe", tt.tag)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("tag %d: got error %v, expected %v", tt.tag, err, tt.err)
+		}
+	}
+
+	const exchange = "rLHzPsX6oXkzU2qL12kHCH8G8cnZv1rBJh"
+	RequireDestinationTag(exchange)
+	if err := validateDestinationTag(exchange, 0); !errors.Is(err, ErrDestinationTag) {
+		t.Errorf("missing required tag: got error %v, expected %v", err, ErrDestinationTag)
+	}
+	if err := validateDestinationTag(exchange, 42); err != nil {
+		t.Errorf("required tag: got error %v", err)
+	}
+}
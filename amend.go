@@ -0,0 +1,120 @@
+package tapi
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rschio/mb-tapi/internal/dec"
+)
+
+var (
+	// ErrAmendFilled is returned by AmendOrder when the order was
+	// filled before it was cancelled, so there is nothing to replace.
+	ErrAmendFilled = errors.New("amend: order filled")
+
+	// ErrAmendNotCancelled is returned by AmendOrder when the order
+	// could not be cancelled. The order is unchanged.
+	ErrAmendNotCancelled = errors.New("amend: order not cancelled")
+
+	// ErrAmendNotReplaced is returned by AmendOrder when the order was
+	// cancelled but the replacement could not be placed.
+	ErrAmendNotReplaced = errors.New("amend: replacement not placed")
+)
+
+// Amend is the result of AmendOrder.
+type Amend struct {
+	// Cancelled is the final state of the amended order.
+	Cancelled *Order
+
+	// Remaining is the quantity not executed by the cancelled order.
+	Remaining string
+
+	// Replacement is the order placed with the remaining quantity.
+	Replacement *Order
+}
+
+// AmendError is the error of AmendOrder. Amend has the orders known
+// when it failed.
+type AmendError struct {
+	Amend *Amend
+	Err   error
+	Cause error
+}
+
+func (e *AmendError) Error() string {
+	if e.Cause == nil {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%v: %v", e.Err, e.Cause)
+}
+
+func (e *AmendError) Unwrap() error { return e.Err }
+
+// AmendOrder changes the price of an open order of c. See the function
+// AmendOrder.
+func (c *Client) AmendOrder(c1, c2 Coin, id int, limit string) (*Amend, error) {
+	return AmendOrder(c, c1, c2, id, limit)
+}
+
+// AmendOrder changes the limit price of the open order id of coin pair
+// c1 and c2. It cancels the order and places a new one of the same type
+// with the quantity not executed until the cancellation, so the fills in
+// between are never bought or sold twice.
+//
+// It fails safe: the replacement is only placed after the cancellation
+// is confirmed, and a failed placement is not retried, as it may have
+// reached the exchange. The errors are *AmendError matching
+// ErrAmendNotCancelled, ErrAmendFilled or ErrAmendNotReplaced. In the
+// last case the order is cancelled and nothing replaces it, so there is
+// never more than one order resting. The *Amend is returned on every
+// path.
+func AmendOrder(api API, c1, c2 Coin, id int, limit string) (*Amend, error) {
+	a := &Amend{}
+	old, err := api.CancelOrder(c1, c2, id)
+	if err != nil || old.Status == OrderStatusOpen {
+		// The cancellation may have been done, or the order filled,
+		// even if the response was lost.
+		o, gerr := api.GetOrder(c1, c2, id)
+		if gerr != nil || o.Status == OrderStatusOpen {
+			if err == nil {
+				err = gerr
+			}
+			return a, &AmendError{Amend: a, Err: ErrAmendNotCancelled, Cause: err}
+		}
+		old = o
+	}
+	a.Cancelled = old
+	if old.Status == OrderStatusFilled {
+		return a, &AmendError{Amend: a, Err: ErrAmendFilled}
+	}
+
+	qt, err := dec.Parse(old.Quantity)
+	if err != nil {
+		return a, &AmendError{Amend: a, Err: ErrAmendNotReplaced, Cause: err}
+	}
+	exec, err := dec.Parse(old.ExecutedQuantity)
+	if err != nil {
+		return a, &AmendError{Amend: a, Err: ErrAmendNotReplaced, Cause: err}
+	}
+	rem := dec.Sub(qt, exec)
+	if rem.Sign() <= 0 {
+		return a, &AmendError{Amend: a, Err: ErrAmendFilled}
+	}
+	prec := decimals(old.Quantity)
+	if p := decimals(old.ExecutedQuantity); p > prec {
+		prec = p
+	}
+	a.Remaining = dec.Format(rem, prec)
+
+	var o *Order
+	if old.Type == OrderTypeSell {
+		o, err = api.PlaceSellOrder(c1, c2, a.Remaining, limit)
+	} else {
+		o, err = api.PlaceBuyOrder(c1, c2, a.Remaining, limit)
+	}
+	if err != nil {
+		return a, &AmendError{Amend: a, Err: ErrAmendNotReplaced, Cause: err}
+	}
+	a.Replacement = o
+	return a, nil
+}
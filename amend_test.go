@@ -0,0 +1,79 @@
+package tapi
+
+import (
+	"errors"
+	"testing"
+)
+
+// amendAPI cancels order 1 returning cancelled, with executed quantity
+// exec, and fails to place when failPlace is set.
+type amendAPI struct {
+	API
+
+	cancelErr error
+	status    int
+	exec      string
+	failPlace bool
+	placed    []string
+}
+
+func (a *amendAPI) CancelOrder(c1, c2 Coin, id int) (*Order, error) {
+	if a.cancelErr != nil {
+		return nil, a.cancelErr
+	}
+	return a.GetOrder(c1, c2, id)
+}
+
+func (a *amendAPI) GetOrder(c1, c2 Coin, id int) (*Order, error) {
+	return &Order{ID: id, Type: OrderTypeSell, Status: a.status,
+		Quantity: "1.00000000", ExecutedQuantity: a.exec, LimitPrice: "100.00000"}, nil
+}
+
+func (a *amendAPI) PlaceSellOrder(c1, c2 Coin, qt, limit string) (*Order, error) {
+	if a.failPlace {
+		return nil, errors.New("connection reset")
+	}
+	a.placed = append(a.placed, qt+"@"+limit)
+	return &Order{ID: 2, Type: OrderTypeSell, Status: OrderStatusOpen, Quantity: qt, LimitPrice: limit}, nil
+}
+
+func TestAmendOrder(t *testing.T) {
+	tests := []struct {
+		name   string
+		api    *amendAPI
+		err    error
+		placed string
+	}{
+		{"not filled", &amendAPI{status: OrderStatusCancelled, exec: "0.00000000"}, nil, "1.00000000@99"},
+		{"partially filled", &amendAPI{status: OrderStatusCancelled, exec: "0.25"}, nil, "0.75000000@99"},
+		{"cancel response lost", &amendAPI{cancelErr: errors.New("timeout"), status: OrderStatusCancelled, exec: "0.5"}, nil, "0.50000000@99"},
+		{"filled", &amendAPI{status: OrderStatusFilled, exec: "1"}, ErrAmendFilled, ""},
+		{"filled on cancel", &amendAPI{status: OrderStatusCancelled, exec: "1.00000000"}, ErrAmendFilled, ""},
+		{"not cancelled", &amendAPI{cancelErr: &Error{Code: 500, Err: "internal"}, status: OrderStatusOpen}, ErrAmendNotCancelled, ""},
+		{"not replaced", &amendAPI{status: OrderStatusCancelled, failPlace: true}, ErrAmendNotReplaced, ""},
+	}
+	for _, tt := range tests {
+		a, err := AmendOrder(tt.api, BRL, BTC, 1, "99")
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: got error %v, expected %v", tt.name, err, tt.err)
+		}
+		if a == nil {
+			t.Errorf("%s: got nil *Amend", tt.name)
+			continue
+		}
+		var aerr *AmendError
+		if err != nil && !errors.As(err, &aerr) {
+			t.Errorf("%s: got error of type %T, expected *AmendError", tt.name, err)
+		}
+		placed := ""
+		if len(tt.api.placed) > 0 {
+			placed = tt.api.placed[0]
+		}
+		if placed != tt.placed || len(tt.api.placed) > 1 {
+			t.Errorf("%s: got placed %v, expected %q", tt.name, tt.api.placed, tt.placed)
+		}
+		if tt.err == nil && (a.Cancelled.ID != 1 || a.Replacement.ID != 2) {
+			t.Errorf("%s: got %+v, expected orders 1 and 2", tt.name, a)
+		}
+	}
+}
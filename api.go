@@ -3,10 +3,13 @@ package tapi
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // ListSystemMessages return the system messages. Use lvl = "" to get all
@@ -91,9 +94,28 @@ type ListOrdersOpts struct {
 
 	// ToTimestamp filter orders created until timestamp (inclusive).
 	ToTimestamp string
+
+	// From and To are like FromTimestamp and ToTimestamp in local time.
+	// A Client with a Clock converts them to the time of the server.
+	// They are ignored if the timestamp strings are set.
+	From time.Time
+	To   time.Time
+}
+
+// timestamps returns the from and to timestamps, with From and To moved
+// by offset.
+func (opts *ListOrdersOpts) timestamps(offset time.Duration) (from, to string) {
+	from, to = opts.FromTimestamp, opts.ToTimestamp
+	if from == "" && !opts.From.IsZero() {
+		from = strconv.FormatInt(opts.From.Add(offset).Unix(), 10)
+	}
+	if to == "" && !opts.To.IsZero() {
+		to = strconv.FormatInt(opts.To.Add(offset).Unix(), 10)
+	}
+	return from, to
 }
 
-func parseOpts(params url.Values, opts *ListOrdersOpts) {
+func parseOpts(params url.Values, opts *ListOrdersOpts, offset time.Duration) {
 	switch t := opts.OrderType; {
 	case t < 0:
 		params.Set("order_type", strconv.Itoa(2))
@@ -126,11 +148,12 @@ func parseOpts(params url.Values, opts *ListOrdersOpts) {
 	if opts.ToID != 0 {
 		params.Set("to_id", strconv.Itoa(opts.ToID))
 	}
-	if opts.FromTimestamp != "" {
-		params.Set("from_timestamp", opts.FromTimestamp)
+	from, to := opts.timestamps(offset)
+	if from != "" {
+		params.Set("from_timestamp", from)
 	}
-	if opts.ToTimestamp != "" {
-		params.Set("to_timestamp", opts.ToTimestamp)
+	if to != "" {
+		params.Set("to_timestamp", to)
 	}
 }
 
@@ -141,7 +164,11 @@ func (c *Client) ListOrders(c1, c2 Coin, opts *ListOrdersOpts) ([]Order, error)
 	params.Set("tapi_method", "list_orders")
 	params.Set("coin_pair", c1.String()+c2.String())
 	if opts != nil {
-		parseOpts(params, opts)
+		var offset time.Duration
+		if c.clock != nil {
+			offset = c.clock.Offset()
+		}
+		parseOpts(params, opts, offset)
 	}
 	resp, err := c.MakeRequest(params)
 	if err != nil {
@@ -150,6 +177,64 @@ func (c *Client) ListOrders(c1, c2 Coin, opts *ListOrdersOpts) ([]Order, error)
 	return unmarshalListOrders(resp.Data)
 }
 
+// maxListOrders is the max number of orders returned by list_orders.
+const maxListOrders = 200
+
+// ListAllOrders walks the order history of coin pair c1 and c2 page by
+// page and returns every order filtered by opts, sorted by ID. The ToID
+// option is used to paginate, so it is only honored on the first page.
+func (c *Client) ListAllOrders(c1, c2 Coin, opts *ListOrdersOpts) ([]Order, error) {
+	return listAllOrders(c, c1, c2, opts)
+}
+
+func listAllOrders(api API, c1, c2 Coin, opts *ListOrdersOpts) ([]Order, error) {
+	var all []Order
+	err := WalkOrders(api, c1, c2, opts, func(orders []Order) error {
+		all = append(all, orders...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].ID < all[j].ID })
+	return all, nil
+}
+
+// OrderLister is the part of API that lists orders.
+type OrderLister interface {
+	ListOrders(c1, c2 Coin, opts *ListOrdersOpts) ([]Order, error)
+}
+
+// WalkOrders calls fn with each page of the orders of coin pair c1 and
+// c2 filtered by opts, the newest page first and each page sorted by
+// ID, so long histories are not held in memory. The ToID option is used
+// to paginate, so it is only honored on the first page. The walk stops
+// at the first error of api or fn.
+func WalkOrders(api OrderLister, c1, c2 Coin, opts *ListOrdersOpts, fn func([]Order) error) error {
+	o := ListOrdersOpts{}
+	if opts != nil {
+		o = *opts
+	}
+	for {
+		orders, err := api.ListOrders(c1, c2, &o)
+		if err != nil {
+			return err
+		}
+		sort.Slice(orders, func(i, j int) bool { return orders[i].ID < orders[j].ID })
+		if err := fn(orders); err != nil {
+			return err
+		}
+		if len(orders) < maxListOrders {
+			return nil
+		}
+		minID := orders[0].ID
+		if minID <= 1 || minID <= o.FromID {
+			return nil
+		}
+		o.ToID = minID - 1
+	}
+}
+
 // ListOrderbook returns the orderbook to the informed coins,
 // if full is true returns at max 500 asks and 500 bids,
 // if full is false returns at max 20 asks and 20 bids.
@@ -170,16 +255,20 @@ func (c *Client) ListOrderbook(c1, c2 Coin, full bool) (*Orderbook, error) {
 // PlaceBuyOrder opens a buy order of coin pair c1 and c2 with quantity qt of
 // digital coin and unit limit price limit.
 func (c *Client) PlaceBuyOrder(c1, c2 Coin, qt, limit string) (*Order, error) {
-	return c.placeOrder(c1, c2, "place_buy_order", qt, limit)
+	return c.placeOrder(c1, c2, "place_buy_order", true, qt, limit)
 }
 
 // PlaceSellOrder opens a sell order of coin pair c1 and c2 with quantity qt of
 // digital coin and unit limit price limit.
 func (c *Client) PlaceSellOrder(c1, c2 Coin, qt, limit string) (*Order, error) {
-	return c.placeOrder(c1, c2, "place_sell_order", qt, limit)
+	return c.placeOrder(c1, c2, "place_sell_order", false, qt, limit)
 }
 
-func (c *Client) placeOrder(c1, c2 Coin, method, qt, limit string) (*Order, error) {
+func (c *Client) placeOrder(c1, c2 Coin, method string, buy bool, qt, limit string) (*Order, error) {
+	qt, limit, _, err := c.checkOrder(c1, c2, buy, qt, limit, "")
+	if err != nil {
+		return nil, err
+	}
 	params := make(url.Values)
 	params.Set("tapi_method", method)
 	params.Set("coin_pair", c1.String()+c2.String())
@@ -195,6 +284,10 @@ func (c *Client) placeOrder(c1, c2 Coin, method, qt, limit string) (*Order, erro
 // PlaceMarketBuyOrder opens a buy order of coin pair c1 and c2 with limit
 // volume cost in BRL.
 func (c *Client) PlaceMarketBuyOrder(c1, c2 Coin, cost string) (*Order, error) {
+	_, _, cost, err := c.checkOrder(c1, c2, true, "", "", cost)
+	if err != nil {
+		return nil, err
+	}
 	params := make(url.Values)
 	params.Set("tapi_method", "place_market_buy_order")
 	params.Set("coin_pair", c1.String()+c2.String())
@@ -209,6 +302,10 @@ func (c *Client) PlaceMarketBuyOrder(c1, c2 Coin, cost string) (*Order, error) {
 // PlaceMarketSellOrder opens a sell order of coin pair c1 and c2 with qt
 // quantity of digital coin.
 func (c *Client) PlaceMarketSellOrder(c1, c2 Coin, qt string) (*Order, error) {
+	qt, _, _, err := c.checkOrder(c1, c2, false, qt, "", "")
+	if err != nil {
+		return nil, err
+	}
 	params := make(url.Values)
 	params.Set("tapi_method", "place_market_sell_order")
 	params.Set("coin_pair", c1.String()+c2.String())
@@ -283,7 +380,9 @@ func (c *Client) WithdrawBRL(desc, qt, accRef string) (*Withdrawal, error) {
 }
 
 // WithdrawCrypto requests a digital coin transfer order with coin,
-// description and withdraw info.
+// description and withdraw info. The address is validated with
+// ValidateAddress before the request is made, and XRP withdrawals to
+// the addresses of RequireDestinationTag need a destination tag.
 func (c *Client) WithdrawCrypto(coin Coin, desc string, i *WithdrawInfo) (*Withdrawal, error) {
 	if i == nil {
 		return nil, errors.New("nil WithdrawInfo")
@@ -291,6 +390,14 @@ func (c *Client) WithdrawCrypto(coin Coin, desc string, i *WithdrawInfo) (*Withd
 	if coin == BRL {
 		return nil, errors.New("use WithdrawBRL for BRL")
 	}
+	if err := ValidateAddress(coin, i.Address); err != nil {
+		return nil, err
+	}
+	if coin == XRP {
+		if err := validateDestinationTag(i.Address, i.DestinationTag); err != nil {
+			return nil, err
+		}
+	}
 	params := make(url.Values)
 	params.Set("address", i.Address)
 	params.Set("quantity", i.Quantity)
@@ -338,36 +445,96 @@ func (e *Error) Is(target error) bool {
 	return (e.Code == t.Code || t.Code == 0)
 }
 
+// AmbiguousError is returned when the outcome of a request is unknown,
+// as when the exchange fails with a 5xx status after the request was
+// received. Err is the cause, an *Error for the HTTP statuses.
+type AmbiguousError struct {
+	Err error
+}
+
+func (e *AmbiguousError) Error() string { return e.Err.Error() }
+
+func (e *AmbiguousError) Unwrap() error { return e.Err }
+
+// Ambiguous reports whether a request that failed with err may still
+// have been executed by the exchange, as on a timeout. The errors
+// returned by the exchange and the validation errors are not, unless
+// they are an *AmbiguousError, nor the requests not sent for lack of
+// credentials or an open circuit.
+func Ambiguous(err error) bool {
+	var aerr *AmbiguousError
+	if errors.As(err, &aerr) {
+		return true
+	}
+	var terr *Error
+	var verr *ValidationError
+	return err != nil && !errors.As(err, &terr) && !errors.As(err, &verr) &&
+		!errors.Is(err, ErrNoCredentials) && !errors.Is(err, ErrCircuitOpen)
+}
+
 // MakeRequest create and make a request with nonce, ID, MAC and params
 // to c.service.
 func (c *Client) MakeRequest(params url.Values) (*Response, error) {
+	creds, err := c.credentials()
+	if err != nil {
+		return nil, err
+	}
+	if c.breaker != nil {
+		done, err := c.breaker.allow(MethodClass(params.Get("tapi_method")))
+		if err != nil {
+			return nil, err
+		}
+		resp, failure, err := c.send(creds, params)
+		done(failure)
+		return resp, err
+	}
+	resp, _, err := c.send(creds, params)
+	return resp, err
+}
+
+// send makes the request. The failure is the error that counts to the
+// circuit breaker: a transport error, a 5xx response or invalid JSON.
+func (c *Client) send(creds Credentials, params url.Values) (resp *Response, failure, err error) {
+	if c.limiter != nil {
+		c.limiter.Wait()
+	}
 	params.Add("tapi_nonce", c.Nonce())
 	e := params.Encode()
 
 	r, err := http.NewRequest("POST", c.service, strings.NewReader(e))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	mac := c.Hmac(r.URL.Path + "?" + e)
+	mac := sign(creds.Key, r.URL.Path+"?"+e)
 	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	r.Header.Set("TAPI-ID", c.apiID)
+	r.Header.Set("TAPI-ID", creds.ID)
 	r.Header.Set("TAPI-MAC", mac)
 
-	resp, err := c.client.Do(r)
+	sent := time.Now()
+	hresp, err := c.client.Do(r)
 	if err != nil {
-		return nil, err
+		return nil, err, err
+	}
+	defer hresp.Body.Close()
+	if hresp.StatusCode >= 500 {
+		failure = fmt.Errorf("http status %d", hresp.StatusCode)
 	}
-	defer resp.Body.Close()
 
 	response := &Response{}
-	err = json.NewDecoder(resp.Body).Decode(response)
+	err = json.NewDecoder(hresp.Body).Decode(response)
 	if err != nil {
-		return nil, err
+		if failure == nil {
+			failure = err
+		}
+		return nil, failure, err
+	}
+	if c.clock != nil {
+		c.clock.Observe(sent, time.Now(), response.ServerUnixTimestamp)
 	}
 	if response.StatusCode != 100 {
 		err := &Error{Code: response.StatusCode, Err: response.ErrorMessage}
-		return nil, err
+		return nil, failure, err
 	}
-	return response, nil
+	return response, failure, nil
 }
@@ -227,6 +227,42 @@ func tListOrders(r *http.Request, strs ...string) string {
 	return ""
 }
 
+func TestListAllOrders(t *testing.T) {
+	const total = 450
+	calls := 0
+	f := func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		toID := total
+		if v := r.FormValue("to_id"); v != "" {
+			toID, _ = strconv.Atoi(v)
+		}
+		var orders []Order
+		for id := toID; id > 0 && len(orders) < maxListOrders; id-- {
+			orders = append(orders, Order{ID: id, CoinPair: "BRLBTC"})
+		}
+		data, _ := json.Marshal(listOrdersResponse{Orders: orders})
+		json.NewEncoder(w).Encode(&Response{Data: data, StatusCode: 100})
+	}
+	srv := httptest.NewServer(http.HandlerFunc(f))
+	defer srv.Close()
+	c := NewClient(srv.URL, fakeID, fakeKey, nil)
+	orders, err := c.ListAllOrders(BRL, BTC, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != total {
+		t.Fatalf("got %d orders, expected %d", len(orders), total)
+	}
+	for i, o := range orders {
+		if o.ID != i+1 {
+			t.Fatalf("got order %d at %d, expected %d", o.ID, i, i+1)
+		}
+	}
+	if calls != 3 {
+		t.Errorf("got %d calls, expected %d", calls, 3)
+	}
+}
+
 func TestListOrderbook(t *testing.T) {
 	tests := []struct {
 		c1   Coin
@@ -547,14 +583,14 @@ func TestWithdrawlCrypto(t *testing.T) {
 			"destination_tag", "",
 		}},
 		{XRP, "hello", &WithdrawInfo{
-			Address:        "18d2ogsrMXsspcxzz3DgecePNdxcZUpaUY",
+			Address:        "rPT1Sjq2YGrBMTttX4GZHjKu9dyfzbpAYe",
 			Quantity:       "0.9",
 			TxFee:          "0.08",
 			TxNotAggregate: true,
 			DestinationTag: 20,
 		}, []string{
 			"coin", "XRP",
-			"address", "18d2ogsrMXsspcxzz3DgecePNdxcZUpaUY",
+			"address", "rPT1Sjq2YGrBMTttX4GZHjKu9dyfzbpAYe",
 			"description", "hello",
 			"quantity", "0.9",
 			"account_ref", "",
@@ -582,6 +618,32 @@ func TestWithdrawlCrypto(t *testing.T) {
 	}
 }
 
+func TestWithdrawCryptoInvalidAddress(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer srv.Close()
+	c := NewClient(srv.URL, fakeID, fakeKey, nil)
+	tests := []struct {
+		coin Coin
+		i    *WithdrawInfo
+		err  error
+	}{
+		{BTC, &WithdrawInfo{Address: "18d2ogsrMXsspcxzz3DgecePNdxcZUpaUY"}, ErrAddressChecksum},
+		{XRP, &WithdrawInfo{Address: "rPT1Sjq2YGrBMTttX4GZHjKu9dyfzbpAYe", DestinationTag: -1}, ErrDestinationTag},
+	}
+	for _, tt := range tests {
+		_, err := c.WithdrawCrypto(tt.coin, "", tt.i)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("got error %v, expected %v", err, tt.err)
+		}
+	}
+	if calls != 0 {
+		t.Errorf("got %d requests with invalid address", calls)
+	}
+}
+
 func tWithdrawCoin(r *http.Request, strs ...string) string {
 	if r.FormValue("tapi_method") != "withdraw_coin" {
 		return "invalid method"
@@ -0,0 +1,147 @@
+// Package audit records the mutating calls of a tapi.API in a
+// tamper-evident journal.
+//
+// Every order placement, cancellation and withdrawal, successful or not,
+// is appended to a Journal with its params, response, caller and time.
+// Each entry holds the hash of the previous one, so editing, removing or
+// reordering entries is detected by Verify.
+package audit
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	tapi "github.com/rschio/mb-tapi"
+)
+
+// API is a tapi.API that records its mutating calls.
+type API struct {
+	tapi.API
+
+	journal *Journal
+	caller  string
+	now     func() time.Time
+}
+
+// New creates an API that records the calls of caller to api in j.
+func New(api tapi.API, j *Journal, caller string) *API {
+	return &API{API: api, journal: j, caller: caller, now: time.Now}
+}
+
+// WithCaller returns an API that shares the journal and records the
+// calls as made by caller.
+func (a *API) WithCaller(caller string) *API {
+	c := *a
+	c.caller = caller
+	return &c
+}
+
+func (a *API) record(method string, params map[string]string, o *tapi.Order, w *tapi.Withdrawal, err error) error {
+	e := &Entry{
+		Time:       a.now().UTC().Format(time.RFC3339Nano),
+		Caller:     a.caller,
+		Method:     method,
+		Params:     params,
+		Order:      o,
+		Withdrawal: w,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if jerr := a.journal.Append(e); jerr != nil {
+		if err != nil {
+			return fmt.Errorf("%w (audit: %v)", err, jerr)
+		}
+		return fmt.Errorf("audit: %s done but not recorded: %v", method, jerr)
+	}
+	return err
+}
+
+func (a *API) order(method string, params map[string]string, o *tapi.Order, err error) (*tapi.Order, error) {
+	err = a.record(method, params, o, nil, err)
+	return o, err
+}
+
+func pair(c1, c2 tapi.Coin) string { return c1.String() + c2.String() }
+
+// normalize returns the order params as sent by api, if it is a
+// tapi.Normalizer, so the journal has the rounded values. Params that
+// fail validation are recorded as given.
+func (a *API) normalize(c1, c2 tapi.Coin, buy bool, qt, limit, cost string) (string, string, string) {
+	if n, ok := a.API.(tapi.Normalizer); ok {
+		if q, l, c, err := n.NormalizeOrder(c1, c2, buy, qt, limit, cost); err == nil {
+			return q, l, c
+		}
+	}
+	return qt, limit, cost
+}
+
+// PlaceBuyOrder places and records a buy order.
+func (a *API) PlaceBuyOrder(c1, c2 tapi.Coin, qt, limit string) (*tapi.Order, error) {
+	o, err := a.API.PlaceBuyOrder(c1, c2, qt, limit)
+	qt, limit, _ = a.normalize(c1, c2, true, qt, limit, "")
+	return a.order("place_buy_order", map[string]string{
+		"coin_pair": pair(c1, c2), "quantity": qt, "limit_price": limit,
+	}, o, err)
+}
+
+// PlaceSellOrder places and records a sell order.
+func (a *API) PlaceSellOrder(c1, c2 tapi.Coin, qt, limit string) (*tapi.Order, error) {
+	o, err := a.API.PlaceSellOrder(c1, c2, qt, limit)
+	qt, limit, _ = a.normalize(c1, c2, false, qt, limit, "")
+	return a.order("place_sell_order", map[string]string{
+		"coin_pair": pair(c1, c2), "quantity": qt, "limit_price": limit,
+	}, o, err)
+}
+
+// PlaceMarketBuyOrder places and records a market buy order.
+func (a *API) PlaceMarketBuyOrder(c1, c2 tapi.Coin, cost string) (*tapi.Order, error) {
+	o, err := a.API.PlaceMarketBuyOrder(c1, c2, cost)
+	_, _, cost = a.normalize(c1, c2, true, "", "", cost)
+	return a.order("place_market_buy_order", map[string]string{
+		"coin_pair": pair(c1, c2), "cost": cost,
+	}, o, err)
+}
+
+// PlaceMarketSellOrder places and records a market sell order.
+func (a *API) PlaceMarketSellOrder(c1, c2 tapi.Coin, qt string) (*tapi.Order, error) {
+	o, err := a.API.PlaceMarketSellOrder(c1, c2, qt)
+	qt, _, _ = a.normalize(c1, c2, false, qt, "", "")
+	return a.order("place_market_sell_order", map[string]string{
+		"coin_pair": pair(c1, c2), "quantity": qt,
+	}, o, err)
+}
+
+// CancelOrder cancels and records the cancellation of an order.
+func (a *API) CancelOrder(c1, c2 tapi.Coin, id int) (*tapi.Order, error) {
+	o, err := a.API.CancelOrder(c1, c2, id)
+	return a.order("cancel_order", map[string]string{
+		"coin_pair": pair(c1, c2), "order_id": strconv.Itoa(id),
+	}, o, err)
+}
+
+// WithdrawBRL requests and records a BRL withdrawal.
+func (a *API) WithdrawBRL(desc, qt, accRef string) (*tapi.Withdrawal, error) {
+	w, err := a.API.WithdrawBRL(desc, qt, accRef)
+	err = a.record("withdraw_coin", map[string]string{
+		"coin": "BRL", "description": desc, "quantity": qt, "account_ref": accRef,
+	}, nil, w, err)
+	return w, err
+}
+
+// WithdrawCrypto requests and records a digital coin transfer.
+func (a *API) WithdrawCrypto(coin tapi.Coin, desc string, i *tapi.WithdrawInfo) (*tapi.Withdrawal, error) {
+	w, err := a.API.WithdrawCrypto(coin, desc, i)
+	params := map[string]string{"coin": coin.String(), "description": desc}
+	if i != nil {
+		params["address"] = i.Address
+		params["quantity"] = i.Quantity
+		params["tx_fee"] = i.TxFee
+		if coin == tapi.XRP {
+			params["destination_tag"] = strconv.Itoa(i.DestinationTag)
+		}
+	}
+	err = a.record("withdraw_coin", params, nil, w, err)
+	return w, err
+}
@@ -0,0 +1,165 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tapi "github.com/rschio/mb-tapi"
+)
+
+type fakeAPI struct {
+	tapi.API
+}
+
+func (f *fakeAPI) PlaceBuyOrder(c1, c2 tapi.Coin, qt, limit string) (*tapi.Order, error) {
+	return &tapi.Order{ID: 1, Quantity: qt, LimitPrice: limit}, nil
+}
+
+func (f *fakeAPI) CancelOrder(c1, c2 tapi.Coin, id int) (*tapi.Order, error) {
+	return nil, &tapi.Error{Code: 207, Err: "order not found"}
+}
+
+func (f *fakeAPI) WithdrawCrypto(coin tapi.Coin, desc string, i *tapi.WithdrawInfo) (*tapi.Withdrawal, error) {
+	return &tapi.Withdrawal{ID: 9, Coin: coin.String(), Quantity: i.Quantity}, nil
+}
+
+func newTestJournal(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "journal"), func() { os.RemoveAll(dir) }
+}
+
+func writeEntries(t *testing.T, path string) {
+	j, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	a := New(&fakeAPI{}, j, "bot")
+	if _, err := a.PlaceBuyOrder(tapi.BRL, tapi.BTC, "0.1", "100"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.CancelOrder(tapi.BRL, tapi.BTC, 5); !errors.Is(err, &tapi.Error{Code: 207}) {
+		t.Fatalf("got error %v, expected code 207", err)
+	}
+	i := &tapi.WithdrawInfo{Address: "addr", Quantity: "1"}
+	if _, err := a.WithCaller("treasury").WithdrawCrypto(tapi.BTC, "cold", i); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJournal(t *testing.T) {
+	path, done := newTestJournal(t)
+	defer done()
+	writeEntries(t, path)
+	// Reopening continues the chain.
+	writeEntries(t, path)
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, head, err := Verify(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 6 || head == "" {
+		t.Errorf("got %d entries and head %q, expected 6", n, head)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	for _, s := range []string{`"caller":"bot"`, `"method":"place_buy_order"`, `"limit_price":"100"`} {
+		if !strings.Contains(lines[0], s) {
+			t.Errorf("entry 1 does not contain %s: %s", s, lines[0])
+		}
+	}
+	if !strings.Contains(lines[1], `"error":"order not found"`) {
+		t.Errorf("entry 2 has no error: %s", lines[1])
+	}
+	if !strings.Contains(lines[2], `"caller":"treasury"`) || !strings.Contains(lines[2], `"withdrawal":{"id":9`) {
+		t.Errorf("entry 3 is not the withdrawal of treasury: %s", lines[2])
+	}
+
+	tests := []struct {
+		name  string
+		lines []string
+		err   error
+		line  int
+	}{
+		{"edited", []string{lines[0], strings.Replace(lines[1], "order not found", "ok", 1), lines[2]}, ErrTampered, 2},
+		{"removed", []string{lines[0], lines[2]}, ErrGap, 2},
+		{"reordered", []string{lines[1], lines[0]}, ErrGap, 1},
+		{"invalid", []string{lines[0], "{"}, ErrTampered, 2},
+	}
+	for _, tt := range tests {
+		_, _, err := Verify(strings.NewReader(strings.Join(tt.lines, "\n")))
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: got error %v, expected %v", tt.name, err, tt.err)
+		}
+		var verr *VerifyError
+		if errors.As(err, &verr) && verr.Line != tt.line {
+			t.Errorf("%s: got line %d, expected %d", tt.name, verr.Line, tt.line)
+		}
+	}
+
+	// A rehashed edit breaks the link of the next entry.
+	forged := strings.Replace(lines[0], `"limit_price":"100"`, `"limit_price":"1"`, 1)
+	if err := ioutil.WriteFile(path, []byte(rehash(t, forged)+"\n"+lines[1]+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenJournal(path); !errors.Is(err, ErrTampered) {
+		t.Errorf("got error %v, expected %v", err, ErrTampered)
+	}
+}
+
+func rehash(t *testing.T, line string) string {
+	var r record
+	if err := json.Unmarshal([]byte(line), &r); err != nil {
+		t.Fatal(err)
+	}
+	r.Hash = sum(r.Entry)
+	b, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// roundingAPI normalizes the params like a client with WithRounding.
+type roundingAPI struct {
+	fakeAPI
+}
+
+func (r *roundingAPI) NormalizeOrder(c1, c2 tapi.Coin, buy bool, qt, limit, cost string) (string, string, string, error) {
+	return "0.1000", "99.95", cost, nil
+}
+
+func TestNormalizedParams(t *testing.T) {
+	path, done := newTestJournal(t)
+	defer done()
+	j, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	a := New(&roundingAPI{}, j, "bot")
+	if _, err := a.PlaceBuyOrder(tapi.BRL, tapi.BTC, "0.10004", "99.97"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{`"quantity":"0.1000"`, `"limit_price":"99.95"`} {
+		if !strings.Contains(string(b), s) {
+			t.Errorf("entry does not contain %s: %s", s, b)
+		}
+	}
+}
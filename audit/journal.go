@@ -0,0 +1,195 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	tapi "github.com/rschio/mb-tapi"
+)
+
+// Entry is a record of the journal.
+type Entry struct {
+	// Seq is the position of the entry, starting at 1.
+	Seq int64 `json:"seq"`
+
+	// Time is the time of the response in RFC 3339 format.
+	Time string `json:"time"`
+
+	// Caller identifies who made the call.
+	Caller string `json:"caller"`
+
+	// Method is the tapi method, such as "place_buy_order".
+	Method string `json:"method"`
+
+	// Params are the params of the call.
+	Params map[string]string `json:"params"`
+
+	// Order or Withdrawal is the response of a successful call, and
+	// Error the error of a failed one.
+	Order      *tapi.Order      `json:"order,omitempty"`
+	Withdrawal *tapi.Withdrawal `json:"withdrawal,omitempty"`
+	Error      string           `json:"error,omitempty"`
+
+	// Prev is the hash of the previous entry, empty in the first one.
+	Prev string `json:"prev"`
+
+	// Hash is the hash of the entry, set by Journal.Append.
+	Hash string `json:"-"`
+}
+
+// record is a line of the journal. The hash is the SHA-256 of the JSON
+// of the entry as written, so it does not depend on how Entry is
+// encoded by later versions.
+type record struct {
+	Entry json.RawMessage `json:"entry"`
+	Hash  string          `json:"hash"`
+}
+
+func sum(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// ParseLine decodes a line of the journal without verifying it.
+func ParseLine(line []byte) (*Entry, error) {
+	var r record
+	if err := json.Unmarshal(line, &r); err != nil {
+		return nil, err
+	}
+	e := &Entry{}
+	if err := json.Unmarshal(r.Entry, e); err != nil {
+		return nil, err
+	}
+	e.Hash = r.Hash
+	return e, nil
+}
+
+var (
+	// ErrTampered is returned when an entry does not match its hash
+	// or the hash of the previous entry.
+	ErrTampered = errors.New("audit: entry tampered")
+
+	// ErrGap is returned when an entry is missing.
+	ErrGap = errors.New("audit: missing entry")
+)
+
+// VerifyError is the first invalid entry found by Verify.
+type VerifyError struct {
+	Line int
+	Seq  int64
+	Err  error
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("line %d (seq %d): %v", e.Line, e.Seq, e.Err)
+}
+
+func (e *VerifyError) Unwrap() error { return e.Err }
+
+// Verify checks the hash chain and the sequence of the journal in r. It
+// returns the number of entries and the hash of the last one. Removing
+// entries from the end of a journal keeps a valid chain, so the head
+// should be compared with a copy kept elsewhere.
+func Verify(r io.Reader) (n int64, head string, err error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 16<<20)
+	line := 0
+	for s.Scan() {
+		line++
+		var r record
+		var e struct {
+			Seq  int64  `json:"seq"`
+			Prev string `json:"prev"`
+		}
+		err := json.Unmarshal(s.Bytes(), &r)
+		if err == nil {
+			err = json.Unmarshal(r.Entry, &e)
+		}
+		if err != nil {
+			return n, head, &VerifyError{Line: line, Err: fmt.Errorf("%w: %v", ErrTampered, err)}
+		}
+		if e.Seq != n+1 {
+			return n, head, &VerifyError{Line: line, Seq: e.Seq,
+				Err: fmt.Errorf("%w: expected seq %d", ErrGap, n+1)}
+		}
+		if e.Prev != head {
+			return n, head, &VerifyError{Line: line, Seq: e.Seq,
+				Err: fmt.Errorf("%w: previous hash does not match", ErrTampered)}
+		}
+		if sum(r.Entry) != r.Hash {
+			return n, head, &VerifyError{Line: line, Seq: e.Seq,
+				Err: fmt.Errorf("%w: hash does not match", ErrTampered)}
+		}
+		n, head = e.Seq, r.Hash
+	}
+	return n, head, s.Err()
+}
+
+// Journal is an append-only file of hash-chained entries. It is safe for
+// concurrent use.
+type Journal struct {
+	mu   sync.Mutex
+	f    *os.File
+	seq  int64
+	head string
+}
+
+// OpenJournal opens the journal in path, creating it if needed. The
+// existing entries are verified before any new entry is appended.
+func OpenJournal(path string) (*Journal, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	n, head, err := Verify(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("audit: %s: %w", path, err)
+	}
+	return &Journal{f: f, seq: n, head: head}, nil
+}
+
+// Append chains e to the last entry and writes it. Seq, Prev and Hash
+// of e are set by Append.
+func (j *Journal) Append(e *Entry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e.Seq = j.seq + 1
+	e.Prev = j.head
+	raw, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	e.Hash = sum(raw)
+	b, err := json.Marshal(record{Entry: raw, Hash: e.Hash})
+	if err != nil {
+		return err
+	}
+	if _, err := j.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	if err := j.f.Sync(); err != nil {
+		return err
+	}
+	j.seq, j.head = e.Seq, e.Hash
+	return nil
+}
+
+// Head returns the number of entries and the hash of the last one.
+func (j *Journal) Head() (int64, string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.seq, j.head
+}
+
+// Close closes the file of the journal.
+func (j *Journal) Close() error {
+	return j.f.Close()
+}
@@ -0,0 +1,775 @@
+// Package backtest runs strategies written against tapi.API over
+// recorded order book snapshots and public trades.
+//
+// Orders are filled against the recorded book. Taker fills walk the
+// opposite side of the current snapshot, and resting limit orders keep a
+// queue position that is consumed by public trades at their price
+// before they are filled as maker. The buy fee is charged in the
+// digital coin and the sell fee in BRL, as the exchange does.
+package backtest
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"time"
+
+	tapi "github.com/rschio/mb-tapi"
+	"github.com/rschio/mb-tapi/internal/dec"
+)
+
+var (
+	// ErrInsufficientBalance is returned when an order needs more
+	// than the available balance.
+	ErrInsufficientBalance = errors.New("backtest: insufficient balance")
+
+	// ErrOrderNotFound is returned when an order ID is unknown.
+	ErrOrderNotFound = errors.New("backtest: order not found")
+
+	// ErrNoOrderbook is returned when an order is placed on a pair
+	// without a recorded order book yet.
+	ErrNoOrderbook = errors.New("backtest: no orderbook for pair")
+
+	// ErrInvalidPrice is returned when a price of the market data is
+	// not positive.
+	ErrInvalidPrice = errors.New("backtest: price must be positive")
+
+	// ErrNoLiquidity is returned when a market order is placed on an
+	// empty side of the book.
+	ErrNoLiquidity = errors.New("backtest: no liquidity in orderbook")
+
+	// ErrUnsupported is returned by the withdrawal methods.
+	ErrUnsupported = errors.New("backtest: unsupported method")
+)
+
+// Config is the initial state of a backtest.
+type Config struct {
+	// Balance is the initial balance of each coin, such as "1000.00".
+	Balance map[tapi.Coin]string
+
+	// MakerFee and TakerFee are the fee rates in percent, in the
+	// format of Operation.FeeRate, such as "0.30".
+	MakerFee string
+	TakerFee string
+}
+
+// Strategy is the code under test.
+type Strategy interface {
+	// Step is called after each event is replayed, with the time of
+	// the event. Returning an error stops the backtest.
+	Step(api tapi.API, now time.Time) error
+}
+
+// StrategyFunc is an adapter to use a function as a Strategy.
+type StrategyFunc func(api tapi.API, now time.Time) error
+
+// Step calls f(api, now).
+func (f StrategyFunc) Step(api tapi.API, now time.Time) error { return f(api, now) }
+
+// Backtest replays events and simulates the exchange. It implements
+// tapi.API and is not safe for concurrent use.
+type Backtest struct {
+	events   []Event
+	maker    *big.Rat
+	taker    *big.Rat
+	makerStr string
+	takerStr string
+
+	now    time.Time
+	books  map[string]*book
+	total  map[tapi.Coin]*big.Rat
+	locked map[tapi.Coin]*big.Rat
+	orders []*order
+	opID   int
+
+	stats stats
+}
+
+var _ tapi.API = (*Backtest)(nil)
+
+type level struct {
+	info  tapi.OrderInfo
+	qt    *big.Rat
+	price *big.Rat
+}
+
+type book struct {
+	bids   []level
+	asks   []level
+	latest int
+}
+
+type order struct {
+	tapi.Order
+	c1, c2   tapi.Coin
+	buy      bool
+	qt       *big.Rat
+	limit    *big.Rat // nil on market orders.
+	executed *big.Rat
+	value    *big.Rat
+	fee      *big.Rat
+	ahead    *big.Rat // quantity ahead in the queue.
+}
+
+// New creates a backtest that replays events with the state in cfg.
+func New(cfg Config, events []Event) (*Backtest, error) {
+	b := &Backtest{
+		events:   events,
+		makerStr: cfg.MakerFee,
+		takerStr: cfg.TakerFee,
+		books:    make(map[string]*book),
+		total:    make(map[tapi.Coin]*big.Rat),
+		locked:   make(map[tapi.Coin]*big.Rat),
+		stats:    newStats(),
+	}
+	var err error
+	if b.maker, err = dec.Parse(cfg.MakerFee); err != nil {
+		return nil, fmt.Errorf("backtest: maker fee: %v", err)
+	}
+	if b.taker, err = dec.Parse(cfg.TakerFee); err != nil {
+		return nil, fmt.Errorf("backtest: taker fee: %v", err)
+	}
+	for _, c := range tapi.Coins {
+		b.total[c] = dec.Zero()
+		b.locked[c] = dec.Zero()
+	}
+	for c, s := range cfg.Balance {
+		v, err := dec.Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("backtest: balance of %v: %v", c, err)
+		}
+		b.total[c] = v
+	}
+	if err := prepare(b.events); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Run replays the events calling s after each one, and returns the
+// statistics of the run.
+func (b *Backtest) Run(s Strategy) (*Result, error) {
+	for i := range b.events {
+		ev := &b.events[i]
+		b.now = ev.t
+		var err error
+		if ev.Orderbook != nil {
+			err = b.replayBook(ev)
+		} else {
+			err = b.replayTrade(ev)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := s.Step(b, b.now); err != nil {
+			return nil, err
+		}
+		b.stats.record(b.now, b.equity())
+	}
+	return b.stats.result(), nil
+}
+
+func pairOf(c1, c2 tapi.Coin) string { return c1.String() + c2.String() }
+
+// parseLevels parses the levels of a book. Levels without quantity are
+// dropped, so no fill is empty.
+func parseLevels(infos []tapi.OrderInfo) ([]level, error) {
+	ls := make([]level, 0, len(infos))
+	for _, info := range infos {
+		qt, err := dec.Parse(info.Quantity)
+		if err != nil {
+			return nil, err
+		}
+		price, err := dec.Parse(info.LimitPrice)
+		if err != nil {
+			return nil, err
+		}
+		if price.Sign() <= 0 {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidPrice, info.LimitPrice)
+		}
+		if qt.Sign() <= 0 {
+			continue
+		}
+		ls = append(ls, level{info: info, qt: qt, price: price})
+	}
+	return ls, nil
+}
+
+func (b *Backtest) replayBook(ev *Event) error {
+	bids, err := parseLevels(ev.Orderbook.Bids)
+	if err != nil {
+		return fmt.Errorf("backtest: orderbook at %s: %w", ev.Timestamp, err)
+	}
+	asks, err := parseLevels(ev.Orderbook.Asks)
+	if err != nil {
+		return fmt.Errorf("backtest: orderbook at %s: %w", ev.Timestamp, err)
+	}
+	bk := &book{bids: bids, asks: asks, latest: ev.Orderbook.LatestOrderID}
+	b.books[ev.Pair] = bk
+	for _, o := range b.openOrders(ev.c1, ev.c2) {
+		// Orders of the new snapshot that cross a resting order
+		// would have matched it.
+		opposite := &bk.asks
+		if !o.buy {
+			opposite = &bk.bids
+		}
+		b.take(o, opposite, o.limit, false)
+		if o.Status != tapi.OrderStatusOpen {
+			continue
+		}
+		// The queue ahead only shrinks while the order rests.
+		same := sumAt(bk.bids, o.limit)
+		if !o.buy {
+			same = sumAt(bk.asks, o.limit)
+		}
+		if same.Cmp(o.ahead) < 0 {
+			o.ahead = same
+		}
+	}
+	return nil
+}
+
+func (b *Backtest) replayTrade(ev *Event) error {
+	price, err := dec.Parse(ev.Trade.Price)
+	if err != nil {
+		return fmt.Errorf("backtest: trade at %s: %v", ev.Timestamp, err)
+	}
+	if price.Sign() <= 0 {
+		return fmt.Errorf("backtest: trade at %s: %w: %s", ev.Timestamp, ErrInvalidPrice, ev.Trade.Price)
+	}
+	amount, err := dec.Parse(ev.Trade.Amount)
+	if err != nil {
+		return fmt.Errorf("backtest: trade at %s: %v", ev.Timestamp, err)
+	}
+	// A taker sell hits the buys and a taker buy lifts the sells.
+	buy := ev.Trade.Type == "sell"
+	var os []*order
+	for _, o := range b.openOrders(ev.c1, ev.c2) {
+		if o.buy != buy {
+			continue
+		}
+		if (buy && o.limit.Cmp(price) >= 0) || (!buy && o.limit.Cmp(price) <= 0) {
+			os = append(os, o)
+		}
+	}
+	sort.SliceStable(os, func(i, j int) bool {
+		if buy {
+			return os[i].limit.Cmp(os[j].limit) > 0
+		}
+		return os[i].limit.Cmp(os[j].limit) < 0
+	})
+	for _, o := range os {
+		if amount.Sign() <= 0 {
+			break
+		}
+		if o.limit.Cmp(price) == 0 {
+			// At our price the queue ahead is filled first.
+			if amount.Cmp(o.ahead) <= 0 {
+				o.ahead = dec.Sub(o.ahead, amount)
+				break
+			}
+			amount = dec.Sub(amount, o.ahead)
+			o.ahead = dec.Zero()
+		}
+		qt := minRat(dec.Sub(o.qt, o.executed), amount)
+		b.fill(o, qt, o.limit, false)
+		amount = dec.Sub(amount, qt)
+	}
+	return nil
+}
+
+func (b *Backtest) openOrders(c1, c2 tapi.Coin) []*order {
+	var os []*order
+	for _, o := range b.orders {
+		if o.Status == tapi.OrderStatusOpen && o.c1 == c1 && o.c2 == c2 {
+			os = append(os, o)
+		}
+	}
+	return os
+}
+
+// take fills o against the levels while their price is not worse than
+// limit, nil limit means any price. Taken liquidity is removed from the
+// levels. Maker fills are done at the order limit price.
+func (b *Backtest) take(o *order, levels *[]level, limit *big.Rat, taker bool) {
+	ls := *levels
+	for len(ls) > 0 && o.executed.Cmp(o.qt) < 0 {
+		l := &ls[0]
+		if limit != nil {
+			if o.buy && l.price.Cmp(limit) > 0 || !o.buy && l.price.Cmp(limit) < 0 {
+				break
+			}
+		}
+		qt := minRat(l.qt, dec.Sub(o.qt, o.executed))
+		price := l.price
+		if !taker {
+			price = o.limit
+		}
+		b.fill(o, qt, price, taker)
+		l.qt = dec.Sub(l.qt, qt)
+		l.info.Quantity = dec.Format(l.qt, 8)
+		if l.qt.Sign() == 0 {
+			ls = ls[1:]
+		}
+	}
+	*levels = ls
+}
+
+func (b *Backtest) fill(o *order, qt, price *big.Rat, taker bool) {
+	rate, rateStr := b.maker, b.makerStr
+	if taker {
+		rate, rateStr = b.taker, b.takerStr
+	}
+	value := dec.Mul(qt, price)
+	feeBRL := dec.Percent(value, rate)
+	if o.buy {
+		fee := dec.Percent(qt, rate)
+		b.total[o.c1].Sub(b.total[o.c1], value)
+		if o.limit != nil {
+			b.locked[o.c1].Sub(b.locked[o.c1], dec.Mul(qt, o.limit))
+		}
+		b.total[o.c2].Add(b.total[o.c2], dec.Sub(qt, fee))
+		o.fee.Add(o.fee, fee)
+	} else {
+		b.total[o.c2].Sub(b.total[o.c2], qt)
+		if o.limit != nil {
+			b.locked[o.c2].Sub(b.locked[o.c2], qt)
+		}
+		b.total[o.c1].Add(b.total[o.c1], dec.Sub(value, feeBRL))
+		o.fee.Add(o.fee, feeBRL)
+	}
+	b.stats.fill(o.c2, o.buy, qt, value, feeBRL)
+
+	b.opID++
+	ts := strconv.FormatInt(b.now.Unix(), 10)
+	o.executed.Add(o.executed, qt)
+	o.value.Add(o.value, value)
+	o.Operations = append(o.Operations, tapi.Operation{
+		ID:                b.opID,
+		Quantity:          dec.Format(qt, 8),
+		Price:             dec.Format(price, 5),
+		FeeRate:           rateStr,
+		ExecutedTimestamp: ts,
+	})
+	o.HasFills = true
+	o.ExecutedQuantity = dec.Format(o.executed, 8)
+	o.ExecutedPriceAvg = dec.Format(dec.Quo(o.value, o.executed), 5)
+	o.Fee = dec.Format(o.fee, 8)
+	o.UpdatedTimestamp = ts
+	if o.executed.Cmp(o.qt) == 0 {
+		o.Status = tapi.OrderStatusFilled
+	}
+}
+
+func (b *Backtest) newOrder(c1, c2 tapi.Coin, buy bool, qt, limit *big.Rat) *order {
+	ts := strconv.FormatInt(b.now.Unix(), 10)
+	o := &order{
+		Order: tapi.Order{
+			ID:               len(b.orders) + 1,
+			CoinPair:         pairOf(c1, c2),
+			Type:             tapi.OrderTypeSell,
+			Status:           tapi.OrderStatusOpen,
+			Quantity:         dec.Format(qt, 8),
+			ExecutedQuantity: dec.Format(dec.Zero(), 8),
+			ExecutedPriceAvg: dec.Format(dec.Zero(), 5),
+			Fee:              dec.Format(dec.Zero(), 8),
+			CreatedTimestamp: ts,
+			UpdatedTimestamp: ts,
+			Operations:       []tapi.Operation{},
+		},
+		c1:       c1,
+		c2:       c2,
+		buy:      buy,
+		qt:       qt,
+		limit:    limit,
+		executed: dec.Zero(),
+		value:    dec.Zero(),
+		fee:      dec.Zero(),
+		ahead:    dec.Zero(),
+	}
+	if buy {
+		o.Type = tapi.OrderTypeBuy
+	}
+	if limit != nil {
+		o.LimitPrice = dec.Format(limit, 5)
+	}
+	b.orders = append(b.orders, o)
+	return o
+}
+
+func (b *Backtest) available(c tapi.Coin) *big.Rat {
+	return dec.Sub(b.total[c], b.locked[c])
+}
+
+func (b *Backtest) book(c1, c2 tapi.Coin) (*book, error) {
+	bk, ok := b.books[pairOf(c1, c2)]
+	if !ok {
+		return nil, ErrNoOrderbook
+	}
+	return bk, nil
+}
+
+func parsePositive(s string) (*big.Rat, error) {
+	v, err := dec.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if v.Sign() <= 0 {
+		return nil, fmt.Errorf("backtest: %s must be positive", s)
+	}
+	return v, nil
+}
+
+func (b *Backtest) placeLimit(c1, c2 tapi.Coin, buy bool, qtStr, limitStr string) (*tapi.Order, error) {
+	bk, err := b.book(c1, c2)
+	if err != nil {
+		return nil, err
+	}
+	qt, err := parsePositive(qtStr)
+	if err != nil {
+		return nil, err
+	}
+	limit, err := parsePositive(limitStr)
+	if err != nil {
+		return nil, err
+	}
+	lockCoin, lock := c2, qt
+	if buy {
+		lockCoin, lock = c1, dec.Mul(qt, limit)
+	}
+	if b.available(lockCoin).Cmp(lock) < 0 {
+		return nil, ErrInsufficientBalance
+	}
+	b.locked[lockCoin].Add(b.locked[lockCoin], lock)
+	o := b.newOrder(c1, c2, buy, qt, limit)
+	if buy {
+		b.take(o, &bk.asks, limit, true)
+		o.ahead = sumAt(bk.bids, limit)
+	} else {
+		b.take(o, &bk.bids, limit, true)
+		o.ahead = sumAt(bk.asks, limit)
+	}
+	cp := o.copy()
+	return &cp, nil
+}
+
+// PlaceBuyOrder places a limit buy order, the part that crosses the
+// book is filled as taker.
+func (b *Backtest) PlaceBuyOrder(c1, c2 tapi.Coin, qt, limit string) (*tapi.Order, error) {
+	return b.placeLimit(c1, c2, true, qt, limit)
+}
+
+// PlaceSellOrder places a limit sell order, the part that crosses the
+// book is filled as taker.
+func (b *Backtest) PlaceSellOrder(c1, c2 tapi.Coin, qt, limit string) (*tapi.Order, error) {
+	return b.placeLimit(c1, c2, false, qt, limit)
+}
+
+// PlaceMarketBuyOrder buys walking the asks until cost is spent. When
+// the asks run out before, the order is cancelled with the quantity
+// executed.
+func (b *Backtest) PlaceMarketBuyOrder(c1, c2 tapi.Coin, costStr string) (*tapi.Order, error) {
+	bk, err := b.book(c1, c2)
+	if err != nil {
+		return nil, err
+	}
+	cost, err := parsePositive(costStr)
+	if err != nil {
+		return nil, err
+	}
+	if b.available(c1).Cmp(cost) < 0 {
+		return nil, ErrInsufficientBalance
+	}
+	// Find the quantity that the cost buys in the book.
+	qt, left := dec.Zero(), cost
+	for _, l := range bk.asks {
+		if left.Sign() <= 0 {
+			break
+		}
+		if l.price.Sign() <= 0 {
+			return nil, ErrInvalidPrice
+		}
+		q := minRat(l.qt, dec.Quo(left, l.price))
+		qt.Add(qt, q)
+		left = dec.Sub(left, dec.Mul(q, l.price))
+	}
+	if qt.Sign() == 0 {
+		return nil, ErrNoLiquidity
+	}
+	o := b.newOrder(c1, c2, true, qt, nil)
+	b.take(o, &bk.asks, nil, true)
+	b.closeMarket(o, left.Sign() > 0)
+	cp := o.copy()
+	return &cp, nil
+}
+
+// PlaceMarketSellOrder sells qt walking the bids.
+func (b *Backtest) PlaceMarketSellOrder(c1, c2 tapi.Coin, qtStr string) (*tapi.Order, error) {
+	bk, err := b.book(c1, c2)
+	if err != nil {
+		return nil, err
+	}
+	qt, err := parsePositive(qtStr)
+	if err != nil {
+		return nil, err
+	}
+	if b.available(c2).Cmp(qt) < 0 {
+		return nil, ErrInsufficientBalance
+	}
+	if len(bk.bids) == 0 {
+		return nil, ErrNoLiquidity
+	}
+	o := b.newOrder(c1, c2, false, qt, nil)
+	b.take(o, &bk.bids, nil, true)
+	b.closeMarket(o, false)
+	cp := o.copy()
+	return &cp, nil
+}
+
+// closeMarket closes a market order after it walked the book. Market
+// orders never rest, so the order is filled or, when the book had no
+// liquidity for all of it or exhausted is set, cancelled.
+func (b *Backtest) closeMarket(o *order, exhausted bool) {
+	if exhausted || o.executed.Cmp(o.qt) < 0 {
+		o.Status = tapi.OrderStatusCancelled
+	} else {
+		o.Status = tapi.OrderStatusFilled
+	}
+}
+
+func (b *Backtest) find(c1, c2 tapi.Coin, id int) (*order, error) {
+	if id < 1 || id > len(b.orders) {
+		return nil, ErrOrderNotFound
+	}
+	o := b.orders[id-1]
+	if o.c1 != c1 || o.c2 != c2 {
+		return nil, ErrOrderNotFound
+	}
+	return o, nil
+}
+
+// CancelOrder cancels an open order and releases its locked balance.
+// Orders that are not open are returned unchanged.
+func (b *Backtest) CancelOrder(c1, c2 tapi.Coin, id int) (*tapi.Order, error) {
+	o, err := b.find(c1, c2, id)
+	if err != nil {
+		return nil, err
+	}
+	if o.Status == tapi.OrderStatusOpen {
+		rem := dec.Sub(o.qt, o.executed)
+		if o.buy {
+			b.locked[c1].Sub(b.locked[c1], dec.Mul(rem, o.limit))
+		} else {
+			b.locked[c2].Sub(b.locked[c2], rem)
+		}
+		o.Status = tapi.OrderStatusCancelled
+		o.UpdatedTimestamp = strconv.FormatInt(b.now.Unix(), 10)
+	}
+	cp := o.copy()
+	return &cp, nil
+}
+
+// GetOrder returns the order with id.
+func (b *Backtest) GetOrder(c1, c2 tapi.Coin, id int) (*tapi.Order, error) {
+	o, err := b.find(c1, c2, id)
+	if err != nil {
+		return nil, err
+	}
+	cp := o.copy()
+	return &cp, nil
+}
+
+// ListOrders returns at max 200 orders of the pair filtered by opts,
+// the most recent first. The timestamp filters are ignored.
+func (b *Backtest) ListOrders(c1, c2 tapi.Coin, opts *tapi.ListOrdersOpts) ([]tapi.Order, error) {
+	if opts == nil {
+		opts = &tapi.ListOrdersOpts{}
+	}
+	statusSet := opts.StatusList != [3]int{}
+	var orders []tapi.Order
+	for i := len(b.orders) - 1; i >= 0 && len(orders) < 200; i-- {
+		o := b.orders[i]
+		switch {
+		case o.c1 != c1 || o.c2 != c2,
+			opts.OrderType > 0 && !o.buy,
+			opts.OrderType < 0 && o.buy,
+			statusSet && opts.StatusList[o.Status-tapi.OrderStatusOpen] == 0,
+			opts.HasFills > 0 && !o.HasFills,
+			opts.HasFills < 0 && o.HasFills,
+			opts.FromID != 0 && o.ID < opts.FromID,
+			opts.ToID != 0 && o.ID > opts.ToID:
+			continue
+		}
+		orders = append(orders, o.copy())
+	}
+	return orders, nil
+}
+
+// ListOrderbook returns the current snapshot of the pair with the open
+// orders of the backtest merged in and flagged with IsOwner.
+func (b *Backtest) ListOrderbook(c1, c2 tapi.Coin, full bool) (*tapi.Orderbook, error) {
+	bk, err := b.book(c1, c2)
+	if err != nil {
+		return nil, err
+	}
+	bids, asks := append([]level(nil), bk.bids...), append([]level(nil), bk.asks...)
+	for _, o := range b.openOrders(c1, c2) {
+		rem := dec.Sub(o.qt, o.executed)
+		l := level{
+			info: tapi.OrderInfo{
+				OrderID:    o.ID,
+				Quantity:   dec.Format(rem, 8),
+				LimitPrice: o.LimitPrice,
+				IsOwner:    true,
+			},
+			qt:    rem,
+			price: o.limit,
+		}
+		if o.buy {
+			bids = insertLevel(bids, l, true)
+		} else {
+			asks = insertLevel(asks, l, false)
+		}
+	}
+	max := 20
+	if full {
+		max = 500
+	}
+	return &tapi.Orderbook{
+		Bids:          infos(bids, max),
+		Asks:          infos(asks, max),
+		LatestOrderID: bk.latest,
+	}, nil
+}
+
+// insertLevel inserts l after the levels with the same or a better
+// price.
+func insertLevel(ls []level, l level, bids bool) []level {
+	i := sort.Search(len(ls), func(i int) bool {
+		if bids {
+			return ls[i].price.Cmp(l.price) < 0
+		}
+		return ls[i].price.Cmp(l.price) > 0
+	})
+	ls = append(ls, level{})
+	copy(ls[i+1:], ls[i:])
+	ls[i] = l
+	return ls
+}
+
+func infos(ls []level, max int) []tapi.OrderInfo {
+	if len(ls) > max {
+		ls = ls[:max]
+	}
+	out := make([]tapi.OrderInfo, len(ls))
+	for i, l := range ls {
+		out[i] = l.info
+	}
+	return out
+}
+
+// GetAccountInfo returns the simulated balances. The withdrawal limits
+// are zero.
+func (b *Backtest) GetAccountInfo() (*tapi.AccountInfo, error) {
+	info := &tapi.AccountInfo{}
+	amount := func(c tapi.Coin) tapi.Amount {
+		prec := 8
+		if c == tapi.BRL {
+			prec = 5
+		}
+		return tapi.Amount{
+			Available: dec.Format(b.available(c), prec),
+			Total:     dec.Format(b.total[c], prec),
+		}
+	}
+	crypto := func(c tapi.Coin) tapi.BalanceCrypto {
+		return tapi.BalanceCrypto{
+			Amount:     amount(c),
+			OpenOrders: len(b.openOrders(tapi.BRL, c)),
+		}
+	}
+	info.Balance.BRL = amount(tapi.BRL)
+	info.Balance.BTC = crypto(tapi.BTC)
+	info.Balance.LTC = crypto(tapi.LTC)
+	info.Balance.BCH = crypto(tapi.BCH)
+	info.Balance.XRP = crypto(tapi.XRP)
+	info.Balance.ETH = crypto(tapi.ETH)
+	return info, nil
+}
+
+// ListSystemMessages returns no messages.
+func (b *Backtest) ListSystemMessages(lvl string) ([]tapi.SystemMessage, error) {
+	return nil, nil
+}
+
+// GetWithdrawal returns ErrUnsupported.
+func (b *Backtest) GetWithdrawal(coin tapi.Coin, id int) (*tapi.Withdrawal, error) {
+	return nil, ErrUnsupported
+}
+
+// WithdrawBRL returns ErrUnsupported.
+func (b *Backtest) WithdrawBRL(desc, qt, accRef string) (*tapi.Withdrawal, error) {
+	return nil, ErrUnsupported
+}
+
+// WithdrawCrypto returns ErrUnsupported.
+func (b *Backtest) WithdrawCrypto(coin tapi.Coin, desc string, i *tapi.WithdrawInfo) (*tapi.Withdrawal, error) {
+	return nil, ErrUnsupported
+}
+
+// copy returns a copy of the order that the caller can modify.
+func (o *order) copy() tapi.Order {
+	cp := o.Order
+	cp.Operations = append([]tapi.Operation{}, o.Operations...)
+	return cp
+}
+
+// equity returns the value of all balances in BRL, the digital coins
+// are valued by the mid price of their BRL pair.
+func (b *Backtest) equity() *big.Rat {
+	eq := new(big.Rat).Set(b.total[tapi.BRL])
+	for _, c := range tapi.Coins {
+		if c == tapi.BRL || b.total[c].Sign() == 0 {
+			continue
+		}
+		bk, ok := b.books[pairOf(tapi.BRL, c)]
+		if !ok {
+			continue
+		}
+		if mid := bk.mid(); mid != nil {
+			eq.Add(eq, dec.Mul(b.total[c], mid))
+		}
+	}
+	return eq
+}
+
+func (bk *book) mid() *big.Rat {
+	switch {
+	case len(bk.bids) > 0 && len(bk.asks) > 0:
+		return dec.Quo(dec.Add(bk.bids[0].price, bk.asks[0].price), big.NewRat(2, 1))
+	case len(bk.bids) > 0:
+		return bk.bids[0].price
+	case len(bk.asks) > 0:
+		return bk.asks[0].price
+	}
+	return nil
+}
+
+func sumAt(ls []level, price *big.Rat) *big.Rat {
+	sum := dec.Zero()
+	for _, l := range ls {
+		if l.price.Cmp(price) == 0 {
+			sum.Add(sum, l.qt)
+		}
+	}
+	return sum
+}
+
+func minRat(x, y *big.Rat) *big.Rat {
+	if x.Cmp(y) < 0 {
+		return x
+	}
+	return y
+}
@@ -0,0 +1,205 @@
+package backtest
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	tapi "github.com/rschio/mb-tapi"
+	"github.com/rschio/mb-tapi/internal/dec"
+)
+
+const testEvents = `
+{"timestamp":"100","pair":"BRLBTC","orderbook":{"bids":[{"order_id":1,"quantity":"1.00000000","limit_price":"1000.00000","is_owner":false}],"asks":[{"order_id":2,"quantity":"1.00000000","limit_price":"1100.00000","is_owner":false}],"latest_order_id":2}}
+{"timestamp":"110","pair":"BRLBTC","trade":{"type":"sell","price":"1000.00000","amount":"1.20000000"}}
+{"timestamp":"120","pair":"BRLBTC","trade":{"type":"sell","price":"990.00000","amount":"1.00000000"}}
+{"timestamp":"130","pair":"BRLBTC","orderbook":{"bids":[{"order_id":1,"quantity":"1.00000000","limit_price":"1000.00000","is_owner":false}],"asks":[{"order_id":2,"quantity":"1.00000000","limit_price":"1100.00000","is_owner":false}],"latest_order_id":2}}
+{"timestamp":"140","pair":"BRLBTC","trade":{"type":"buy","price":"1060.00000","amount":"0.50000000"}}
+`
+
+func newTestBacktest(t *testing.T) *Backtest {
+	evs, err := ReadEvents(strings.NewReader(testEvents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := New(Config{
+		Balance:  map[tapi.Coin]string{tapi.BRL: "10000"},
+		MakerFee: "0.30",
+		TakerFee: "0.70",
+	}, evs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestRun(t *testing.T) {
+	b := newTestBacktest(t)
+	step := 0
+	strategy := func(api tapi.API, now time.Time) error {
+		step++
+		switch step {
+		case 1:
+			if _, err := api.PlaceMarketBuyOrder(tapi.BRL, tapi.BTC, "550"); err != nil {
+				return err
+			}
+			if _, err := api.PlaceBuyOrder(tapi.BRL, tapi.BTC, "0.5", "1000"); err != nil {
+				return err
+			}
+			book, err := api.ListOrderbook(tapi.BRL, tapi.BTC, false)
+			if err != nil {
+				return err
+			}
+			if len(book.Bids) != 2 || !book.Bids[1].IsOwner {
+				t.Errorf("own order not in the book: %+v", book.Bids)
+			}
+			if len(book.Asks) != 1 || book.Asks[0].Quantity != "0.50000000" {
+				t.Errorf("market order did not take the asks: %+v", book.Asks)
+			}
+		case 2:
+			o, err := api.GetOrder(tapi.BRL, tapi.BTC, 2)
+			if err != nil {
+				return err
+			}
+			// 1.2 traded at our price, 1 was ahead in the queue.
+			if o.ExecutedQuantity != "0.20000000" {
+				t.Errorf("got executed %s, expected %s", o.ExecutedQuantity, "0.20000000")
+			}
+		case 4:
+			if _, err := api.PlaceSellOrder(tapi.BRL, tapi.BTC, "0.5", "1050"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	res, err := b.Run(StrategyFunc(strategy))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := b.GetAccountInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Balance.BRL.Total != "9473.42500" {
+		t.Errorf("got BRL %s, expected %s", info.Balance.BRL.Total, "9473.42500")
+	}
+	if info.Balance.BTC.Total != "0.49500000" {
+		t.Errorf("got BTC %s, expected %s", info.Balance.BTC.Total, "0.49500000")
+	}
+	orders, err := b.ListOrders(tapi.BRL, tapi.BTC, &tapi.ListOrdersOpts{
+		StatusList: [3]int{0, 0, 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 3 {
+		t.Errorf("got %d filled orders, expected %d", len(orders), 3)
+	}
+	if ops := orders[0].Operations; len(ops) != 1 || ops[0].FeeRate != "0.30" {
+		t.Errorf("sell should be filled as maker: %+v", ops)
+	}
+
+	if res.Fills != 4 || res.Buys != 3 || res.Sells != 1 {
+		t.Errorf("got fills %d, buys %d, sells %d", res.Fills, res.Buys, res.Sells)
+	}
+	if got := dec.Format(res.Volume, 2); got != "1575.00" {
+		t.Errorf("got volume %s, expected %s", got, "1575.00")
+	}
+	if got := dec.Format(res.RealizedPnL, 4); got != "-4.2132" {
+		t.Errorf("got realized pnl %s, expected %s", got, "-4.2132")
+	}
+	if res.Losses != 1 {
+		t.Errorf("got %d losses, expected %d", res.Losses, 1)
+	}
+	if len(res.Equity) != 5 {
+		t.Errorf("got %d equity points, expected %d", len(res.Equity), 5)
+	}
+	if res.MaxDrawdown.Sign() <= 0 {
+		t.Errorf("got max drawdown %s, expected positive", res.MaxDrawdown.FloatString(4))
+	}
+}
+
+func TestCancelOrder(t *testing.T) {
+	b := newTestBacktest(t)
+	strategy := func(api tapi.API, now time.Time) error {
+		if now.Unix() != 100 {
+			return nil
+		}
+		if _, err := api.PlaceBuyOrder(tapi.BRL, tapi.BTC, "20", "1000"); !errors.Is(err, ErrInsufficientBalance) {
+			t.Errorf("got error %v, expected %v", err, ErrInsufficientBalance)
+		}
+		o, err := api.PlaceBuyOrder(tapi.BRL, tapi.BTC, "10", "1000")
+		if err != nil {
+			return err
+		}
+		o, err = api.CancelOrder(tapi.BRL, tapi.BTC, o.ID)
+		if err != nil {
+			return err
+		}
+		if o.Status != tapi.OrderStatusCancelled {
+			t.Errorf("got status %d, expected %d", o.Status, tapi.OrderStatusCancelled)
+		}
+		info, err := api.GetAccountInfo()
+		if err != nil {
+			return err
+		}
+		if info.Balance.BRL.Available != "10000.00000" {
+			t.Errorf("got available %s after cancel", info.Balance.BRL.Available)
+		}
+		return nil
+	}
+	if _, err := b.Run(StrategyFunc(strategy)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMarketBuyExhausted(t *testing.T) {
+	b := newTestBacktest(t)
+	strategy := func(api tapi.API, now time.Time) error {
+		if now.Unix() != 100 {
+			return nil
+		}
+		o, err := api.PlaceMarketBuyOrder(tapi.BRL, tapi.BTC, "2000")
+		if err != nil {
+			return err
+		}
+		if o.Status != tapi.OrderStatusCancelled || o.ExecutedQuantity != "1.00000000" {
+			t.Errorf("got status %d and executed %s, expected cancelled with 1.00000000",
+				o.Status, o.ExecutedQuantity)
+		}
+		if _, err := api.PlaceMarketBuyOrder(tapi.BRL, tapi.BTC, "100"); !errors.Is(err, ErrNoLiquidity) {
+			t.Errorf("got error %v, expected %v", err, ErrNoLiquidity)
+		}
+		return nil
+	}
+	if _, err := b.Run(StrategyFunc(strategy)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestZeroPrice(t *testing.T) {
+	tests := []string{
+		`{"timestamp":"100","pair":"BRLBTC","orderbook":{"bids":[],"asks":[{"order_id":2,"quantity":"1.00000000","limit_price":"0.00000","is_owner":false}],"latest_order_id":2}}`,
+		`{"timestamp":"100","pair":"BRLBTC","orderbook":{"bids":[],"asks":[],"latest_order_id":2}}
+{"timestamp":"110","pair":"BRLBTC","trade":{"type":"sell","price":"0","amount":"1.00000000"}}`,
+	}
+	for _, data := range tests {
+		evs, err := ReadEvents(strings.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := New(Config{Balance: map[tapi.Coin]string{tapi.BRL: "10000"}}, evs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		strategy := func(api tapi.API, now time.Time) error {
+			api.PlaceMarketBuyOrder(tapi.BRL, tapi.BTC, "100")
+			return nil
+		}
+		if _, err := b.Run(StrategyFunc(strategy)); !errors.Is(err, ErrInvalidPrice) {
+			t.Errorf("got error %v, expected %v", err, ErrInvalidPrice)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package backtest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	tapi "github.com/rschio/mb-tapi"
+)
+
+// Event is a recorded order book snapshot or public trade of a pair.
+// Exactly one of Orderbook and Trade is set.
+type Event struct {
+	// Timestamp is the unix timestamp of the event, in seconds.
+	Timestamp string `json:"timestamp"`
+
+	// Pair is the coin pair, such as "BRLBTC".
+	Pair string `json:"pair"`
+
+	Orderbook *tapi.Orderbook `json:"orderbook,omitempty"`
+	Trade     *Trade          `json:"trade,omitempty"`
+
+	t      time.Time
+	c1, c2 tapi.Coin
+}
+
+// Trade is a public trade.
+type Trade struct {
+	// Type is the side of the taker, "buy" or "sell".
+	Type string `json:"type"`
+
+	Price  string `json:"price"`
+	Amount string `json:"amount"`
+}
+
+// ReadEvents reads events in JSON lines format, one event per line, and
+// returns them sorted by time.
+func ReadEvents(r io.Reader) ([]Event, error) {
+	var evs []Event
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 16<<20)
+	for n := 1; s.Scan(); n++ {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+		var ev Event
+		if err := json.Unmarshal(s.Bytes(), &ev); err != nil {
+			return nil, fmt.Errorf("backtest: line %d: %v", n, err)
+		}
+		evs = append(evs, ev)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if err := prepare(evs); err != nil {
+		return nil, err
+	}
+	return evs, nil
+}
+
+// prepare validates and sorts evs.
+func prepare(evs []Event) error {
+	for i := range evs {
+		ev := &evs[i]
+		t, err := tapi.ParseTimestamp(ev.Timestamp)
+		if err != nil {
+			return fmt.Errorf("backtest: %v", err)
+		}
+		ev.t = t
+		if ev.c1, ev.c2, err = tapi.ParsePair(ev.Pair); err != nil {
+			return fmt.Errorf("backtest: %v", err)
+		}
+		if (ev.Orderbook == nil) == (ev.Trade == nil) {
+			return fmt.Errorf("backtest: event at %s must have an orderbook or a trade", ev.Timestamp)
+		}
+		if ev.Trade != nil && ev.Trade.Type != "buy" && ev.Trade.Type != "sell" {
+			return fmt.Errorf("backtest: invalid trade type %q", ev.Trade.Type)
+		}
+	}
+	sort.SliceStable(evs, func(i, j int) bool { return evs[i].t.Before(evs[j].t) })
+	return nil
+}
@@ -0,0 +1,131 @@
+package backtest
+
+import (
+	"math/big"
+	"time"
+
+	tapi "github.com/rschio/mb-tapi"
+	"github.com/rschio/mb-tapi/internal/dec"
+)
+
+// Point is a point of the equity curve.
+type Point struct {
+	Time time.Time
+
+	// Equity is the value of all balances in BRL.
+	Equity *big.Rat
+}
+
+// Result contains the equity curve and the trade statistics of a run.
+type Result struct {
+	Equity []Point
+
+	// MaxDrawdown is the largest fall of equity from a previous peak,
+	// as a fraction of the peak.
+	MaxDrawdown *big.Rat
+
+	// Fills, Buys and Sells count the executions.
+	Fills int
+	Buys  int
+	Sells int
+
+	// Volume and Fees are the traded value and the paid fees in BRL.
+	Volume *big.Rat
+	Fees   *big.Rat
+
+	// RealizedPnL is the profit of the sells over the average cost of
+	// the coins bought during the run, net of fees. Coins of the
+	// initial balance are valued at the sell price.
+	RealizedPnL *big.Rat
+
+	// Wins and Losses count the sells with positive and negative
+	// realized profit.
+	Wins   int
+	Losses int
+}
+
+type position struct {
+	qt   *big.Rat
+	cost *big.Rat
+}
+
+type stats struct {
+	res  Result
+	peak *big.Rat
+	pos  map[tapi.Coin]*position
+}
+
+func newStats() stats {
+	return stats{
+		res: Result{
+			MaxDrawdown: dec.Zero(),
+			Volume:      dec.Zero(),
+			Fees:        dec.Zero(),
+			RealizedPnL: dec.Zero(),
+		},
+		pos: make(map[tapi.Coin]*position),
+	}
+}
+
+func (s *stats) fill(coin tapi.Coin, buy bool, qt, value, fee *big.Rat) {
+	s.res.Fills++
+	s.res.Volume.Add(s.res.Volume, value)
+	s.res.Fees.Add(s.res.Fees, fee)
+	p := s.pos[coin]
+	if p == nil {
+		p = &position{qt: dec.Zero(), cost: dec.Zero()}
+		s.pos[coin] = p
+	}
+	if buy {
+		s.res.Buys++
+		// The buy fee is paid in the coin, so the cost of the net
+		// quantity includes it.
+		net := qt
+		if value.Sign() > 0 {
+			net = dec.Sub(qt, dec.Quo(dec.Mul(qt, fee), value))
+		}
+		p.qt.Add(p.qt, net)
+		p.cost.Add(p.cost, value)
+		return
+	}
+	s.res.Sells++
+	basis := dec.Zero()
+	tracked := minRat(qt, p.qt)
+	if tracked.Sign() > 0 {
+		basis = dec.Quo(dec.Mul(p.cost, tracked), p.qt)
+		p.cost.Sub(p.cost, basis)
+		p.qt.Sub(p.qt, tracked)
+	}
+	// Coins not bought during the run have the sell price as cost.
+	if untracked := dec.Sub(qt, tracked); untracked.Sign() > 0 {
+		basis.Add(basis, dec.Quo(dec.Mul(value, untracked), qt))
+	}
+	pnl := dec.Sub(dec.Sub(value, fee), basis)
+	s.res.RealizedPnL.Add(s.res.RealizedPnL, pnl)
+	switch pnl.Sign() {
+	case 1:
+		s.res.Wins++
+	case -1:
+		s.res.Losses++
+	}
+}
+
+func (s *stats) record(t time.Time, equity *big.Rat) {
+	s.res.Equity = append(s.res.Equity, Point{Time: t, Equity: equity})
+	if s.peak == nil || equity.Cmp(s.peak) > 0 {
+		s.peak = equity
+		return
+	}
+	if s.peak.Sign() <= 0 {
+		return
+	}
+	dd := dec.Quo(dec.Sub(s.peak, equity), s.peak)
+	if dd.Cmp(s.res.MaxDrawdown) > 0 {
+		s.res.MaxDrawdown = dd
+	}
+}
+
+func (s *stats) result() *Result {
+	r := s.res
+	return &r
+}
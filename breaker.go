@@ -0,0 +1,244 @@
+package tapi
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Method classes of the circuit breaker.
+const (
+	ClassRead     = "read"
+	ClassTrade    = "trade"
+	ClassCancel   = "cancel"
+	ClassWithdraw = "withdraw"
+)
+
+// MethodClass returns the class of a tapi method: ClassWithdraw for
+// withdraw_coin, ClassTrade for the order placement, ClassCancel for
+// cancel_order and ClassRead for the others. The cancellations have
+// their own class, so failed placements never stop them from reducing
+// the risk of the resting orders.
+func MethodClass(method string) string {
+	switch {
+	case method == "withdraw_coin":
+		return ClassWithdraw
+	case method == "cancel_order":
+		return ClassCancel
+	case strings.HasPrefix(method, "place_"):
+		return ClassTrade
+	}
+	return ClassRead
+}
+
+// BreakerState is the state of the circuit of a method class.
+type BreakerState int
+
+const (
+	// BreakerClosed lets the requests pass.
+	BreakerClosed BreakerState = iota
+
+	// BreakerOpen fails the requests without sending them.
+	BreakerOpen
+
+	// BreakerHalfOpen lets a limited number of trial requests pass.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("BreakerState(%d)", int(s))
+}
+
+// ErrCircuitOpen is matched by the errors of requests rejected by an
+// open circuit.
+var ErrCircuitOpen = errors.New("circuit open")
+
+// CircuitOpenError is returned by MakeRequest while the circuit of the
+// class of the method is open.
+type CircuitOpenError struct {
+	Class string
+
+	// Retry is when the circuit lets a trial request pass.
+	Retry time.Time
+
+	// Last is the last failure of the circuit.
+	Last error
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s %v until %s: %v", e.Class, ErrCircuitOpen,
+		e.Retry.Format(time.RFC3339), e.Last)
+}
+
+func (e *CircuitOpenError) Unwrap() error { return ErrCircuitOpen }
+
+// BreakerConfig configures a CircuitBreaker. Zero fields have defaults.
+type BreakerConfig struct {
+	// Threshold is the number of consecutive failures that opens a
+	// circuit, 5 by default.
+	Threshold int
+
+	// Cooldown is how long a circuit stays open before a trial, 30
+	// seconds by default.
+	Cooldown time.Duration
+
+	// Probes is the number of concurrent trial requests of a half-open
+	// circuit, 1 by default.
+	Probes int
+
+	// OnStateChange, if not nil, is called with each state change,
+	// such as to update a metrics gauge. It must not call the breaker.
+	OnStateChange func(class string, from, to BreakerState)
+}
+
+// BreakerStats are the counters of a circuit.
+type BreakerStats struct {
+	State BreakerState
+
+	// Failures are the consecutive failures.
+	Failures int
+
+	// Rejected is the number of requests failed fast.
+	Rejected uint64
+
+	// Opened is the number of times the circuit opened.
+	Opened uint64
+}
+
+type circuit struct {
+	BreakerStats
+	retry  time.Time
+	probes int
+	last   error
+}
+
+// CircuitBreaker stops the requests of a method class after repeated
+// transport errors, 5xx responses or invalid JSON, so the callers fail
+// fast instead of waiting for timeouts. The errors returned by the
+// exchange, with a tapi status code, are not failures. It is safe for
+// concurrent use and can be shared by clients.
+type CircuitBreaker struct {
+	cfg BreakerConfig
+	now func() time.Time
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+// NewCircuitBreaker creates a CircuitBreaker configured by cfg.
+func NewCircuitBreaker(cfg BreakerConfig) *CircuitBreaker {
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = 5
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = 30 * time.Second
+	}
+	if cfg.Probes <= 0 {
+		cfg.Probes = 1
+	}
+	return &CircuitBreaker{cfg: cfg, now: time.Now, circuits: make(map[string]*circuit)}
+}
+
+// WithCircuitBreaker makes the client check b before each request.
+func WithCircuitBreaker(b *CircuitBreaker) Option {
+	return func(c *Client) { c.breaker = b }
+}
+
+func (b *CircuitBreaker) circuit(class string) *circuit {
+	c, ok := b.circuits[class]
+	if !ok {
+		c = &circuit{}
+		b.circuits[class] = c
+	}
+	return c
+}
+
+func (b *CircuitBreaker) setState(class string, c *circuit, s BreakerState) {
+	from := c.State
+	if from == s {
+		return
+	}
+	c.State = s
+	if s == BreakerOpen {
+		c.Opened++
+	}
+	if b.cfg.OnStateChange != nil {
+		b.cfg.OnStateChange(class, from, s)
+	}
+}
+
+// allow reports whether a request of class can be sent. If so, done
+// must be called with its failure, or nil.
+func (b *CircuitBreaker) allow(class string) (done func(failure error), err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.circuit(class)
+	if c.State == BreakerOpen && !b.now().Before(c.retry) {
+		b.setState(class, c, BreakerHalfOpen)
+		c.probes = 0
+	}
+	probe := false
+	switch c.State {
+	case BreakerOpen:
+		c.Rejected++
+		return nil, &CircuitOpenError{Class: class, Retry: c.retry, Last: c.last}
+	case BreakerHalfOpen:
+		if c.probes >= b.cfg.Probes {
+			c.Rejected++
+			return nil, &CircuitOpenError{Class: class, Retry: c.retry, Last: c.last}
+		}
+		c.probes++
+		probe = true
+	}
+	return func(failure error) { b.done(class, probe, failure) }, nil
+}
+
+func (b *CircuitBreaker) done(class string, probe bool, failure error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.circuit(class)
+	if probe {
+		c.probes--
+	}
+	if failure == nil {
+		c.Failures = 0
+		if probe {
+			b.setState(class, c, BreakerClosed)
+		}
+		return
+	}
+	c.Failures++
+	c.last = failure
+	if probe || (c.State == BreakerClosed && c.Failures >= b.cfg.Threshold) {
+		c.retry = b.now().Add(b.cfg.Cooldown)
+		b.setState(class, c, BreakerOpen)
+	}
+}
+
+// State returns the state of the circuit of class.
+func (b *CircuitBreaker) State(class string) BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.circuit(class).State
+}
+
+// Stats returns the counters of the circuits used, by class.
+func (b *CircuitBreaker) Stats() map[string]BreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := make(map[string]BreakerStats, len(b.circuits))
+	for class, c := range b.circuits {
+		stats[class] = c.BreakerStats
+	}
+	return stats
+}
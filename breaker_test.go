@@ -0,0 +1,119 @@
+package tapi
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMethodClass(t *testing.T) {
+	tests := map[string]string{
+		"list_orders":            ClassRead,
+		"get_account_info":       ClassRead,
+		"place_market_buy_order": ClassTrade,
+		"cancel_order":           ClassCancel,
+		"withdraw_coin":          ClassWithdraw,
+	}
+	for method, class := range tests {
+		if got := MethodClass(method); got != class {
+			t.Errorf("%s: got %s, expected %s", method, got, class)
+		}
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	mode := "down"
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch mode {
+		case "down":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("<html>503</html>"))
+		case "invalid":
+			w.Write([]byte(`{"status_code": 201, "error_message": "invalid"}`))
+		default:
+			w.Write([]byte(`{"status_code": 100, "response_data": {}}`))
+		}
+	}))
+	defer srv.Close()
+
+	var changes []string
+	b := NewCircuitBreaker(BreakerConfig{
+		Threshold: 2,
+		Cooldown:  time.Minute,
+		OnStateChange: func(class string, from, to BreakerState) {
+			changes = append(changes, class+" "+to.String())
+		},
+	})
+	now := time.Unix(1600000000, 0)
+	b.now = func() time.Time { return now }
+	c := NewClient(srv.URL, fakeID, fakeKey, nil, WithCircuitBreaker(b))
+
+	// The errors of the exchange are not failures.
+	mode = "invalid"
+	for i := 0; i < 3; i++ {
+		c.GetAccountInfo()
+	}
+	if s := b.State(ClassRead); s != BreakerClosed {
+		t.Errorf("got %v, expected closed", s)
+	}
+
+	mode = "down"
+	c.GetAccountInfo()
+	c.GetAccountInfo()
+	n := requests
+	_, err := c.GetAccountInfo()
+	var cerr *CircuitOpenError
+	if !errors.Is(err, ErrCircuitOpen) || !errors.As(err, &cerr) || cerr.Class != ClassRead {
+		t.Errorf("got error %v, expected read circuit open", err)
+	}
+	if requests != n {
+		t.Errorf("got %d requests, expected %d", requests, n)
+	}
+	// Other classes are not affected.
+	if _, err := c.CancelOrder(BRL, BTC, 1); errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("got error %v on cancel, expected a request", err)
+	}
+	// The cancellations are not stopped by failed placements.
+	c.PlaceBuyOrder(BRL, BTC, "1", "100")
+	c.PlaceBuyOrder(BRL, BTC, "1", "100")
+	if s := b.State(ClassTrade); s != BreakerOpen {
+		t.Errorf("got %v, expected trade open", s)
+	}
+	if _, err := c.CancelOrder(BRL, BTC, 1); errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("got error %v on cancel, expected a request", err)
+	}
+
+	// A failed trial opens the circuit again.
+	now = now.Add(time.Minute)
+	c.GetAccountInfo()
+	if s := b.State(ClassRead); s != BreakerOpen {
+		t.Errorf("got %v, expected open", s)
+	}
+	now = now.Add(time.Minute)
+	mode = "ok"
+	if _, err := c.GetAccountInfo(); err != nil {
+		t.Fatal(err)
+	}
+	if s := b.State(ClassRead); s != BreakerClosed {
+		t.Errorf("got %v, expected closed", s)
+	}
+
+	exp := []string{"read open", "trade open", "cancel open", "read half-open", "read open", "read half-open", "read closed"}
+	if len(changes) != len(exp) {
+		t.Fatalf("got changes %v, expected %v", changes, exp)
+	}
+	for i := range exp {
+		if changes[i] != exp[i] {
+			t.Errorf("got changes %v, expected %v", changes, exp)
+			break
+		}
+	}
+	st := b.Stats()[ClassRead]
+	if st.Opened != 2 || st.Rejected != 1 {
+		t.Errorf("got %+v, expected opened 2 and rejected 1", st)
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"crypto/hmac"
 	"crypto/sha512"
 	"encoding/hex"
+	"fmt"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -15,36 +17,121 @@ const DefaultService = "https://www.mercadobitcoin.net/tapi/v3/"
 // Client is a API client.
 type Client struct {
 	service string
-	apiID   string
-	apiKey  string
 	client  *http.Client
+
+	mu    sync.RWMutex
+	creds CredentialProvider
+
+	limiter *RateLimiter
+	breaker *CircuitBreaker
+	clock   *Clock
+
+	nonceMu   sync.Mutex
+	lastNonce int64
+
+	orderRules
 }
 
-// NewClient creates a new client.
-func NewClient(service, apiID, apiKey string, client *http.Client) *Client {
+// Option configures a Client.
+type Option func(*Client)
+
+// NewClient creates a new client configured by opts. The apiID and
+// apiKey are ignored if opts contains WithCredentials.
+func NewClient(service, apiID, apiKey string, client *http.Client, opts ...Option) *Client {
 	c := &Client{
 		service: service,
-		apiID:   apiID,
-		apiKey:  apiKey,
 		client:  client,
+		creds:   NewStaticProvider(apiID, apiKey),
 	}
+	c.markets = DefaultMarkets
 	if c.client == nil {
 		c.client = http.DefaultClient
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
 	return c
 }
 
-// Nonce creates a unique value that always increase.
+// Now returns the time of the server estimated by the Clock of the
+// client, or the local time if it has none.
+func (c *Client) Now() time.Time {
+	if c.clock != nil {
+		return c.clock.Now()
+	}
+	return time.Now()
+}
+
+// Nonce creates a unique value that always increase. Each Client has
+// its own sequence, so even concurrent calls never repeat a value.
 func (c *Client) Nonce() string {
-	t := time.Now().UnixNano()
+	c.nonceMu.Lock()
+	defer c.nonceMu.Unlock()
+	t := c.Now().UnixNano()
+	if t <= c.lastNonce {
+		t = c.lastNonce + 1
+	}
+	c.lastNonce = t
 	nonce := strconv.FormatInt(t, 10)
 	return nonce
 }
 
-// Hmac signs the msg.
+// SignMessage signs msg with the current key, as MakeRequest signs the
+// requests. It fails if the credential provider fails or has no key.
+func (c *Client) SignMessage(msg string) (string, error) {
+	creds, err := c.credentials()
+	if err != nil {
+		return "", err
+	}
+	return sign(creds.Key, msg), nil
+}
+
+// Hmac signs the msg with the current key. An empty string, which is
+// never a valid MAC, means the credentials could not be read.
+//
+// Deprecated: Use SignMessage, which returns the error.
 func (c *Client) Hmac(msg string) string {
-	mac := hmac.New(sha512.New, []byte(c.apiKey))
+	mac, _ := c.SignMessage(msg)
+	return mac
+}
+
+func sign(key, msg string) string {
+	mac := hmac.New(sha512.New, []byte(key))
 	mac.Write([]byte(msg))
 	out := mac.Sum(nil)
 	return hex.EncodeToString(out)
 }
+
+// String describes the client without its credentials.
+func (c *Client) String() string {
+	return fmt.Sprintf("tapi.Client{service: %q}", c.service)
+}
+
+// GoString is like String, so %#v does not print the credentials.
+func (c *Client) GoString() string {
+	return c.String()
+}
+
+// API is the set of tapi methods implemented by Client. Code that
+// depends on API can run against other implementations, such as a
+// backtest.
+type API interface {
+	ListSystemMessages(lvl string) ([]SystemMessage, error)
+	GetAccountInfo() (*AccountInfo, error)
+	GetOrder(c1, c2 Coin, id int) (*Order, error)
+	ListOrders(c1, c2 Coin, opts *ListOrdersOpts) ([]Order, error)
+	ListOrderbook(c1, c2 Coin, full bool) (*Orderbook, error)
+	PlaceBuyOrder(c1, c2 Coin, qt, limit string) (*Order, error)
+	PlaceSellOrder(c1, c2 Coin, qt, limit string) (*Order, error)
+	PlaceMarketBuyOrder(c1, c2 Coin, cost string) (*Order, error)
+	PlaceMarketSellOrder(c1, c2 Coin, qt string) (*Order, error)
+	CancelOrder(c1, c2 Coin, id int) (*Order, error)
+	GetWithdrawal(coin Coin, id int) (*Withdrawal, error)
+	WithdrawBRL(desc, qt, accRef string) (*Withdrawal, error)
+	WithdrawCrypto(coin Coin, desc string, i *WithdrawInfo) (*Withdrawal, error)
+}
+
+var (
+	_ API        = (*Client)(nil)
+	_ Normalizer = (*Client)(nil)
+)
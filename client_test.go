@@ -2,6 +2,9 @@ package tapi
 
 import (
 	"crypto/hmac"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -22,3 +25,21 @@ func TestClientHmac(t *testing.T) {
 		t.Errorf("wrong hmac")
 	}
 }
+
+func TestSignMessageErrors(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer srv.Close()
+	cli := NewClient(srv.URL, fakeID, "", nil)
+	if _, err := cli.SignMessage("msg"); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("got error %v, expected %v", err, ErrNoCredentials)
+	}
+	if _, err := cli.GetAccountInfo(); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("got error %v, expected %v", err, ErrNoCredentials)
+	}
+	if calls != 0 {
+		t.Errorf("got %d requests without key", calls)
+	}
+}
@@ -0,0 +1,132 @@
+package tapi
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ClockConfig configures a Clock. Zero fields have defaults.
+type ClockConfig struct {
+	// Smoothing is the weight of a new sample in the offset, between 0
+	// and 1, 0.2 by default.
+	Smoothing float64
+
+	// MaxRTT is the max duration of a request used as sample, 2 seconds
+	// by default. Slow requests say little about when the server
+	// stamped the response.
+	MaxRTT time.Duration
+
+	// MaxJump is the max distance between a sample and the offset, 5
+	// seconds by default. Farther samples are outliers.
+	MaxJump time.Duration
+
+	// Resync is the number of consecutive outliers that replace the
+	// offset, 3 by default, as when one of the clocks is stepped.
+	Resync int
+}
+
+// ClockStats are the counters of a Clock.
+type ClockStats struct {
+	Offset   time.Duration
+	Samples  uint64
+	Rejected uint64
+}
+
+// Clock tracks the offset between the local clock and the clock of the
+// server from the ServerUnixTimestamp of the responses. The timestamps
+// have a resolution of one second, so the offset is smoothed over the
+// samples. It is safe for concurrent use.
+type Clock struct {
+	cfg ClockConfig
+	now func() time.Time
+
+	mu       sync.Mutex
+	stats    ClockStats
+	outliers []time.Duration
+}
+
+// NewClock creates a Clock configured by cfg. The offset is zero until
+// the first sample.
+func NewClock(cfg ClockConfig) *Clock {
+	if cfg.Smoothing <= 0 || cfg.Smoothing > 1 {
+		cfg.Smoothing = 0.2
+	}
+	if cfg.MaxRTT <= 0 {
+		cfg.MaxRTT = 2 * time.Second
+	}
+	if cfg.MaxJump <= 0 {
+		cfg.MaxJump = 5 * time.Second
+	}
+	if cfg.Resync <= 0 {
+		cfg.Resync = 3
+	}
+	return &Clock{cfg: cfg, now: time.Now}
+}
+
+// WithClock makes the client sample k with each response and use its
+// time for the nonces and the From and To of ListOrdersOpts.
+func WithClock(k *Clock) Option {
+	return func(c *Client) { c.clock = k }
+}
+
+// Observe adds the sample of a request sent and received at the local
+// times with the server timestamp of the response. It reports whether
+// the sample was used.
+func (k *Clock) Observe(sent, received time.Time, serverUnix string) bool {
+	sec, err := strconv.ParseInt(serverUnix, 10, 64)
+	rtt := received.Sub(sent)
+	if err != nil || sec <= 0 || rtt < 0 || rtt > k.cfg.MaxRTT {
+		return false
+	}
+	// The server truncates its time to the second, so the middle of the
+	// second is the best guess, compared to the middle of the request.
+	server := time.Unix(sec, int64(time.Second/2))
+	sample := server.Sub(sent.Add(rtt / 2))
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.stats.Samples == 0 {
+		k.stats.Offset = sample
+		k.stats.Samples++
+		return true
+	}
+	if d := sample - k.stats.Offset; d > k.cfg.MaxJump || d < -k.cfg.MaxJump {
+		k.stats.Rejected++
+		k.outliers = append(k.outliers, sample)
+		if len(k.outliers) < k.cfg.Resync {
+			return false
+		}
+		var sum time.Duration
+		for _, o := range k.outliers {
+			sum += o
+		}
+		k.stats.Offset = sum / time.Duration(len(k.outliers))
+		k.outliers = nil
+		k.stats.Samples++
+		return true
+	}
+	k.outliers = nil
+	k.stats.Offset += time.Duration(k.cfg.Smoothing * float64(sample-k.stats.Offset))
+	k.stats.Samples++
+	return true
+}
+
+// Offset returns the estimated server time minus the local time.
+func (k *Clock) Offset() time.Duration {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.stats.Offset
+}
+
+// Now returns the estimated time of the server.
+func (k *Clock) Now() time.Time {
+	return k.now().Add(k.Offset())
+}
+
+// Stats returns the offset and the counters of the samples.
+func (k *Clock) Stats() ClockStats {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.stats
+}
@@ -0,0 +1,96 @@
+package tapi
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestClockObserve(t *testing.T) {
+	k := NewClock(ClockConfig{Smoothing: 0.5, MaxJump: 5 * time.Second, Resync: 2})
+	local := time.Unix(1600000000, 0)
+	at := func(skew time.Duration) string {
+		return strconv.FormatInt(local.Add(skew).Unix(), 10)
+	}
+	// The request takes 1s, so the sample is taken at its middle.
+	if !k.Observe(local, local.Add(time.Second), at(10*time.Second)) {
+		t.Fatal("got first sample rejected")
+	}
+	if got := k.Offset(); got != 10*time.Second {
+		t.Errorf("got offset %v, expected 10s", got)
+	}
+	k.Observe(local, local.Add(time.Second), at(12*time.Second))
+	if got := k.Offset(); got != 11*time.Second {
+		t.Errorf("got offset %v, expected 11s", got)
+	}
+
+	// Slow requests, invalid timestamps and outliers are rejected.
+	if k.Observe(local, local.Add(3*time.Second), at(time.Minute)) {
+		t.Error("got slow request sampled")
+	}
+	if k.Observe(local, local.Add(time.Second), "") {
+		t.Error("got empty timestamp sampled")
+	}
+	if k.Observe(local, local.Add(time.Second), at(time.Minute)) {
+		t.Error("got outlier sampled")
+	}
+	if got := k.Offset(); got != 11*time.Second {
+		t.Errorf("got offset %v, expected 11s", got)
+	}
+	// Consecutive outliers replace the offset.
+	if !k.Observe(local, local.Add(time.Second), at(time.Minute+2*time.Second)) {
+		t.Error("got resync rejected")
+	}
+	if got := k.Offset(); got != time.Minute+time.Second {
+		t.Errorf("got offset %v, expected 1m1s", got)
+	}
+	st := k.Stats()
+	if st.Samples != 3 || st.Rejected != 2 {
+		t.Errorf("got %+v, expected 3 samples and 2 rejected", st)
+	}
+}
+
+func TestClientClock(t *testing.T) {
+	skew := time.Hour
+	var nonce, from string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce = r.FormValue("tapi_nonce")
+		from = r.FormValue("from_timestamp")
+		fmt.Fprintf(w, `{"status_code": 100, "response_data": {"orders": []}, "server_unix_timestamp": "%d"}`,
+			time.Now().Add(skew).Unix())
+	}))
+	defer srv.Close()
+
+	k := NewClock(ClockConfig{})
+	c := NewClient(srv.URL, fakeID, fakeKey, nil, WithClock(k))
+	if _, err := c.ListOrders(BRL, BTC, nil); err != nil {
+		t.Fatal(err)
+	}
+	if d := k.Offset() - skew; d > 2*time.Second || d < -2*time.Second {
+		t.Errorf("got offset %v, expected about %v", k.Offset(), skew)
+	}
+
+	local := time.Now()
+	if _, err := c.ListOrders(BRL, BTC, &ListOrdersOpts{From: local}); err != nil {
+		t.Fatal(err)
+	}
+	n, _ := strconv.ParseInt(nonce, 10, 64)
+	if d := time.Unix(0, n).Sub(local.Add(skew)); d > 2*time.Second || d < -2*time.Second {
+		t.Errorf("got nonce %s, expected about %d", nonce, local.Add(skew).UnixNano())
+	}
+	sec, _ := strconv.ParseInt(from, 10, 64)
+	if d := sec - local.Add(skew).Unix(); d > 2 || d < -2 {
+		t.Errorf("got from_timestamp %s, expected about %d", from, local.Add(skew).Unix())
+	}
+
+	// The timestamp strings are sent as they are.
+	if _, err := c.ListOrders(BRL, BTC, &ListOrdersOpts{From: local, FromTimestamp: "1600000000"}); err != nil {
+		t.Fatal(err)
+	}
+	if from != "1600000000" {
+		t.Errorf("got from_timestamp %s, expected 1600000000", from)
+	}
+}
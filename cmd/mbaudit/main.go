@@ -0,0 +1,75 @@
+// Command mbaudit checks an audit journal written by the audit package.
+//
+// Usage:
+//
+//	mbaudit verify [-head HASH] JOURNAL
+//
+// verify checks the hash chain and the sequence of the entries and
+// prints the number of entries and the hash of the last one. With -head
+// it also fails if the journal does not contain the entry of HASH, as
+// entries removed from the end of the journal keep a valid chain.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/rschio/mb-tapi/audit"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 || os.Args[1] != "verify" {
+		log.Fatal("usage: mbaudit verify [-head HASH] JOURNAL")
+	}
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	head := fs.String("head", "", "hash of an entry the journal must contain")
+	fs.Parse(os.Args[2:])
+	if fs.NArg() != 1 {
+		log.Fatal("usage: mbaudit verify [-head HASH] JOURNAL")
+	}
+	path := fs.Arg(0)
+
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	n, last, err := audit.Verify(f)
+	if err != nil {
+		log.Fatalf("%s: %v", path, err)
+	}
+	if *head != "" && *head != last {
+		found, err := contains(path, *head)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !found {
+			log.Fatalf("%s: entry %s not found, the journal was truncated", path, *head)
+		}
+	}
+	fmt.Printf("%s: ok, %d entries, head %s\n", path, n, last)
+}
+
+func contains(path, hash string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 64*1024), 16<<20)
+	for s.Scan() {
+		e, err := audit.ParseLine(s.Bytes())
+		if err != nil {
+			return false, err
+		}
+		if e.Hash == hash {
+			return true, nil
+		}
+	}
+	return false, s.Err()
+}
@@ -0,0 +1,155 @@
+// Command mbctl runs operational commands on a Mercado Bitcoin account.
+//
+// Usage:
+//
+//	mbctl cancel-all [-coins BTC,ETH] [-type buy|sell] [-timeout 1m]
+//	mbctl deadman -store FILE -heartbeat FILE [-account NAME] [-coins BTC,ETH] [-timeout 1m]
+//
+// cancel-all is the kill switch: it cancels every open order of the
+// coins traded against BRL, prints the orders cancelled, filled and
+// failed, and exits with status 1 if any order may still be open.
+//
+// deadman runs the dead man's switch as a sidecar of a strategy: when
+// the heartbeat file written by the strategy is older than the timeout,
+// it cancels the open orders of the account in the order store.
+//
+// The API ID and key are read from the MBID and MBKEY environment
+// variables.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	tapi "github.com/rschio/mb-tapi"
+	"github.com/rschio/mb-tapi/deadman"
+	"github.com/rschio/mb-tapi/store"
+)
+
+const usage = `usage:
+	mbctl cancel-all [-coins BTC,ETH] [-type buy|sell] [-timeout 1m]
+	mbctl deadman -store FILE -heartbeat FILE [-account NAME] [-coins BTC,ETH] [-timeout 1m]`
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		log.Fatal(usage)
+	}
+	switch os.Args[1] {
+	case "cancel-all":
+		cancelAll(os.Args[2:])
+	case "deadman":
+		deadMan(os.Args[2:])
+	default:
+		log.Fatal(usage)
+	}
+}
+
+func newClient(service string) *tapi.Client {
+	creds := tapi.EnvProvider{IDVar: "MBID", KeyVar: "MBKEY"}
+	if _, err := creds.Credentials(); err != nil {
+		log.Fatal(err)
+	}
+	return tapi.NewClient(service, "", "", nil, tapi.WithCredentials(creds))
+}
+
+// parsePairs parses comma separated coins traded against BRL.
+func parsePairs(s string) [][2]tapi.Coin {
+	var pairs [][2]tapi.Coin
+	for _, name := range strings.Split(s, ",") {
+		c, err := tapi.ParseCoin(strings.ToUpper(strings.TrimSpace(name)))
+		if err != nil {
+			log.Fatal(err)
+		}
+		pairs = append(pairs, [2]tapi.Coin{tapi.BRL, c})
+	}
+	return pairs
+}
+
+func cancelAll(args []string) {
+	fs := flag.NewFlagSet("cancel-all", flag.ExitOnError)
+	coins := fs.String("coins", "", "comma separated coins traded against BRL (default all)")
+	typ := fs.String("type", "", "cancel only buy or sell orders")
+	timeout := fs.Duration("timeout", time.Minute, "max duration")
+	service := fs.String("service", tapi.DefaultService, "tapi endpoint")
+	fs.Parse(args)
+
+	f := &tapi.CancelFilter{}
+	switch *typ {
+	case "":
+	case "buy":
+		f.OrderType = 1
+	case "sell":
+		f.OrderType = -1
+	default:
+		log.Fatalf("invalid type %q", *typ)
+	}
+	if *coins != "" {
+		f.Pairs = parsePairs(*coins)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	r, err := newClient(*service).CancelAll(ctx, f)
+	for _, o := range r.Cancelled {
+		fmt.Printf("cancelled %s %d\n", o.CoinPair, o.ID)
+	}
+	for _, o := range r.Filled {
+		fmt.Printf("filled %s %d\n", o.CoinPair, o.ID)
+	}
+	for _, fl := range r.Failed {
+		fmt.Printf("failed %s %d: %v\n", fl.Order.CoinPair, fl.Order.ID, fl.Err)
+	}
+	for p, err := range r.ListErrors {
+		fmt.Printf("failed to list %s: %v\n", p, err)
+	}
+	fmt.Println(r)
+	if err != nil {
+		log.Print(err)
+	}
+	if !r.OK() {
+		os.Exit(1)
+	}
+}
+
+func deadMan(args []string) {
+	fs := flag.NewFlagSet("deadman", flag.ExitOnError)
+	storePath := fs.String("store", "", "order store file shared with the strategy")
+	heartbeat := fs.String("heartbeat", "", "heartbeat file written by the strategy")
+	account := fs.String("account", "main", "account of the orders in the store")
+	coins := fs.String("coins", "BTC,LTC,BCH,XRP,ETH", "comma separated coins traded against BRL")
+	timeout := fs.Duration("timeout", time.Minute, "max time between heartbeats")
+	service := fs.String("service", tapi.DefaultService, "tapi endpoint")
+	fs.Parse(args)
+	if *storePath == "" || *heartbeat == "" {
+		log.Fatal(usage)
+	}
+
+	st, err := store.Open(*storePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	cfg := deadman.Config{Timeout: *timeout, HeartbeatFile: *heartbeat, Logger: logger}
+	s, err := deadman.NewSidecar(newClient(*service), st, *account, parsePairs(*coins), cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		cancel()
+	}()
+	logger.Printf("watching %s every %v", *heartbeat, *timeout/4)
+	s.Run(ctx)
+}
@@ -0,0 +1,138 @@
+// Command mbexport exports the orders, fills and withdrawals of a
+// Mercado Bitcoin account to files in CSV or JSON lines format.
+//
+// The records are appended to orders, operations and withdrawals files
+// in the output directory, and a cursor file keeps the last exported
+// order ID of each pair, the orders still open and the status of the
+// exported withdrawals, so a nightly run only fetches the new orders and
+// writes again the orders and withdrawals that changed.
+//
+// The API ID and key are read from the MBID and MBKEY environment
+// variables.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	tapi "github.com/rschio/mb-tapi"
+	"github.com/rschio/mb-tapi/export"
+)
+
+func main() {
+	format := flag.String("format", "csv", "output format: csv or jsonl")
+	dir := flag.String("out", ".", "output directory")
+	cursor := flag.String("cursor", "", "cursor file (default OUT/cursor.json)")
+	coins := flag.String("coins", "BTC,LTC,BCH,XRP,ETH", "comma separated coins traded against BRL")
+	withdrawals := flag.String("withdrawals", "", "comma separated withdrawals to export, as COIN:ID")
+	service := flag.String("service", tapi.DefaultService, "tapi endpoint")
+	flag.Parse()
+
+	id := os.Getenv("MBID")
+	key := os.Getenv("MBKEY")
+	if id == "" || key == "" {
+		log.Fatalf("invalid ID or key")
+	}
+	f, err := export.ParseFormat(*format)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cs, err := parseCoins(*coins)
+	if err != nil {
+		log.Fatal(err)
+	}
+	refs, err := parseRefs(*withdrawals)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *cursor == "" {
+		*cursor = filepath.Join(*dir, "cursor.json")
+	}
+	cur, err := export.LoadCursor(*cursor)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	e := &export.Exporter{Src: tapi.NewClient(*service, id, key, nil)}
+	var files []*os.File
+	open := func(name string, cols []string) *export.Writer {
+		ext := ".csv"
+		if f == export.JSONLines {
+			ext = ".jsonl"
+		}
+		path := filepath.Join(*dir, name+ext)
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		files = append(files, file)
+		st, err := file.Stat()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return export.NewWriter(file, f, cols, st.Size() == 0)
+	}
+	e.Orders = open("orders", export.OrderColumns)
+	e.Operations = open("operations", export.OperationColumns)
+	if len(refs) > 0 {
+		e.Withdrawals = open("withdrawals", export.WithdrawalColumns)
+	}
+
+	err = e.ExportOrders(cs, cur)
+	if err == nil {
+		err = e.ExportWithdrawals(refs, cur)
+	}
+	// Save the cursor even on failure, the records exported before the
+	// error are already written.
+	if serr := cur.Save(*cursor); serr != nil {
+		log.Println(serr)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, file := range files {
+		if err := file.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func parseCoins(s string) ([]tapi.Coin, error) {
+	var cs []tapi.Coin
+	for _, name := range strings.Split(s, ",") {
+		c, err := tapi.ParseCoin(strings.ToUpper(strings.TrimSpace(name)))
+		if err != nil {
+			return nil, err
+		}
+		cs = append(cs, c)
+	}
+	return cs, nil
+}
+
+func parseRefs(s string) ([]export.WithdrawalRef, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var refs []export.WithdrawalRef
+	for _, r := range strings.Split(s, ",") {
+		parts := strings.SplitN(strings.TrimSpace(r), ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid withdrawal %q", r)
+		}
+		c, err := tapi.ParseCoin(strings.ToUpper(parts[0]))
+		if err != nil {
+			return nil, err
+		}
+		id, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid withdrawal %q", r)
+		}
+		refs = append(refs, export.WithdrawalRef{Coin: c, ID: id})
+	}
+	return refs, nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	tapi "github.com/rschio/mb-tapi"
+)
+
+// Method groups usable in the allowlists. withdraw_coin is in no group,
+// it must be listed explicitly.
+var groups = map[string][]string{
+	"@read": {
+		"list_system_messages", "get_account_info", "get_order",
+		"list_orders", "list_orderbook", "get_withdrawal",
+	},
+	"@trade": {
+		"list_system_messages", "get_account_info", "get_order",
+		"list_orders", "list_orderbook", "get_withdrawal",
+		"place_buy_order", "place_sell_order", "place_market_buy_order",
+		"place_market_sell_order", "cancel_order",
+	},
+}
+
+// ClientConfig is an internal client of the gateway.
+type ClientConfig struct {
+	// ID and Key are the TAPI-ID and key the client signs with.
+	ID  string `json:"id"`
+	Key string `json:"key"`
+
+	// Methods are the allowed tapi methods or groups.
+	Methods []string `json:"methods"`
+}
+
+// Config is the configuration file of the gateway.
+type Config struct {
+	Listen   string `json:"listen"`
+	Upstream string `json:"upstream"`
+
+	// RateLimit is the max requests per RatePeriod sent upstream by
+	// all clients together.
+	RateLimit  int    `json:"rate_limit"`
+	RatePeriod string `json:"rate_period"`
+
+	// NonceWindow is the max distance between the nonces of the
+	// clients and the time of the exchange, as estimated from the
+	// upstream responses, 1m by default.
+	NonceWindow string `json:"nonce_window"`
+
+	Clients []ClientConfig `json:"clients"`
+}
+
+// LoadConfig reads the config file in path. The file has the keys of
+// the clients, so it must not be accessible by other users.
+func LoadConfig(path string) (*Config, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("%s has permissions %v, expected 0600", path, fi.Mode().Perm())
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{Listen: "127.0.0.1:8080", Upstream: tapi.DefaultService}
+	if err := json.Unmarshal(b, cfg); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+type client struct {
+	key     string
+	methods map[string]bool
+}
+
+// Gateway verifies the requests of the internal clients and forwards
+// them upstream signed with the account key. The requests are sent one
+// at a time, so the nonces reach the exchange in order.
+type Gateway struct {
+	clients  map[string]client
+	verifier *tapi.Verifier
+	logger   *log.Logger
+
+	mu       sync.Mutex
+	upstream *tapi.Client
+}
+
+// NewGateway creates a Gateway that forwards to upstream. The nonces of
+// the clients must be within window of upstream.Now, zero meaning
+// tapi.DefaultNonceWindow.
+func NewGateway(clients []ClientConfig, upstream *tapi.Client, window time.Duration, logger *log.Logger) (*Gateway, error) {
+	g := &Gateway{
+		clients:  make(map[string]client),
+		verifier: tapi.NewVerifier(tapi.VerifierConfig{Window: window, Now: upstream.Now}),
+		logger:   logger,
+		upstream: upstream,
+	}
+	for _, c := range clients {
+		if c.ID == "" || c.Key == "" {
+			return nil, errors.New("client without id or key")
+		}
+		if _, ok := g.clients[c.ID]; ok {
+			return nil, fmt.Errorf("duplicated client %q", c.ID)
+		}
+		methods := make(map[string]bool)
+		for _, m := range c.Methods {
+			if strings.HasPrefix(m, "@") {
+				group, ok := groups[m]
+				if !ok {
+					return nil, fmt.Errorf("client %q: unknown group %q", c.ID, m)
+				}
+				for _, gm := range group {
+					methods[gm] = true
+				}
+				continue
+			}
+			methods[m] = true
+		}
+		g.clients[c.ID] = client{key: c.Key, methods: methods}
+	}
+	return g, nil
+}
+
+func (g *Gateway) key(id string) (string, error) {
+	c, ok := g.clients[id]
+	if !ok {
+		return "", fmt.Errorf("unknown client %q", id)
+	}
+	return c.key, nil
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	id, params, err := g.verifier.Verify(r, g.key)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	method := params.Get("tapi_method")
+	if !g.clients[id].methods[method] {
+		g.logger.Printf("denied %s to %s", method, id)
+		writeError(w, http.StatusForbidden, fmt.Sprintf("method %q not allowed", method))
+		return
+	}
+	resp, err := g.forward(params)
+	var e *tapi.Error
+	switch {
+	case errors.As(err, &e):
+		resp = &tapi.Response{StatusCode: e.Code, ErrorMessage: e.Err}
+	case err != nil:
+		g.logger.Printf("%s from %s: %v", method, id, err)
+		writeError(w, http.StatusBadGateway, "upstream error")
+		return
+	}
+	g.logger.Printf("%s from %s: %d", method, id, resp.StatusCode)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// forward sends params upstream with a new nonce.
+func (g *Gateway) forward(params url.Values) (*tapi.Response, error) {
+	params.Del("tapi_nonce")
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.upstream.MakeRequest(params)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(&tapi.Response{StatusCode: code, ErrorMessage: msg})
+}
+
+func parsePeriod(s string) (time.Duration, error) {
+	if s == "" {
+		return time.Minute, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("rate period %s must be positive", s)
+	}
+	return d, nil
+}
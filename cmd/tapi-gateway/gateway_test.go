@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	tapi "github.com/rschio/mb-tapi"
+)
+
+const accountKey = "account-key"
+
+// upstream is a fake tapi that checks the account signature and that
+// the nonces arrive in order.
+type upstream struct {
+	mu        sync.Mutex
+	last      int64
+	outOfSync bool
+	methods   []string
+}
+
+func (u *upstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_, params, err := tapi.VerifyRequest(r, func(id string) (string, error) {
+		if id != "account" {
+			return "", errors.New("unknown id")
+		}
+		return accountKey, nil
+	})
+	if err != nil {
+		w.Write([]byte(`{"status_code":203,"error_message":"` + err.Error() + `"}`))
+		return
+	}
+	nonce, _ := strconv.ParseInt(params.Get("tapi_nonce"), 10, 64)
+	u.mu.Lock()
+	if nonce <= u.last {
+		u.outOfSync = true
+	}
+	u.last = nonce
+	u.methods = append(u.methods, params.Get("tapi_method"))
+	u.mu.Unlock()
+	w.Write([]byte(`{"response_data":{"balance":{"brl":{"available":"1.00","total":"1.00"}}},"status_code":100,"server_unix_timestamp":"1"}`))
+}
+
+func newTestGateway(t *testing.T) (*upstream, string, func()) {
+	up := &upstream{}
+	upSrv := httptest.NewServer(up)
+	g, err := NewGateway([]ClientConfig{
+		{ID: "reports", Key: "reports-key", Methods: []string{"@read"}},
+		{ID: "bot", Key: "bot-key", Methods: []string{"@trade"}},
+		{ID: "treasury", Key: "treasury-key", Methods: []string{"withdraw_coin"}},
+	}, tapi.NewClient(upSrv.URL+"/tapi/v3/", "account", accountKey, nil), 0,
+		log.New(ioutil.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gSrv := httptest.NewServer(g)
+	return up, gSrv.URL + "/tapi/v3/", func() {
+		gSrv.Close()
+		upSrv.Close()
+	}
+}
+
+func TestGatewayAllowlist(t *testing.T) {
+	up, service, done := newTestGateway(t)
+	defer done()
+	forbidden := &tapi.Error{Code: http.StatusForbidden}
+	unauthorized := &tapi.Error{Code: http.StatusUnauthorized}
+
+	reports := tapi.NewClient(service, "reports", "reports-key", nil)
+	info, err := reports.GetAccountInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Balance.BRL.Total != "1.00" {
+		t.Errorf("got balance %s, expected 1.00", info.Balance.BRL.Total)
+	}
+	if _, err := reports.PlaceBuyOrder(tapi.BRL, tapi.BTC, "1", "1"); !errors.Is(err, forbidden) {
+		t.Errorf("reports: got error %v, expected %v", err, forbidden)
+	}
+
+	bot := tapi.NewClient(service, "bot", "bot-key", nil)
+	if _, err := bot.GetAccountInfo(); err != nil {
+		t.Error(err)
+	}
+	if _, err := bot.WithdrawBRL("", "1", "1"); !errors.Is(err, forbidden) {
+		t.Errorf("bot: got error %v, expected %v", err, forbidden)
+	}
+
+	treasury := tapi.NewClient(service, "treasury", "treasury-key", nil)
+	if _, err := treasury.GetAccountInfo(); !errors.Is(err, forbidden) {
+		t.Errorf("treasury: got error %v, expected %v", err, forbidden)
+	}
+
+	wrong := tapi.NewClient(service, "bot", "reports-key", nil)
+	if _, err := wrong.GetAccountInfo(); !errors.Is(err, unauthorized) {
+		t.Errorf("wrong key: got error %v, expected %v", err, unauthorized)
+	}
+	if len(up.methods) != 2 {
+		t.Errorf("got %d upstream requests, expected 2", len(up.methods))
+	}
+}
+
+func TestGatewaySerializesNonces(t *testing.T) {
+	up, service, done := newTestGateway(t)
+	defer done()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := tapi.NewClient(service, "bot", "bot-key", nil)
+			for j := 0; j < 10; j++ {
+				if _, err := c.GetAccountInfo(); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if up.outOfSync {
+		t.Error("nonces arrived out of order")
+	}
+	if len(up.methods) != 40 {
+		t.Errorf("got %d upstream requests, expected 40", len(up.methods))
+	}
+}
+
+func TestNewGatewayErrors(t *testing.T) {
+	c := tapi.NewClient(tapi.DefaultService, "", "", nil)
+	logger := log.New(ioutil.Discard, "", 0)
+	if _, err := NewGateway([]ClientConfig{{ID: "a", Key: "k", Methods: []string{"@admin"}}}, c, 0, logger); err == nil {
+		t.Error("expected error of unknown group")
+	}
+	if _, err := NewGateway([]ClientConfig{{ID: "a", Key: "k"}, {ID: "a", Key: "j"}}, c, 0, logger); err == nil {
+		t.Error("expected error of duplicated client")
+	}
+}
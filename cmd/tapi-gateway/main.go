@@ -0,0 +1,77 @@
+// Command tapi-gateway is an HTTP proxy that shares a single tapi key
+// with internal services.
+//
+// The services sign their requests as usual with their own TAPI-ID and
+// key from the config file, so a tapi.Client can use the gateway as its
+// service, such as http://127.0.0.1:8080/tapi/v3/. The gateway checks
+// the signature, nonce and method allowlist of the client, then sends
+// the request upstream signed with the account key, one at a time and
+// within a global rate limit. The nonces of the clients must be within
+// nonce_window of the time of the exchange, estimated from the upstream
+// responses, so the clients should sync with a tapi.Clock too.
+//
+// The account ID and key are read from the MBID and MBKEY environment
+// variables. An example config:
+//
+//	{
+//		"listen": "127.0.0.1:8080",
+//		"rate_limit": 100,
+//		"rate_period": "1m",
+//		"nonce_window": "1m",
+//		"clients": [
+//			{"id": "reports", "key": "...", "methods": ["@read"]},
+//			{"id": "bot", "key": "...", "methods": ["@trade"]}
+//		]
+//	}
+//
+// The group @read has the query methods and @trade adds the order
+// methods. withdraw_coin must be listed by name.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	tapi "github.com/rschio/mb-tapi"
+)
+
+func main() {
+	config := flag.String("config", "gateway.json", "config file")
+	flag.Parse()
+
+	cfg, err := LoadConfig(*config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	period, err := parsePeriod(cfg.RatePeriod)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var window time.Duration
+	if cfg.NonceWindow != "" {
+		if window, err = time.ParseDuration(cfg.NonceWindow); err != nil {
+			log.Fatal(err)
+		}
+	}
+	creds := tapi.EnvProvider{IDVar: "MBID", KeyVar: "MBKEY"}
+	if _, err := creds.Credentials(); err != nil {
+		log.Fatal(err)
+	}
+	// The clients with a Clock sync with the timestamps forwarded by the
+	// gateway, so their nonces follow the time of the exchange.
+	opts := []tapi.Option{tapi.WithCredentials(creds), tapi.WithClock(tapi.NewClock(tapi.ClockConfig{}))}
+	if cfg.RateLimit > 0 {
+		opts = append(opts, tapi.WithRateLimiter(tapi.NewRateLimiter(cfg.RateLimit, period)))
+	}
+	upstream := tapi.NewClient(cfg.Upstream, "", "", nil, opts...)
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	g, err := NewGateway(cfg.Clients, upstream, window, logger)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logger.Printf("listening on %s", cfg.Listen)
+	log.Fatal(http.ListenAndServe(cfg.Listen, g))
+}
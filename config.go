@@ -0,0 +1,70 @@
+package tapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Version is a version of the trade API.
+type Version int
+
+const (
+	V3 Version = 3
+	V4 Version = 4
+)
+
+// Config selects and configures the API version used by Open.
+type Config struct {
+	// Version is the API version. Zero means V3.
+	Version Version
+
+	// Service is the endpoint. Empty means the default endpoint of
+	// the version.
+	Service string
+
+	// Credentials provides the TAPI-ID and key on v3, or the login
+	// and password of the token on v4.
+	Credentials CredentialProvider
+
+	// AccountID is the v4 account. Empty means the first account.
+	AccountID string
+
+	// HTTPClient is the client of the requests. Nil means
+	// http.DefaultClient.
+	HTTPClient *http.Client
+
+	// Options configures the v3 Client. The v4 client only supports
+	// WithMarkets and WithRounding.
+	Options []Option
+}
+
+// Open creates a client of the version in cfg.
+func Open(cfg Config) (API, error) {
+	if cfg.Credentials == nil {
+		return nil, errors.New("nil credentials")
+	}
+	switch cfg.Version {
+	case 0, V3:
+		if cfg.Service == "" {
+			cfg.Service = DefaultService
+		}
+		opts := append([]Option{WithCredentials(cfg.Credentials)}, cfg.Options...)
+		return NewClient(cfg.Service, "", "", cfg.HTTPClient, opts...), nil
+	case V4:
+		if cfg.Service == "" {
+			cfg.Service = DefaultServiceV4
+		}
+		v3 := Client{orderRules: orderRules{markets: DefaultMarkets}}
+		for _, opt := range cfg.Options {
+			opt(&v3)
+		}
+		if v3.creds != nil || v3.limiter != nil || v3.breaker != nil || v3.clock != nil {
+			return nil, errors.New("only WithMarkets and WithRounding are supported by v4")
+		}
+		c := NewClientV4(cfg.Service, cfg.Credentials, cfg.AccountID, cfg.HTTPClient)
+		c.orderRules = v3.orderRules
+		return c, nil
+	}
+	return nil, fmt.Errorf("invalid API version %d", cfg.Version)
+}
@@ -0,0 +1,167 @@
+package tapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// Credentials are the TAPI-ID and the secret key used to sign the
+// requests. Printing Credentials never shows the key.
+type Credentials struct {
+	ID  string `json:"id"`
+	Key string `json:"key"`
+}
+
+func (c Credentials) String() string {
+	return fmt.Sprintf("{ID:%s Key:[redacted]}", c.ID)
+}
+
+// GoString redacts the key of %#v.
+func (c Credentials) GoString() string {
+	return fmt.Sprintf("tapi.Credentials{ID:%q, Key:\"[redacted]\"}", c.ID)
+}
+
+// CredentialProvider is asked for the credentials before each request
+// is signed, so a provider can rotate them without rebuilding the
+// Client.
+type CredentialProvider interface {
+	Credentials() (Credentials, error)
+}
+
+// ErrNoCredentials is returned when a provider has no credentials.
+var ErrNoCredentials = errors.New("no credentials")
+
+// ErrInsecureFile is returned when a credentials file can be read or
+// written by other users.
+var ErrInsecureFile = errors.New("credentials file permissions are not 0600")
+
+// StaticProvider returns fixed credentials that can be replaced with
+// Set. It is safe for concurrent use. Printing a StaticProvider never
+// shows the key.
+type StaticProvider struct {
+	mu    sync.RWMutex
+	creds Credentials
+}
+
+// NewStaticProvider creates a StaticProvider of id and key.
+func NewStaticProvider(id, key string) *StaticProvider {
+	return &StaticProvider{creds: Credentials{ID: id, Key: key}}
+}
+
+// Credentials returns the current credentials.
+func (p *StaticProvider) Credentials() (Credentials, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.creds, nil
+}
+
+func (p *StaticProvider) String() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return fmt.Sprintf("StaticProvider%v", p.creds)
+}
+
+// GoString redacts the key of %#v.
+func (p *StaticProvider) GoString() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return fmt.Sprintf("&tapi.StaticProvider{creds:%#v}", p.creds)
+}
+
+// Set replaces the credentials.
+func (p *StaticProvider) Set(c Credentials) {
+	p.mu.Lock()
+	p.creds = c
+	p.mu.Unlock()
+}
+
+// EnvProvider reads the credentials from environment variables on each
+// request.
+type EnvProvider struct {
+	IDVar  string
+	KeyVar string
+}
+
+// Credentials returns the values of IDVar and KeyVar.
+func (p EnvProvider) Credentials() (Credentials, error) {
+	c := Credentials{ID: os.Getenv(p.IDVar), Key: os.Getenv(p.KeyVar)}
+	if c.ID == "" || c.Key == "" {
+		return Credentials{}, fmt.Errorf("%w in $%s and $%s", ErrNoCredentials, p.IDVar, p.KeyVar)
+	}
+	return c, nil
+}
+
+// FileProvider reads the credentials from a JSON file with the fields
+// "id" and "key" on each request. The file must not be accessible by
+// other users.
+type FileProvider struct {
+	Path string
+}
+
+// Credentials reads the file.
+func (p FileProvider) Credentials() (Credentials, error) {
+	b, err := readPrivateFile(p.Path)
+	if err != nil {
+		return Credentials{}, err
+	}
+	var c Credentials
+	if err := json.Unmarshal(b, &c); err != nil {
+		return Credentials{}, fmt.Errorf("invalid credentials file %s: %v", p.Path, err)
+	}
+	if c.ID == "" || c.Key == "" {
+		return Credentials{}, fmt.Errorf("%w in %s", ErrNoCredentials, p.Path)
+	}
+	return c, nil
+}
+
+func readPrivateFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("%w: %s has %v", ErrInsecureFile, path, fi.Mode().Perm())
+	}
+	return ioutil.ReadAll(f)
+}
+
+// WithCredentials makes the client ask p for the credentials instead of
+// using the apiID and apiKey of NewClient.
+func WithCredentials(p CredentialProvider) Option {
+	return func(c *Client) { c.creds = p }
+}
+
+// SetCredentials replaces the credential provider of the client. The
+// requests already signed keep the old credentials.
+func (c *Client) SetCredentials(p CredentialProvider) {
+	c.mu.Lock()
+	c.creds = p
+	c.mu.Unlock()
+}
+
+func (c *Client) credentials() (Credentials, error) {
+	c.mu.RLock()
+	p := c.creds
+	c.mu.RUnlock()
+	if p == nil {
+		return Credentials{}, ErrNoCredentials
+	}
+	creds, err := p.Credentials()
+	if err != nil {
+		return Credentials{}, err
+	}
+	// An empty key would sign the request with an empty secret.
+	if creds.ID == "" || creds.Key == "" {
+		return Credentials{}, ErrNoCredentials
+	}
+	return creds, nil
+}
@@ -0,0 +1,174 @@
+package tapi
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCredentialsNotPrinted(t *testing.T) {
+	c := NewClient(DefaultService, fakeID, fakeKey, nil)
+	creds, _ := c.credentials()
+	p := NewStaticProvider(fakeID, fakeKey)
+	for _, s := range []string{
+		fmt.Sprintf("%v", p), fmt.Sprintf("%+v", p), fmt.Sprintf("%#v", p),
+		fmt.Sprintf("%+v", []*StaticProvider{p}),
+		fmt.Sprintf("%v", c), fmt.Sprintf("%+v", c), fmt.Sprintf("%#v", c),
+		fmt.Sprintf("%v", creds), fmt.Sprintf("%+v", creds), fmt.Sprintf("%#v", creds),
+	} {
+		if strings.Contains(s, fakeKey) {
+			t.Errorf("key in output %q", s)
+		}
+	}
+}
+
+func TestCredentialRotation(t *testing.T) {
+	var gotID string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotID = r.Header.Get("TAPI-ID")
+		r.ParseForm()
+		if sign("key-"+gotID, r.URL.Path+"?"+r.PostForm.Encode()) != r.Header.Get("TAPI-MAC") {
+			w.Write([]byte(`{"status_code":203,"error_message":"invalid mac"}`))
+			return
+		}
+		w.Write(jsonGetOrder)
+	}))
+	defer srv.Close()
+	p := NewStaticProvider("a", "key-a")
+	c := NewClient(srv.URL+"/", "", "", nil, WithCredentials(p))
+	if _, err := c.GetOrder(BRL, BTC, 1); err != nil || gotID != "a" {
+		t.Fatalf("got id %q and error %v, expected a", gotID, err)
+	}
+	p.Set(Credentials{ID: "b", Key: "key-b"})
+	if _, err := c.GetOrder(BRL, BTC, 1); err != nil || gotID != "b" {
+		t.Fatalf("got id %q and error %v, expected b", gotID, err)
+	}
+	c.SetCredentials(NewStaticProvider("c", "key-c"))
+	if _, err := c.GetOrder(BRL, BTC, 1); err != nil || gotID != "c" {
+		t.Fatalf("got id %q and error %v, expected c", gotID, err)
+	}
+}
+
+func TestEnvProvider(t *testing.T) {
+	os.Setenv("TAPI_TEST_ID", "id")
+	os.Setenv("TAPI_TEST_KEY", "key")
+	defer os.Unsetenv("TAPI_TEST_ID")
+	defer os.Unsetenv("TAPI_TEST_KEY")
+	p := EnvProvider{IDVar: "TAPI_TEST_ID", KeyVar: "TAPI_TEST_KEY"}
+	c, err := p.Credentials()
+	if err != nil || c.ID != "id" || c.Key != "key" {
+		t.Errorf("got %v %v, expected id", c, err)
+	}
+	os.Unsetenv("TAPI_TEST_KEY")
+	if _, err := p.Credentials(); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("got error %v, expected %v", err, ErrNoCredentials)
+	}
+}
+
+func TestFileProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "creds.json")
+	if err := ioutil.WriteFile(path, []byte(`{"id":"id","key":"key"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := FileProvider{Path: path}
+	if _, err := p.Credentials(); !errors.Is(err, ErrInsecureFile) {
+		t.Errorf("got error %v, expected %v", err, ErrInsecureFile)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := p.Credentials()
+	if err != nil || c.ID != "id" || c.Key != "key" {
+		t.Errorf("got %v %v, expected id", c, err)
+	}
+}
+
+func TestKeystore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keystore")
+	pass := []byte("correct horse")
+	err = WriteKeystore(path, pass, map[string]Credentials{
+		"main": {ID: "id1", Key: "key1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenKeystore(path, "main", []byte("wrong")); !errors.Is(err, ErrKeystorePassphrase) {
+		t.Errorf("got error %v, expected %v", err, ErrKeystorePassphrase)
+	}
+	if _, err := OpenKeystore(path, "other", pass); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("got error %v, expected %v", err, ErrNoCredentials)
+	}
+	k, err := OpenKeystore(path, "main", pass)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadFile(path)
+	if strings.Contains(string(b), "key1") {
+		t.Error("key saved in plain text")
+	}
+	err = WriteKeystore(path, pass, map[string]Credentials{
+		"main": {ID: "id2", Key: "key2-rotated"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := k.Credentials()
+	if err != nil || c.ID != "id2" || c.Key != "key2-rotated" {
+		t.Errorf("got %v %v, expected id2", c, err)
+	}
+
+	// The iteration count of the file is bounded.
+	for _, iter := range []int{0, 1, 1 << 40} {
+		var kf keystoreFile
+		b, _ := ioutil.ReadFile(path)
+		if err := json.Unmarshal(b, &kf); err != nil {
+			t.Fatal(err)
+		}
+		kf.Iter = iter
+		b, _ = json.Marshal(kf)
+		if err := ioutil.WriteFile(path, b, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := OpenKeystore(path, "main", pass); err == nil || !strings.Contains(err.Error(), "iteration count") {
+			t.Errorf("iter %d: got error %v, expected iteration count out of range", iter, err)
+		}
+	}
+}
+
+func TestPBKDF2(t *testing.T) {
+	// RFC 7914 section 11.
+	tests := []struct {
+		pass, salt string
+		iter       int
+		expected   string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" +
+			"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56" +
+			"a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	}
+	for _, tt := range tests {
+		got := hex.EncodeToString(pbkdf2([]byte(tt.pass), []byte(tt.salt), tt.iter, 64))
+		if got != tt.expected {
+			t.Errorf("got %s, expected %s", got, tt.expected)
+		}
+	}
+}
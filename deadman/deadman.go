@@ -0,0 +1,338 @@
+// Package deadman cancels the open orders of a strategy that stops
+// sending heartbeats.
+//
+// In process, a Watchdog wraps the tapi.API of the strategy, keeps the
+// orders placed through it and cancels the open ones when Heartbeat is
+// not called within the timeout. As a sidecar, a Sidecar reads the
+// heartbeat file written by the Watchdog of the strategy and cancels the
+// open orders of the account found in a shared order store, so it also
+// works when the strategy process hangs as a whole.
+package deadman
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tapi "github.com/rschio/mb-tapi"
+	"github.com/rschio/mb-tapi/store"
+)
+
+// Config configures a Watchdog or a Sidecar.
+type Config struct {
+	// Timeout is the max time between heartbeats.
+	Timeout time.Duration
+
+	// HeartbeatFile is the file where the Watchdog writes the time of
+	// each heartbeat, read by the Sidecar. Empty means in memory only.
+	HeartbeatFile string
+
+	// Logger logs the orders cancelled. Nil means the standard logger.
+	Logger *log.Logger
+}
+
+// ErrTimeout is returned when the Config has no positive Timeout.
+var ErrTimeout = errors.New("deadman: timeout must be positive")
+
+func (c *Config) logger() *log.Logger {
+	if c.Logger == nil {
+		return log.New(os.Stderr, "", log.LstdFlags)
+	}
+	return c.Logger
+}
+
+type ref struct {
+	c1, c2 tapi.Coin
+	id     int
+}
+
+// cancel cancels the orders and returns the ones that failed. An order
+// already closed is not a failure.
+func cancel(api tapi.API, refs []ref, logger *log.Logger) []ref {
+	var failed []ref
+	for _, r := range refs {
+		o, err := api.CancelOrder(r.c1, r.c2, r.id)
+		if err != nil {
+			if g, gerr := api.GetOrder(r.c1, r.c2, r.id); gerr == nil && g.Status != tapi.OrderStatusOpen {
+				logger.Printf("deadman: order %d of %v%v already closed", r.id, r.c1, r.c2)
+				continue
+			}
+			logger.Printf("deadman: cancel order %d of %v%v: %v", r.id, r.c1, r.c2, err)
+			failed = append(failed, r)
+			continue
+		}
+		logger.Printf("deadman: cancelled order %d of %v%v, executed %s of %s",
+			r.id, r.c1, r.c2, o.ExecutedQuantity, o.Quantity)
+	}
+	return failed
+}
+
+// run calls check every quarter of timeout until ctx is done.
+func run(ctx context.Context, timeout time.Duration, check func() (bool, error), logger *log.Logger) error {
+	d := timeout / 4
+	if d <= 0 {
+		d = timeout
+	}
+	t := time.NewTicker(d)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+		if _, err := check(); err != nil {
+			logger.Printf("deadman: %v", err)
+		}
+	}
+}
+
+// Watchdog is a tapi.API that cancels the open orders placed through it
+// when the heartbeats stop. Market orders are tracked too, as they stay
+// open when the book can not fill them at once. It is safe for
+// concurrent use.
+type Watchdog struct {
+	tapi.API
+
+	cfg    Config
+	logger *log.Logger
+	now    func() time.Time
+
+	mu     sync.Mutex
+	last   time.Time
+	orders map[int]ref
+}
+
+// New creates a Watchdog of api. The timeout starts to count at the
+// creation.
+func New(api tapi.API, cfg Config) (*Watchdog, error) {
+	if cfg.Timeout <= 0 {
+		return nil, ErrTimeout
+	}
+	w := &Watchdog{
+		API:    api,
+		cfg:    cfg,
+		logger: cfg.logger(),
+		now:    time.Now,
+		orders: make(map[int]ref),
+	}
+	w.last = w.now()
+	return w, nil
+}
+
+// Heartbeat tells the watchdog the strategy is alive.
+func (w *Watchdog) Heartbeat() error {
+	now := w.now()
+	w.mu.Lock()
+	w.last = now
+	w.mu.Unlock()
+	if w.cfg.HeartbeatFile == "" {
+		return nil
+	}
+	return writeHeartbeat(w.cfg.HeartbeatFile, now)
+}
+
+func (w *Watchdog) track(c1, c2 tapi.Coin, o *tapi.Order, err error) (*tapi.Order, error) {
+	if err == nil && o.Status == tapi.OrderStatusOpen {
+		w.mu.Lock()
+		w.orders[o.ID] = ref{c1, c2, o.ID}
+		w.mu.Unlock()
+	}
+	return o, err
+}
+
+// PlaceBuyOrder places and tracks a buy order.
+func (w *Watchdog) PlaceBuyOrder(c1, c2 tapi.Coin, qt, limit string) (*tapi.Order, error) {
+	o, err := w.API.PlaceBuyOrder(c1, c2, qt, limit)
+	return w.track(c1, c2, o, err)
+}
+
+// PlaceSellOrder places and tracks a sell order.
+func (w *Watchdog) PlaceSellOrder(c1, c2 tapi.Coin, qt, limit string) (*tapi.Order, error) {
+	o, err := w.API.PlaceSellOrder(c1, c2, qt, limit)
+	return w.track(c1, c2, o, err)
+}
+
+// PlaceMarketBuyOrder places a market buy order and tracks it if it is
+// still open.
+func (w *Watchdog) PlaceMarketBuyOrder(c1, c2 tapi.Coin, cost string) (*tapi.Order, error) {
+	o, err := w.API.PlaceMarketBuyOrder(c1, c2, cost)
+	return w.track(c1, c2, o, err)
+}
+
+// PlaceMarketSellOrder places a market sell order and tracks it if it is
+// still open.
+func (w *Watchdog) PlaceMarketSellOrder(c1, c2 tapi.Coin, qt string) (*tapi.Order, error) {
+	o, err := w.API.PlaceMarketSellOrder(c1, c2, qt)
+	return w.track(c1, c2, o, err)
+}
+
+// CancelOrder cancels an order and stops tracking it.
+func (w *Watchdog) CancelOrder(c1, c2 tapi.Coin, id int) (*tapi.Order, error) {
+	o, err := w.API.CancelOrder(c1, c2, id)
+	if err == nil {
+		w.mu.Lock()
+		delete(w.orders, id)
+		w.mu.Unlock()
+	}
+	return o, err
+}
+
+// Check cancels the tracked orders if the last heartbeat is older than
+// the timeout. It reports whether the timeout expired. The orders that
+// failed to cancel are kept for the next check.
+func (w *Watchdog) Check() (bool, error) {
+	w.mu.Lock()
+	if w.now().Sub(w.last) <= w.cfg.Timeout {
+		w.mu.Unlock()
+		return false, nil
+	}
+	refs := make([]ref, 0, len(w.orders))
+	for _, r := range w.orders {
+		refs = append(refs, r)
+	}
+	w.orders = make(map[int]ref)
+	w.mu.Unlock()
+	if len(refs) == 0 {
+		return true, nil
+	}
+	w.logger.Printf("deadman: no heartbeat for %v, cancelling %d orders", w.cfg.Timeout, len(refs))
+	failed := cancel(w.API, refs, w.logger)
+	w.mu.Lock()
+	for _, r := range failed {
+		w.orders[r.id] = r
+	}
+	w.mu.Unlock()
+	if len(failed) > 0 {
+		return true, fmt.Errorf("%d orders not cancelled", len(failed))
+	}
+	return true, nil
+}
+
+// Run checks the heartbeats until ctx is done.
+func (w *Watchdog) Run(ctx context.Context) error {
+	return run(ctx, w.cfg.Timeout, w.Check, w.logger)
+}
+
+func writeHeartbeat(path string, t time.Time) error {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(strconv.FormatInt(t.UnixNano(), 10)), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func readHeartbeat(path string) (time.Time, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	ns, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid heartbeat file %s", path)
+	}
+	return time.Unix(0, ns), nil
+}
+
+// Sidecar cancels the open orders of an account when the heartbeat file
+// of its strategy gets older than the timeout.
+type Sidecar struct {
+	api     tapi.API
+	store   *store.Store
+	account string
+	pairs   [][2]tapi.Coin
+	cfg     Config
+	logger  *log.Logger
+	now     func() time.Time
+
+	// cancelled are the orders cancelled still open in the store.
+	cancelled map[string]bool
+}
+
+// NewSidecar creates a Sidecar that cancels, with api, the open orders
+// of the pairs of account in s. On timeout the pairs are synced first,
+// to find the orders placed since the last sync of the strategy.
+func NewSidecar(api tapi.API, s *store.Store, account string, pairs [][2]tapi.Coin, cfg Config) (*Sidecar, error) {
+	if cfg.Timeout <= 0 {
+		return nil, ErrTimeout
+	}
+	if cfg.HeartbeatFile == "" {
+		return nil, errors.New("deadman: sidecar without heartbeat file")
+	}
+	return &Sidecar{
+		api:       api,
+		store:     s,
+		account:   account,
+		pairs:     pairs,
+		cfg:       cfg,
+		logger:    cfg.logger(),
+		now:       time.Now,
+		cancelled: make(map[string]bool),
+	}, nil
+}
+
+// Check cancels the open orders if the heartbeat is older than the
+// timeout. It reports whether the timeout expired. A missing heartbeat
+// file counts as expired.
+func (s *Sidecar) Check() (bool, error) {
+	last, err := readHeartbeat(s.cfg.HeartbeatFile)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	if s.now().Sub(last) <= s.cfg.Timeout {
+		s.cancelled = make(map[string]bool)
+		return false, nil
+	}
+	for _, p := range s.pairs {
+		if _, err := s.store.Sync(s.account, s.api, p[0], p[1]); err != nil {
+			s.logger.Printf("deadman: sync %v%v: %v", p[0], p[1], err)
+		}
+	}
+	var refs []ref
+	for _, p := range s.pairs {
+		orders, err := s.store.Orders(store.Query{
+			Account:  s.account,
+			Pair:     p[0].String() + p[1].String(),
+			Statuses: []int{tapi.OrderStatusOpen},
+		})
+		if err != nil {
+			return true, err
+		}
+		for _, o := range orders {
+			key := o.CoinPair + strconv.Itoa(o.ID)
+			if !s.cancelled[key] {
+				refs = append(refs, ref{p[0], p[1], o.ID})
+			}
+		}
+	}
+	if len(refs) == 0 {
+		return true, nil
+	}
+	s.logger.Printf("deadman: heartbeat older than %v, cancelling %d orders of %s", s.cfg.Timeout, len(refs), s.account)
+	failed := cancel(s.api, refs, s.logger)
+	notCancelled := make(map[int]bool)
+	for _, r := range failed {
+		notCancelled[r.id] = true
+	}
+	for _, r := range refs {
+		if !notCancelled[r.id] {
+			s.cancelled[r.c1.String()+r.c2.String()+strconv.Itoa(r.id)] = true
+		}
+	}
+	if len(failed) > 0 {
+		return true, fmt.Errorf("%d orders not cancelled", len(failed))
+	}
+	return true, nil
+}
+
+// Run checks the heartbeat until ctx is done.
+func (s *Sidecar) Run(ctx context.Context) error {
+	return run(ctx, s.cfg.Timeout, s.Check, s.logger)
+}
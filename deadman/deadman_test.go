@@ -0,0 +1,170 @@
+package deadman
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	tapi "github.com/rschio/mb-tapi"
+	"github.com/rschio/mb-tapi/store"
+)
+
+type fakeAPI struct {
+	tapi.API
+
+	orders    []tapi.Order
+	cancelled []int
+}
+
+func (f *fakeAPI) PlaceBuyOrder(c1, c2 tapi.Coin, qt, limit string) (*tapi.Order, error) {
+	o := tapi.Order{ID: len(f.orders) + 1, CoinPair: "BRLBTC", Status: tapi.OrderStatusOpen,
+		Quantity: qt, LimitPrice: limit, ExecutedQuantity: "0", CreatedTimestamp: "1600000000"}
+	f.orders = append(f.orders, o)
+	return &o, nil
+}
+
+func (f *fakeAPI) PlaceMarketSellOrder(c1, c2 tapi.Coin, qt string) (*tapi.Order, error) {
+	// Half is filled, the rest stays open.
+	o := tapi.Order{ID: len(f.orders) + 1, CoinPair: "BRLBTC", Status: tapi.OrderStatusOpen,
+		Quantity: qt, ExecutedQuantity: "0.5", CreatedTimestamp: "1600000000"}
+	f.orders = append(f.orders, o)
+	return &o, nil
+}
+
+func (f *fakeAPI) CancelOrder(c1, c2 tapi.Coin, id int) (*tapi.Order, error) {
+	f.cancelled = append(f.cancelled, id)
+	f.orders[id-1].Status = tapi.OrderStatusCancelled
+	o := f.orders[id-1]
+	return &o, nil
+}
+
+func (f *fakeAPI) ListOrders(c1, c2 tapi.Coin, opts *tapi.ListOrdersOpts) ([]tapi.Order, error) {
+	var res []tapi.Order
+	for _, o := range f.orders {
+		if o.ID >= opts.FromID && (opts.ToID == 0 || o.ID <= opts.ToID) {
+			res = append(res, o)
+		}
+	}
+	return res, nil
+}
+
+func TestWatchdog(t *testing.T) {
+	f := &fakeAPI{}
+	var buf bytes.Buffer
+	w, err := New(f, Config{Timeout: time.Minute, Logger: log.New(&buf, "", 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1600000000, 0)
+	w.now = func() time.Time { return now }
+	w.Heartbeat()
+
+	w.PlaceBuyOrder(tapi.BRL, tapi.BTC, "1", "100")
+	w.PlaceBuyOrder(tapi.BRL, tapi.BTC, "1", "101")
+	w.CancelOrder(tapi.BRL, tapi.BTC, 1)
+	now = now.Add(30 * time.Second)
+	if tripped, _ := w.Check(); tripped {
+		t.Error("got tripped before the timeout")
+	}
+	now = now.Add(31 * time.Second)
+	tripped, err := w.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tripped || len(f.cancelled) != 2 || f.cancelled[1] != 2 {
+		t.Errorf("got tripped %v and cancelled %v, expected order 2 cancelled", tripped, f.cancelled)
+	}
+	if !strings.Contains(buf.String(), "cancelled order 2 of BRLBTC") {
+		t.Errorf("got log %q, expected order 2 cancelled", buf.String())
+	}
+	// The orders are not cancelled twice.
+	w.Check()
+	if len(f.cancelled) != 2 {
+		t.Errorf("got cancelled %v, expected [1 2]", f.cancelled)
+	}
+}
+
+func TestSidecar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadman")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := Config{
+		Timeout:       time.Minute,
+		HeartbeatFile: filepath.Join(dir, "heartbeat"),
+		Logger:        log.New(ioutil.Discard, "", 0),
+	}
+	now := time.Unix(1600000000, 0)
+
+	// The strategy places an order and heartbeats.
+	f := &fakeAPI{}
+	w, err := New(f, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.now = func() time.Time { return now }
+	if err := w.Heartbeat(); err != nil {
+		t.Fatal(err)
+	}
+	w.PlaceBuyOrder(tapi.BRL, tapi.BTC, "1", "100")
+
+	st, err := store.Open(filepath.Join(dir, "orders"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSidecar(f, st, "main", [][2]tapi.Coin{{tapi.BRL, tapi.BTC}}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.now = func() time.Time { return now.Add(30 * time.Second) }
+	if tripped, _ := s.Check(); tripped || len(f.cancelled) != 0 {
+		t.Errorf("got tripped %v and cancelled %v before the timeout", tripped, f.cancelled)
+	}
+	s.now = func() time.Time { return now.Add(2 * time.Minute) }
+	tripped, err := s.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tripped || len(f.cancelled) != 1 || f.cancelled[0] != 1 {
+		t.Errorf("got tripped %v and cancelled %v, expected order 1 cancelled", tripped, f.cancelled)
+	}
+}
+
+func TestMarketOrder(t *testing.T) {
+	f := &fakeAPI{}
+	w, err := New(f, Config{Timeout: time.Minute, Logger: log.New(ioutil.Discard, "", 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1600000000, 0)
+	w.now = func() time.Time { return now }
+	w.Heartbeat()
+
+	w.PlaceMarketSellOrder(tapi.BRL, tapi.BTC, "1")
+	now = now.Add(2 * time.Minute)
+	tripped, err := w.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tripped || len(f.cancelled) != 1 || f.cancelled[0] != 1 {
+		t.Errorf("got tripped %v and cancelled %v, expected order 1 cancelled", tripped, f.cancelled)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	for _, d := range []time.Duration{0, -time.Second} {
+		if _, err := New(&fakeAPI{}, Config{Timeout: d}); err != ErrTimeout {
+			t.Errorf("got %v, expected %v", err, ErrTimeout)
+		}
+		cfg := Config{Timeout: d, HeartbeatFile: "heartbeat"}
+		if _, err := NewSidecar(&fakeAPI{}, nil, "main", nil, cfg); err != ErrTimeout {
+			t.Errorf("got %v, expected %v", err, ErrTimeout)
+		}
+	}
+}
@@ -0,0 +1,383 @@
+// Package export streams the orders, fills and withdrawals of an
+// account as CSV or JSON lines with stable column schemas.
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	tapi "github.com/rschio/mb-tapi"
+)
+
+// Format is the output format of a Writer.
+type Format int
+
+const (
+	// CSV writes comma separated values.
+	CSV Format = iota
+
+	// JSONLines writes one JSON object per line.
+	JSONLines
+)
+
+// ParseFormat parses "csv" or "jsonl".
+func ParseFormat(s string) (Format, error) {
+	switch s {
+	case "csv":
+		return CSV, nil
+	case "jsonl":
+		return JSONLines, nil
+	}
+	return 0, fmt.Errorf("invalid format %q", s)
+}
+
+// Column schemas of the records. New columns are only appended.
+var (
+	OrderColumns = []string{
+		"order_id", "coin_pair", "order_type", "status", "has_fills",
+		"quantity", "limit_price", "executed_quantity",
+		"executed_price_avg", "fee", "created_timestamp",
+		"updated_timestamp",
+	}
+	OperationColumns = []string{
+		"order_id", "coin_pair", "operation_id", "quantity", "price",
+		"fee_rate", "executed_timestamp",
+	}
+	WithdrawalColumns = []string{
+		"id", "coin", "quantity", "net_quantity", "fee", "account",
+		"address", "status", "tx", "destination_tag",
+		"created_timestamp", "updated_timestamp",
+	}
+)
+
+// Writer writes records with a fixed list of columns.
+type Writer struct {
+	f       Format
+	cols    []string
+	header  bool
+	w       io.Writer
+	csv     *csv.Writer
+	started bool
+}
+
+// NewWriter creates a Writer of records with columns cols. If header
+// is true the CSV header is written before the first record.
+func NewWriter(w io.Writer, f Format, cols []string, header bool) *Writer {
+	wr := &Writer{f: f, cols: cols, header: header, w: w}
+	if f == CSV {
+		wr.csv = csv.NewWriter(w)
+	}
+	return wr
+}
+
+// Write writes a record, vals must follow the columns order.
+func (w *Writer) Write(vals ...interface{}) error {
+	if len(vals) != len(w.cols) {
+		return fmt.Errorf("export: got %d values, expected %d", len(vals), len(w.cols))
+	}
+	if w.f == JSONLines {
+		return w.writeJSON(vals)
+	}
+	if !w.started && w.header {
+		if err := w.csv.Write(w.cols); err != nil {
+			return err
+		}
+	}
+	w.started = true
+	row := make([]string, len(vals))
+	for i, v := range vals {
+		row[i] = fmt.Sprint(v)
+	}
+	return w.csv.Write(row)
+}
+
+// writeJSON writes the object keys in the columns order.
+func (w *Writer) writeJSON(vals []interface{}) error {
+	line := []byte{'{'}
+	for i, v := range vals {
+		if i > 0 {
+			line = append(line, ',')
+		}
+		k, _ := json.Marshal(w.cols[i])
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		line = append(line, k...)
+		line = append(line, ':')
+		line = append(line, b...)
+	}
+	line = append(line, '}', '\n')
+	_, err := w.w.Write(line)
+	return err
+}
+
+// Flush flushes any buffered data.
+func (w *Writer) Flush() error {
+	if w.csv == nil {
+		return nil
+	}
+	w.csv.Flush()
+	return w.csv.Error()
+}
+
+// Source is the part of the tapi client used to fetch the history.
+type Source interface {
+	ListOrders(c1, c2 tapi.Coin, opts *tapi.ListOrdersOpts) ([]tapi.Order, error)
+	GetOrder(c1, c2 tapi.Coin, id int) (*tapi.Order, error)
+	GetWithdrawal(coin tapi.Coin, id int) (*tapi.Withdrawal, error)
+}
+
+// Position is the export position of a coin pair.
+type Position struct {
+	// Last is the last exported order ID.
+	Last int `json:"last"`
+
+	// Open are the orders exported while open, by ID.
+	Open map[int]OpenOrder `json:"open,omitempty"`
+}
+
+// OpenOrder is the exported state of an open order.
+type OpenOrder struct {
+	Status    int    `json:"status"`
+	Updated   string `json:"updated"`
+	Operation int    `json:"operation"`
+}
+
+// WithdrawalRef identifies a withdrawal. The API has no method to list
+// the withdrawals, so their IDs must be known by the caller.
+type WithdrawalRef struct {
+	Coin tapi.Coin
+	ID   int
+}
+
+func (r WithdrawalRef) String() string {
+	return r.Coin.String() + ":" + strconv.Itoa(r.ID)
+}
+
+// Cursor is the export position of an account.
+type Cursor struct {
+	// Pairs are the positions of each coin pair, such as "BRLBTC".
+	Pairs map[string]Position `json:"pairs"`
+
+	// Withdrawals are the exported status of each withdrawal, by
+	// "COIN:ID", so a withdrawal is only written again when its status
+	// changes.
+	Withdrawals map[string]int `json:"withdrawals,omitempty"`
+}
+
+// NewCursor returns an empty cursor.
+func NewCursor() *Cursor {
+	return &Cursor{Pairs: make(map[string]Position), Withdrawals: make(map[string]int)}
+}
+
+// LoadCursor reads a cursor saved in path. A missing file is read as
+// an empty cursor.
+func LoadCursor(path string) (*Cursor, error) {
+	cur := NewCursor()
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cur, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, cur); err != nil {
+		return nil, fmt.Errorf("export: invalid cursor %s: %v", path, err)
+	}
+	if cur.Pairs == nil {
+		cur.Pairs = make(map[string]Position)
+	}
+	if cur.Withdrawals == nil {
+		cur.Withdrawals = make(map[string]int)
+	}
+	return cur, nil
+}
+
+// Save writes the cursor to path atomically.
+func (c *Cursor) Save(path string) error {
+	b, err := json.MarshalIndent(c, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".cursor")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Exporter writes the history of an account. Any nil Writer skips its
+// records.
+type Exporter struct {
+	Src         Source
+	Orders      *Writer
+	Operations  *Writer
+	Withdrawals *Writer
+}
+
+// ExportOrders writes the orders and fills of the pairs BRL/coin of
+// every coin in coins, after the positions in cur, and updates cur.
+//
+// The orders created since the last export are listed page by page, and
+// the orders exported while open are fetched again by ID. An order that
+// changed is written again, so a later row of an order_id supersedes
+// the earlier ones, while each operation is only written once.
+func (e *Exporter) ExportOrders(coins []tapi.Coin, cur *Cursor) error {
+	for _, coin := range coins {
+		if coin == tapi.BRL {
+			continue
+		}
+		pair := tapi.BRL.String() + coin.String()
+		pos := cur.Pairs[pair]
+		next := Position{Last: pos.Last, Open: make(map[int]OpenOrder)}
+
+		ids := make([]int, 0, len(pos.Open))
+		for id := range pos.Open {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		for _, id := range ids {
+			o, err := e.Src.GetOrder(tapi.BRL, coin, id)
+			if err != nil {
+				return fmt.Errorf("export: get order %d of %s: %v", id, pair, err)
+			}
+			old := pos.Open[id]
+			if o.Status != old.Status || o.UpdatedTimestamp != old.Updated || lastOperation(o) > old.Operation {
+				if err := e.writeOrder(*o, old.Operation); err != nil {
+					return err
+				}
+			}
+			if o.Status == tapi.OrderStatusOpen {
+				next.Open[id] = openOrder(o)
+			}
+		}
+
+		opts := &tapi.ListOrdersOpts{FromID: pos.Last + 1}
+		err := tapi.WalkOrders(e.Src, tapi.BRL, coin, opts, func(orders []tapi.Order) error {
+			for i := range orders {
+				o := &orders[i]
+				if err := e.writeOrder(*o, 0); err != nil {
+					return err
+				}
+				if o.ID > next.Last {
+					next.Last = o.ID
+				}
+				if o.Status == tapi.OrderStatusOpen {
+					next.Open[o.ID] = openOrder(o)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// Only move the cursor after the records are written.
+		if err := e.flush(); err != nil {
+			return err
+		}
+		cur.Pairs[pair] = next
+	}
+	return nil
+}
+
+func openOrder(o *tapi.Order) OpenOrder {
+	return OpenOrder{Status: o.Status, Updated: o.UpdatedTimestamp, Operation: lastOperation(o)}
+}
+
+// lastOperation returns the greatest operation ID of o.
+func lastOperation(o *tapi.Order) int {
+	last := 0
+	for _, op := range o.Operations {
+		if op.ID > last {
+			last = op.ID
+		}
+	}
+	return last
+}
+
+// writeOrder writes o and its operations after the operation ID after.
+func (e *Exporter) writeOrder(o tapi.Order, after int) error {
+	if e.Orders != nil {
+		err := e.Orders.Write(o.ID, o.CoinPair, o.Type, o.Status,
+			o.HasFills, o.Quantity, o.LimitPrice, o.ExecutedQuantity,
+			o.ExecutedPriceAvg, o.Fee, o.CreatedTimestamp,
+			o.UpdatedTimestamp)
+		if err != nil {
+			return err
+		}
+	}
+	if e.Operations == nil {
+		return nil
+	}
+	for _, op := range o.Operations {
+		if op.ID <= after {
+			continue
+		}
+		err := e.Operations.Write(o.ID, o.CoinPair, op.ID, op.Quantity,
+			op.Price, op.FeeRate, op.ExecutedTimestamp)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportWithdrawals fetches the withdrawals in refs and writes the ones
+// not in cur or whose status changed since, and updates cur.
+func (e *Exporter) ExportWithdrawals(refs []WithdrawalRef, cur *Cursor) error {
+	if e.Withdrawals == nil {
+		return nil
+	}
+	next := make(map[string]int)
+	for _, ref := range refs {
+		w, err := e.Src.GetWithdrawal(ref.Coin, ref.ID)
+		if err != nil {
+			return err
+		}
+		next[ref.String()] = w.Status
+		if status, ok := cur.Withdrawals[ref.String()]; ok && status == w.Status {
+			continue
+		}
+		err = e.Withdrawals.Write(w.ID, w.Coin, w.Quantity, w.NetQuantity,
+			w.Fee, w.Account, w.Address, w.Status, w.Tx, w.DestinationTag,
+			w.CreatedTimestamp, w.UpdatedTimestamp)
+		if err != nil {
+			return err
+		}
+	}
+	if err := e.flush(); err != nil {
+		return err
+	}
+	for ref, status := range next {
+		cur.Withdrawals[ref] = status
+	}
+	return nil
+}
+
+func (e *Exporter) flush() error {
+	for _, w := range []*Writer{e.Orders, e.Operations, e.Withdrawals} {
+		if w == nil {
+			continue
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
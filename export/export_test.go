@@ -0,0 +1,194 @@
+package export
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	tapi "github.com/rschio/mb-tapi"
+)
+
+var testOrders = []tapi.Order{
+	{ID: 1, CoinPair: "BRLBTC", Type: 1, Status: 2, Quantity: "1.00000000",
+		LimitPrice: "1000.00000", ExecutedQuantity: "0.00000000",
+		ExecutedPriceAvg: "0.00000", Fee: "0.00000000",
+		CreatedTimestamp: "1453838494", UpdatedTimestamp: "1453838494",
+		Operations: []tapi.Operation{}},
+	{ID: 3, CoinPair: "BRLBTC", Type: 2, Status: 4, HasFills: true,
+		Quantity: "1.00000000", LimitPrice: "900.00000",
+		ExecutedQuantity: "1.00000000", ExecutedPriceAvg: "900.00000",
+		Fee: "6.30000000", CreatedTimestamp: "1453838494",
+		UpdatedTimestamp: "1453838494",
+		Operations: []tapi.Operation{{ID: 1, Quantity: "1.00000000",
+			Price: "900.00000", FeeRate: "0.70",
+			ExecutedTimestamp: "1453838494"}}},
+}
+
+type fakeSource struct {
+	orders []tapi.Order
+	status int
+}
+
+func (f *fakeSource) ListOrders(c1, c2 tapi.Coin, opts *tapi.ListOrdersOpts) ([]tapi.Order, error) {
+	var orders []tapi.Order
+	if c2 != tapi.BTC {
+		return nil, nil
+	}
+	for _, o := range f.orders {
+		if o.ID >= opts.FromID && (opts.ToID == 0 || o.ID <= opts.ToID) {
+			orders = append(orders, o)
+		}
+	}
+	return orders, nil
+}
+
+func (f *fakeSource) GetOrder(c1, c2 tapi.Coin, id int) (*tapi.Order, error) {
+	for _, o := range f.orders {
+		if o.ID == id {
+			return &o, nil
+		}
+	}
+	return nil, &tapi.Error{Code: 215}
+}
+
+func (f *fakeSource) GetWithdrawal(coin tapi.Coin, id int) (*tapi.Withdrawal, error) {
+	return &tapi.Withdrawal{ID: id, Coin: coin.String(), Quantity: "1.5",
+		Fee: "0.0005", Address: "1G38ybvfUyn96aJbKnzkifX2eEMH9N87ww",
+		Status: f.status, CreatedTimestamp: "1453912131",
+		UpdatedTimestamp: "1453912131"}, nil
+}
+
+func TestExportOrdersCSV(t *testing.T) {
+	orders, ops := new(bytes.Buffer), new(bytes.Buffer)
+	src := &fakeSource{orders: append([]tapi.Order(nil), testOrders...)}
+	e := &Exporter{
+		Src:        src,
+		Orders:     NewWriter(orders, CSV, OrderColumns, true),
+		Operations: NewWriter(ops, CSV, OperationColumns, false),
+	}
+	cur := NewCursor()
+	if err := e.ExportOrders(tapi.Coins, cur); err != nil {
+		t.Fatal(err)
+	}
+	expected := "order_id,coin_pair,order_type,status,has_fills,quantity," +
+		"limit_price,executed_quantity,executed_price_avg,fee," +
+		"created_timestamp,updated_timestamp\n" +
+		"1,BRLBTC,1,2,false,1.00000000,1000.00000,0.00000000,0.00000," +
+		"0.00000000,1453838494,1453838494\n" +
+		"3,BRLBTC,2,4,true,1.00000000,900.00000,1.00000000,900.00000," +
+		"6.30000000,1453838494,1453838494\n"
+	if orders.String() != expected {
+		t.Errorf("got orders:\n%s\nexpected:\n%s", orders, expected)
+	}
+	expected = "3,BRLBTC,1,1.00000000,900.00000,0.70,1453838494\n"
+	if ops.String() != expected {
+		t.Errorf("got operations:\n%s\nexpected:\n%s", ops, expected)
+	}
+	if pos := cur.Pairs["BRLBTC"]; pos.Last != 3 || len(pos.Open) != 1 {
+		t.Errorf("got cursor %+v, expected last 3 and order 1 open", pos)
+	}
+
+	// Resuming from the cursor exports nothing new.
+	orders.Reset()
+	ops.Reset()
+	if err := e.ExportOrders(tapi.Coins, cur); err != nil {
+		t.Fatal(err)
+	}
+	if orders.Len() != 0 {
+		t.Errorf("got orders after cursor:\n%s", orders)
+	}
+
+	// The open order is filled, so it is written again with the new
+	// operation only.
+	o := &src.orders[0]
+	o.Status, o.HasFills, o.ExecutedQuantity = 4, true, "1.00000000"
+	o.UpdatedTimestamp = "1453838600"
+	o.Operations = []tapi.Operation{{ID: 2, Quantity: "1.00000000",
+		Price: "1000.00000", FeeRate: "0.30", ExecutedTimestamp: "1453838600"}}
+	if err := e.ExportOrders(tapi.Coins, cur); err != nil {
+		t.Fatal(err)
+	}
+	expected = "1,BRLBTC,1,4,true,1.00000000,1000.00000,1.00000000,0.00000," +
+		"0.00000000,1453838494,1453838600\n"
+	if orders.String() != expected {
+		t.Errorf("got orders:\n%s\nexpected:\n%s", orders, expected)
+	}
+	expected = "1,BRLBTC,2,1.00000000,1000.00000,0.30,1453838600\n"
+	if ops.String() != expected {
+		t.Errorf("got operations:\n%s\nexpected:\n%s", ops, expected)
+	}
+	if pos := cur.Pairs["BRLBTC"]; pos.Last != 3 || len(pos.Open) != 0 {
+		t.Errorf("got cursor %+v, expected last 3 and no order open", pos)
+	}
+}
+
+func TestExportWithdrawalsJSONLines(t *testing.T) {
+	ws := new(bytes.Buffer)
+	src := &fakeSource{status: tapi.WithdrawalStatusDone}
+	e := &Exporter{
+		Src:         src,
+		Withdrawals: NewWriter(ws, JSONLines, WithdrawalColumns, true),
+	}
+	refs := []WithdrawalRef{{Coin: tapi.BTC, ID: 7}}
+	cur := NewCursor()
+	if err := e.ExportWithdrawals(refs, cur); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"id":7,"coin":"BTC","quantity":"1.5","net_quantity":"",` +
+		`"fee":"0.0005","account":"",` +
+		`"address":"1G38ybvfUyn96aJbKnzkifX2eEMH9N87ww","status":2,` +
+		`"tx":"","destination_tag":0,"created_timestamp":"1453912131",` +
+		`"updated_timestamp":"1453912131"}` + "\n"
+	if ws.String() != expected {
+		t.Errorf("got withdrawals:\n%s\nexpected:\n%s", ws, expected)
+	}
+
+	// A withdrawal is only written again when its status changes.
+	ws.Reset()
+	if err := e.ExportWithdrawals(refs, cur); err != nil {
+		t.Fatal(err)
+	}
+	if ws.Len() != 0 {
+		t.Errorf("got withdrawals after cursor:\n%s", ws)
+	}
+	src.status = tapi.WithdrawalStatusCancelled
+	if err := e.ExportWithdrawals(refs, cur); err != nil {
+		t.Fatal(err)
+	}
+	if cur.Withdrawals["BTC:7"] != tapi.WithdrawalStatusCancelled || ws.Len() == 0 {
+		t.Errorf("got cursor %v and withdrawals:\n%s\nexpected the cancelled one", cur.Withdrawals, ws)
+	}
+}
+
+func TestCursor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cursor.json")
+	cur, err := LoadCursor(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cur.Pairs) != 0 {
+		t.Errorf("got %d pairs in missing cursor", len(cur.Pairs))
+	}
+	cur.Pairs["BRLBTC"] = Position{Last: 42, Open: map[int]OpenOrder{40: {Status: 2, Updated: "1453838494"}}}
+	cur.Withdrawals["BTC:7"] = tapi.WithdrawalStatusOpen
+	if err := cur.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	cur, err = LoadCursor(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos := cur.Pairs["BRLBTC"]; pos.Last != 42 || pos.Open[40].Updated != "1453838494" {
+		t.Errorf("got cursor %+v, expected last 42 and order 40 open", pos)
+	}
+	if s := cur.Withdrawals["BTC:7"]; s != tapi.WithdrawalStatusOpen {
+		t.Errorf("got withdrawal status %d, expected open", s)
+	}
+}
@@ -0,0 +1,304 @@
+// Package fee estimates the cost of orders and withdrawals before they
+// are sent.
+//
+// The exchange charges the buy fee in the digital coin and the sell fee
+// in BRL, with a lower rate for orders that add liquidity (maker) than
+// for orders that take it (taker).
+package fee
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	tapi "github.com/rschio/mb-tapi"
+	"github.com/rschio/mb-tapi/internal/dec"
+)
+
+// Schedule contains the fee rates in percent, in the format of
+// Operation.FeeRate.
+type Schedule struct {
+	Maker string
+	Taker string
+
+	// Withdrawal is the default network fee of each digital coin,
+	// used when WithdrawInfo.TxFee is empty.
+	Withdrawal map[tapi.Coin]string
+}
+
+// DefaultSchedule is the base tier of the exchange.
+var DefaultSchedule = Schedule{Maker: tapi.DefaultMakerFeeRate, Taker: tapi.DefaultTakerFeeRate}
+
+// Side is the side of an order.
+type Side int
+
+const (
+	Buy Side = iota
+	Sell
+)
+
+// ErrNoLiquidity is returned when no part of a market order would be
+// filled.
+var ErrNoLiquidity = errors.New("fee: no liquidity for the market order")
+
+// Model estimates fees with a schedule that can be learned from the
+// recent fills of the account. It is safe for concurrent use.
+type Model struct {
+	api tapi.API
+
+	mu    sync.Mutex
+	maker *big.Rat
+	taker *big.Rat
+	wfees map[tapi.Coin]*big.Rat
+}
+
+// New creates a Model that uses api to read the order book and the
+// recent fills, starting with the rates of s.
+func New(api tapi.API, s Schedule) (*Model, error) {
+	m := &Model{api: api, wfees: make(map[tapi.Coin]*big.Rat)}
+	var err error
+	if m.maker, err = dec.Parse(s.Maker); err != nil {
+		return nil, fmt.Errorf("fee: maker: %v", err)
+	}
+	if m.taker, err = dec.Parse(s.Taker); err != nil {
+		return nil, fmt.Errorf("fee: taker: %v", err)
+	}
+	for c, f := range s.Withdrawal {
+		v, err := dec.Parse(f)
+		if err != nil {
+			return nil, fmt.Errorf("fee: withdrawal fee of %v: %v", c, err)
+		}
+		m.wfees[c] = v
+	}
+	return m, nil
+}
+
+// Rates returns the current maker and taker rates in percent.
+func (m *Model) Rates() (maker, taker *big.Rat) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return new(big.Rat).Set(m.maker), new(big.Rat).Set(m.taker)
+}
+
+// Learn updates the rates with the fee rates of the most recent fills
+// of the pair c1 and c2. The exchange does not tell whether a fill was
+// maker or taker, so when two rates are seen the lower is taken as the
+// maker rate and the higher as the taker rate. A single rate updates the
+// current rate closest to it.
+func (m *Model) Learn(c1, c2 tapi.Coin) error {
+	orders, err := m.api.ListOrders(c1, c2, &tapi.ListOrdersOpts{HasFills: 1})
+	if err != nil {
+		return err
+	}
+	var lo, hi *big.Rat
+	for _, o := range orders {
+		for _, op := range o.Operations {
+			r, err := dec.Parse(op.FeeRate)
+			if err != nil {
+				return fmt.Errorf("fee: order %d: %v", o.ID, err)
+			}
+			if lo == nil || r.Cmp(lo) < 0 {
+				lo = r
+			}
+			if hi == nil || r.Cmp(hi) > 0 {
+				hi = r
+			}
+		}
+	}
+	if lo == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if lo.Cmp(hi) != 0 {
+		m.maker, m.taker = lo, hi
+		return nil
+	}
+	dm := new(big.Rat).Abs(dec.Sub(lo, m.maker))
+	dt := new(big.Rat).Abs(dec.Sub(lo, m.taker))
+	if dm.Cmp(dt) <= 0 {
+		m.maker = lo
+	} else {
+		m.taker = lo
+	}
+	return nil
+}
+
+// Estimate is the expected result of an order if it was placed on the
+// current order book. The part of a limit order that does not cross the
+// book is assumed to rest and be filled later at the limit price, as
+// maker.
+type Estimate struct {
+	// Filled is the quantity of digital coin filled at once as taker.
+	Filled *big.Rat
+
+	// Resting is the quantity that would rest on the book because
+	// the limit price does not cross it.
+	Resting *big.Rat
+
+	// Price is the average price of Filled at the book prices and
+	// Resting at the limit price. BestPrice is the best price of the
+	// opposite side of the book, nil if it is empty.
+	Price     *big.Rat
+	BestPrice *big.Rat
+
+	// Slippage is the fraction that Price is worse than BestPrice,
+	// negative if better and zero without BestPrice.
+	Slippage *big.Rat
+
+	// Value is (Filled + Resting) * Price in BRL.
+	Value *big.Rat
+
+	// TakerRate and MakerRate are the rates in percent of Filled and
+	// Resting. Fee is charged in the received coin: the digital coin on
+	// buys and BRL on sells.
+	TakerRate *big.Rat
+	MakerRate *big.Rat
+	Fee       *big.Rat
+
+	// Net is the received quantity after the fee: digital coin on
+	// buys and BRL on sells.
+	Net *big.Rat
+}
+
+// EstimateOrder walks the current order book of c1 and c2 and estimates
+// an order of side with quantity qt. An empty limit estimates a market
+// order, which fails with ErrNoLiquidity if the book side is empty.
+func (m *Model) EstimateOrder(c1, c2 tapi.Coin, side Side, qt, limit string) (*Estimate, error) {
+	q, err := dec.Parse(qt)
+	if err != nil {
+		return nil, err
+	}
+	if q.Sign() <= 0 {
+		return nil, fmt.Errorf("fee: quantity %q must be positive", qt)
+	}
+	var lim *big.Rat
+	if limit != "" {
+		if lim, err = dec.Parse(limit); err != nil {
+			return nil, err
+		}
+		if lim.Sign() <= 0 {
+			return nil, fmt.Errorf("fee: limit %q must be positive", limit)
+		}
+	}
+	book, err := m.api.ListOrderbook(c1, c2, true)
+	if err != nil {
+		return nil, err
+	}
+	levels := book.Asks
+	if side == Sell {
+		levels = book.Bids
+	}
+	e, err := walk(levels, side, q, lim)
+	if err != nil {
+		return nil, err
+	}
+	e.MakerRate, e.TakerRate = m.Rates()
+	restingValue := dec.Zero()
+	if e.Resting.Sign() > 0 {
+		restingValue = dec.Mul(e.Resting, lim)
+	}
+	if side == Buy {
+		e.Fee = dec.Add(dec.Percent(e.Filled, e.TakerRate), dec.Percent(e.Resting, e.MakerRate))
+		e.Net = dec.Sub(dec.Add(e.Filled, e.Resting), e.Fee)
+	} else {
+		e.Fee = dec.Add(dec.Percent(e.Value, e.TakerRate), dec.Percent(restingValue, e.MakerRate))
+		e.Net = dec.Sub(dec.Add(e.Value, restingValue), e.Fee)
+	}
+	e.Value.Add(e.Value, restingValue)
+	e.Price = dec.Quo(e.Value, dec.Add(e.Filled, e.Resting))
+	e.Slippage = dec.Zero()
+	if e.BestPrice != nil && e.BestPrice.Sign() > 0 {
+		diff := dec.Sub(e.Price, e.BestPrice)
+		if side == Sell {
+			diff.Neg(diff)
+		}
+		e.Slippage = dec.Quo(diff, e.BestPrice)
+	}
+	return e, nil
+}
+
+// walk fills qt with the levels up to limit. Value is the value of the
+// filled part only.
+func walk(levels []tapi.OrderInfo, side Side, qt, limit *big.Rat) (*Estimate, error) {
+	e := &Estimate{Filled: dec.Zero(), Value: dec.Zero()}
+	for _, l := range levels {
+		left := dec.Sub(qt, e.Filled)
+		if left.Sign() <= 0 {
+			break
+		}
+		price, err := dec.Parse(l.LimitPrice)
+		if err != nil {
+			return nil, err
+		}
+		if e.BestPrice == nil {
+			e.BestPrice = price
+		}
+		if limit != nil && (side == Buy && price.Cmp(limit) > 0 || side == Sell && price.Cmp(limit) < 0) {
+			break
+		}
+		lq, err := dec.Parse(l.Quantity)
+		if err != nil {
+			return nil, err
+		}
+		if lq.Cmp(left) > 0 {
+			lq = left
+		}
+		e.Filled.Add(e.Filled, lq)
+		e.Value.Add(e.Value, dec.Mul(lq, price))
+	}
+	if limit == nil {
+		// Market orders do not rest.
+		if e.Filled.Sign() == 0 {
+			return nil, ErrNoLiquidity
+		}
+		e.Resting = dec.Zero()
+		return e, nil
+	}
+	e.Resting = dec.Sub(qt, e.Filled)
+	return e, nil
+}
+
+// WithdrawalEstimate is the expected cost of a digital coin transfer.
+type WithdrawalEstimate struct {
+	// NetworkFee is the fee paid to the network.
+	NetworkFee *big.Rat
+
+	// Net is the quantity received by the destination.
+	Net *big.Rat
+
+	// Total is the quantity debited from the account.
+	Total *big.Rat
+}
+
+// EstimateWithdrawal estimates a WithdrawCrypto request. The network
+// fee is i.TxFee or, when it is empty, the schedule fee of coin.
+func (m *Model) EstimateWithdrawal(coin tapi.Coin, i *tapi.WithdrawInfo) (*WithdrawalEstimate, error) {
+	if i == nil {
+		return nil, errors.New("nil WithdrawInfo")
+	}
+	qt, err := dec.Parse(i.Quantity)
+	if err != nil {
+		return nil, err
+	}
+	var nf *big.Rat
+	if i.TxFee != "" {
+		if nf, err = dec.Parse(i.TxFee); err != nil {
+			return nil, err
+		}
+	} else {
+		m.mu.Lock()
+		f, ok := m.wfees[coin]
+		m.mu.Unlock()
+		if !ok {
+			return nil, fmt.Errorf("fee: no network fee of %v", coin)
+		}
+		nf = new(big.Rat).Set(f)
+	}
+	return &WithdrawalEstimate{
+		NetworkFee: nf,
+		Net:        qt,
+		Total:      dec.Add(qt, nf),
+	}, nil
+}
@@ -0,0 +1,151 @@
+package fee
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	tapi "github.com/rschio/mb-tapi"
+	"github.com/rschio/mb-tapi/internal/dec"
+)
+
+type fakeAPI struct {
+	tapi.API
+	orders []tapi.Order
+	book   *tapi.Orderbook
+}
+
+func (f *fakeAPI) ListOrders(c1, c2 tapi.Coin, opts *tapi.ListOrdersOpts) ([]tapi.Order, error) {
+	return f.orders, nil
+}
+
+func (f *fakeAPI) ListOrderbook(c1, c2 tapi.Coin, full bool) (*tapi.Orderbook, error) {
+	return f.book, nil
+}
+
+var testBook = &tapi.Orderbook{
+	Bids: []tapi.OrderInfo{
+		{OrderID: 1, Quantity: "1.00000000", LimitPrice: "1000.00000"},
+		{OrderID: 2, Quantity: "1.00000000", LimitPrice: "990.00000"},
+	},
+	Asks: []tapi.OrderInfo{
+		{OrderID: 3, Quantity: "1.00000000", LimitPrice: "1100.00000"},
+		{OrderID: 4, Quantity: "1.00000000", LimitPrice: "1200.00000"},
+	},
+}
+
+func rat(t *testing.T, s string) string {
+	t.Helper()
+	r, err := dec.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r.RatString()
+}
+
+func TestEstimateOrder(t *testing.T) {
+	m, err := New(&fakeAPI{book: testBook}, DefaultSchedule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		side                                  Side
+		qt, limit                             string
+		filled, resting, price, slippage, fee string
+		net                                   string
+	}{
+		{Buy, "0.5", "", "0.5", "0", "1100.00000000", "0", "0.0035", "0.4965"},
+		{Buy, "1.5", "", "1.5", "0", "1133.33333333", "1/33", "0.0105", "1.4895"},
+		{Buy, "1.5", "1150", "1", "0.5", "1116.66666667", "1/66", "0.0085", "1.4915"},
+		{Sell, "2", "", "2", "0", "995.00000000", "0.005", "13.93", "1976.07"},
+		// Not crossing the book, so it all rests as maker.
+		{Buy, "1", "1000", "0", "1", "1000.00000000", "-1/11", "0.003", "0.997"},
+		{Sell, "1", "1050", "0", "1", "1050.00000000", "-1/20", "3.15", "1046.85"},
+	}
+	for _, tt := range tests {
+		e, err := m.EstimateOrder(tapi.BRL, tapi.BTC, tt.side, tt.qt, tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check := func(name string, got *big.Rat, expected string) {
+			if got.RatString() != rat(t, expected) {
+				t.Errorf("%v %s: got %s %s, expected %s", tt.side, tt.qt, name, got.RatString(), expected)
+			}
+		}
+		check("filled", e.Filled, tt.filled)
+		check("resting", e.Resting, tt.resting)
+		if got := dec.Format(e.Price, 8); got != tt.price {
+			t.Errorf("%v %s: got price %s, expected %s", tt.side, tt.qt, got, tt.price)
+		}
+		check("slippage", e.Slippage, tt.slippage)
+		check("fee", e.Fee, tt.fee)
+		check("net", e.Net, tt.net)
+	}
+	m.api = &fakeAPI{book: &tapi.Orderbook{Bids: testBook.Bids}}
+	if _, err := m.EstimateOrder(tapi.BRL, tapi.BTC, Buy, "1", ""); !errors.Is(err, ErrNoLiquidity) {
+		t.Errorf("got error %v, expected %v", err, ErrNoLiquidity)
+	}
+	for _, p := range [][2]string{{"0", "1000"}, {"", "1000"}, {"-1", ""}, {"1", "0"}} {
+		if _, err := m.EstimateOrder(tapi.BRL, tapi.BTC, Sell, p[0], p[1]); err == nil {
+			t.Errorf("got no error for quantity %q and limit %q", p[0], p[1])
+		}
+	}
+	m.api = &fakeAPI{book: &tapi.Orderbook{Asks: []tapi.OrderInfo{{Quantity: "1", LimitPrice: "0"}}}}
+	e, err := m.EstimateOrder(tapi.BRL, tapi.BTC, Buy, "1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Slippage.Sign() != 0 {
+		t.Errorf("got slippage %s on a zero price book, expected 0", e.Slippage.RatString())
+	}
+}
+
+func TestLearn(t *testing.T) {
+	api := &fakeAPI{}
+	m, err := New(api, DefaultSchedule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api.orders = []tapi.Order{{ID: 1, Operations: []tapi.Operation{{FeeRate: "0.25"}}}}
+	if err := m.Learn(tapi.BRL, tapi.BTC); err != nil {
+		t.Fatal(err)
+	}
+	maker, taker := m.Rates()
+	if maker.RatString() != rat(t, "0.25") || taker.RatString() != rat(t, "0.70") {
+		t.Errorf("got rates %s %s, expected 0.25 0.70", maker.RatString(), taker.RatString())
+	}
+	api.orders = append(api.orders, tapi.Order{ID: 2, Operations: []tapi.Operation{{FeeRate: "0.50"}}})
+	if err := m.Learn(tapi.BRL, tapi.BTC); err != nil {
+		t.Fatal(err)
+	}
+	maker, taker = m.Rates()
+	if maker.RatString() != rat(t, "0.25") || taker.RatString() != rat(t, "0.50") {
+		t.Errorf("got rates %s %s, expected 0.25 0.50", maker.RatString(), taker.RatString())
+	}
+}
+
+func TestEstimateWithdrawal(t *testing.T) {
+	s := DefaultSchedule
+	s.Withdrawal = map[tapi.Coin]string{tapi.BTC: "0.0004"}
+	m, err := New(&fakeAPI{}, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := m.EstimateWithdrawal(tapi.BTC, &tapi.WithdrawInfo{Quantity: "0.5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Total.RatString() != rat(t, "0.5004") || e.Net.RatString() != rat(t, "0.5") {
+		t.Errorf("got total %s net %s, expected 0.5004 0.5", e.Total.RatString(), e.Net.RatString())
+	}
+	e, err = m.EstimateWithdrawal(tapi.BTC, &tapi.WithdrawInfo{Quantity: "0.5", TxFee: "0.001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.NetworkFee.RatString() != rat(t, "0.001") {
+		t.Errorf("got fee %s, expected 0.001", e.NetworkFee.RatString())
+	}
+	if _, err := m.EstimateWithdrawal(tapi.ETH, &tapi.WithdrawInfo{Quantity: "1"}); err == nil {
+		t.Error("expected error without network fee")
+	}
+}
@@ -0,0 +1,95 @@
+package guard
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	tapi "github.com/rschio/mb-tapi"
+)
+
+// Entry is an allowed withdrawal destination.
+type Entry struct {
+	// Coin is the coin name, such as "BTC".
+	Coin string `json:"coin"`
+
+	// Address is the destination address. For BRL it is the
+	// account_ref of a registered bank account.
+	Address string `json:"address"`
+
+	// DestinationTag is the required destination tag of XRP
+	// addresses.
+	DestinationTag int `json:"destination_tag,omitempty"`
+
+	// Label describes the destination.
+	Label string `json:"label,omitempty"`
+}
+
+// Allowlist is a list of destinations signed by the treasury key.
+type Allowlist struct {
+	Entries   []Entry `json:"entries"`
+	Signature []byte  `json:"signature"`
+}
+
+// ErrBadSignature is returned when the allowlist signature does not
+// match its entries.
+var ErrBadSignature = errors.New("guard: invalid allowlist signature")
+
+func (a *Allowlist) message() ([]byte, error) {
+	return json.Marshal(a.Entries)
+}
+
+// Sign signs the entries with priv.
+func (a *Allowlist) Sign(priv ed25519.PrivateKey) error {
+	msg, err := a.message()
+	if err != nil {
+		return err
+	}
+	a.Signature = ed25519.Sign(priv, msg)
+	return nil
+}
+
+// Verify checks the signature of the entries with pub.
+func (a *Allowlist) Verify(pub ed25519.PublicKey) error {
+	msg, err := a.message()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pub, msg, a.Signature) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// LoadAllowlist reads the allowlist in path and verifies it with pub.
+func LoadAllowlist(path string, pub ed25519.PublicKey) (*Allowlist, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	a := &Allowlist{}
+	if err := json.Unmarshal(b, a); err != nil {
+		return nil, fmt.Errorf("guard: invalid allowlist %s: %v", path, err)
+	}
+	if err := a.Verify(pub); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// allowed reports whether the destination is in the list. XRP
+// destinations must also match the destination tag.
+func (a *Allowlist) allowed(coin tapi.Coin, address string, tag int) bool {
+	for _, e := range a.Entries {
+		if e.Coin != coin.String() || e.Address != address {
+			continue
+		}
+		if coin == tapi.XRP && e.DestinationTag != tag {
+			continue
+		}
+		return true
+	}
+	return false
+}
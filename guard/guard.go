@@ -0,0 +1,357 @@
+// Package guard protects the withdrawals of a tapi.API.
+//
+// A Guard only lets withdrawals go to the destinations of a signed
+// allowlist, enforces daily caps per coin tracked locally and the
+// exchange withdrawal limits, and requires an approval token of a
+// second operator above a threshold.
+package guard
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tapi "github.com/rschio/mb-tapi"
+	"github.com/rschio/mb-tapi/internal/dec"
+)
+
+var (
+	// ErrNotAllowlisted is returned when the destination is not in
+	// the allowlist.
+	ErrNotAllowlisted = errors.New("guard: destination not in allowlist")
+
+	// ErrDailyCap is returned when the withdrawal exceeds the daily
+	// cap of the coin.
+	ErrDailyCap = errors.New("guard: daily cap exceeded")
+
+	// ErrExchangeLimit is returned when the withdrawal exceeds the
+	// available withdrawal limit of the account.
+	ErrExchangeLimit = errors.New("guard: exchange withdrawal limit exceeded")
+
+	// ErrApprovalRequired is returned when the withdrawal is above the
+	// approval threshold and has no approval token.
+	ErrApprovalRequired = errors.New("guard: approval required")
+
+	// ErrInvalidApproval is returned when the approval token is
+	// invalid, expired or already used.
+	ErrInvalidApproval = errors.New("guard: invalid approval")
+)
+
+// Config configures a Guard. Any coin without a cap or threshold is not
+// limited by them.
+type Config struct {
+	// Allowlist is the list of destinations. It is required.
+	Allowlist *Allowlist
+
+	// AllowlistKey is the treasury key that signed Allowlist. It is
+	// required.
+	AllowlistKey ed25519.PublicKey
+
+	// DailyCaps is the max quantity withdrawn per UTC day of each
+	// coin.
+	DailyCaps map[tapi.Coin]string
+
+	// ApprovalThresholds is the quantity of each coin above which a
+	// withdrawal needs an approval token.
+	ApprovalThresholds map[tapi.Coin]string
+
+	// Approvers are the keys of the operators that can approve.
+	Approvers []ed25519.PublicKey
+
+	// StatePath is the file where the daily usage and the used
+	// tokens are saved. Empty means keep them only in memory.
+	StatePath string
+}
+
+// Guard is a tapi.API that checks the withdrawals before sending them.
+type Guard struct {
+	tapi.API
+
+	allow      *Allowlist
+	caps       map[tapi.Coin]*big.Rat
+	thresholds map[tapi.Coin]*big.Rat
+	approvers  []ed25519.PublicKey
+	path       string
+	now        func() time.Time
+
+	mu    sync.Mutex
+	state state
+}
+
+type state struct {
+	Day    string            `json:"day"`
+	Used   map[string]string `json:"used"`
+	Tokens map[string]int64  `json:"tokens"`
+}
+
+// New creates a Guard of the withdrawals of api. It fails with
+// ErrBadSignature if the allowlist is not signed by its key.
+func New(api tapi.API, cfg Config) (*Guard, error) {
+	if cfg.Allowlist == nil {
+		return nil, errors.New("guard: nil allowlist")
+	}
+	if len(cfg.AllowlistKey) != ed25519.PublicKeySize {
+		return nil, errors.New("guard: invalid allowlist key")
+	}
+	if err := cfg.Allowlist.Verify(cfg.AllowlistKey); err != nil {
+		return nil, err
+	}
+	g := &Guard{
+		API:        api,
+		allow:      cfg.Allowlist,
+		caps:       make(map[tapi.Coin]*big.Rat),
+		thresholds: make(map[tapi.Coin]*big.Rat),
+		approvers:  cfg.Approvers,
+		path:       cfg.StatePath,
+		now:        time.Now,
+	}
+	for c, s := range cfg.DailyCaps {
+		v, err := dec.Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("guard: cap of %v: %v", c, err)
+		}
+		g.caps[c] = v
+	}
+	for c, s := range cfg.ApprovalThresholds {
+		v, err := dec.Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("guard: threshold of %v: %v", c, err)
+		}
+		g.thresholds[c] = v
+	}
+	if err := g.load(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// WithdrawBRL checks and requests a BRL withdrawal to the account
+// accRef. It fails with ErrApprovalRequired above the threshold.
+func (g *Guard) WithdrawBRL(desc, qt, accRef string) (*tapi.Withdrawal, error) {
+	return g.WithdrawBRLApproved(desc, qt, accRef, "")
+}
+
+// WithdrawBRLApproved is like WithdrawBRL with an approval token.
+func (g *Guard) WithdrawBRLApproved(desc, qt, accRef, token string) (*tapi.Withdrawal, error) {
+	req := Request{Coin: tapi.BRL, Address: accRef, Quantity: qt}
+	return g.withdraw(req, token, func() (*tapi.Withdrawal, error) {
+		return g.API.WithdrawBRL(desc, qt, accRef)
+	})
+}
+
+// WithdrawCrypto checks and requests a digital coin transfer. It fails
+// with ErrApprovalRequired above the threshold.
+func (g *Guard) WithdrawCrypto(coin tapi.Coin, desc string, i *tapi.WithdrawInfo) (*tapi.Withdrawal, error) {
+	return g.WithdrawCryptoApproved(coin, desc, i, "")
+}
+
+// WithdrawCryptoApproved is like WithdrawCrypto with an approval token.
+func (g *Guard) WithdrawCryptoApproved(coin tapi.Coin, desc string, i *tapi.WithdrawInfo, token string) (*tapi.Withdrawal, error) {
+	if i == nil {
+		return nil, errors.New("nil WithdrawInfo")
+	}
+	req := Request{
+		Coin:           coin,
+		Address:        i.Address,
+		DestinationTag: i.DestinationTag,
+		Quantity:       i.Quantity,
+	}
+	return g.withdraw(req, token, func() (*tapi.Withdrawal, error) {
+		return g.API.WithdrawCrypto(coin, desc, i)
+	})
+}
+
+func (g *Guard) withdraw(req Request, token string, do func() (*tapi.Withdrawal, error)) (*tapi.Withdrawal, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !g.allow.allowed(req.Coin, req.Address, req.DestinationTag) {
+		return nil, fmt.Errorf("%w: %v %s", ErrNotAllowlisted, req.Coin, req.Address)
+	}
+	qt, err := dec.Parse(req.Quantity)
+	if err != nil {
+		return nil, err
+	}
+	if qt.Sign() <= 0 {
+		return nil, fmt.Errorf("guard: quantity %q must be positive", req.Quantity)
+	}
+	now := g.now()
+	g.rollDay(now)
+	if th, ok := g.thresholds[req.Coin]; ok && qt.Cmp(th) > 0 {
+		if err := g.checkApproval(req, token, now); err != nil {
+			return nil, err
+		}
+	}
+	used, err := dec.Parse(g.state.Used[req.Coin.String()])
+	if err != nil {
+		return nil, err
+	}
+	total := dec.Add(used, qt)
+	if cp, ok := g.caps[req.Coin]; ok && total.Cmp(cp) > 0 {
+		return nil, fmt.Errorf("%w: %v used %s of %s", ErrDailyCap,
+			req.Coin, dec.Format(used, 8), dec.Format(cp, 8))
+	}
+	info, err := g.API.GetAccountInfo()
+	if err != nil {
+		return nil, err
+	}
+	// ClientV4 does not return the withdrawal limits, an unknown limit
+	// is left to the exchange.
+	if avail := info.WithdrawalLimit(req.Coin).Available; avail != "" {
+		limit, err := dec.Parse(avail)
+		if err != nil {
+			return nil, err
+		}
+		if qt.Cmp(limit) > 0 {
+			return nil, fmt.Errorf("%w: %v available %s", ErrExchangeLimit, req.Coin, avail)
+		}
+	}
+
+	w, err := do()
+	if err != nil {
+		// A withdrawal that may have been made counts against the
+		// cap and uses the token, so retries cannot exceed them.
+		if tapi.Ambiguous(err) {
+			g.use(req.Coin, total, token)
+			if serr := g.save(); serr != nil {
+				return nil, fmt.Errorf("%v, and state not saved: %v", err, serr)
+			}
+		}
+		return nil, err
+	}
+	g.use(req.Coin, total, token)
+	if err := g.save(); err != nil {
+		return w, fmt.Errorf("guard: withdrawal %d done but state not saved: %v", w.ID, err)
+	}
+	return w, nil
+}
+
+// use records the daily usage of coin and the token.
+func (g *Guard) use(coin tapi.Coin, total *big.Rat, token string) {
+	if token != "" {
+		g.state.Tokens[token] = tokenExpiry(token)
+	}
+	g.state.Used[coin.String()] = total.RatString()
+}
+
+// Request is the withdrawal approved by a token.
+type Request struct {
+	Coin           tapi.Coin
+	Address        string
+	DestinationTag int
+	Quantity       string
+}
+
+func (r Request) message(expiry int64) []byte {
+	return []byte(fmt.Sprintf("%v|%s|%d|%s|%d", r.Coin, r.Address,
+		r.DestinationTag, r.Quantity, expiry))
+}
+
+// Approve creates a single use approval token of req valid until
+// expiry. It is called by the second operator with its own key.
+func Approve(priv ed25519.PrivateKey, req Request, expiry time.Time) string {
+	exp := expiry.Unix()
+	sig := ed25519.Sign(priv, req.message(exp))
+	return strconv.FormatInt(exp, 10) + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func tokenExpiry(token string) int64 {
+	i := strings.IndexByte(token, '.')
+	if i < 0 {
+		return 0
+	}
+	exp, _ := strconv.ParseInt(token[:i], 10, 64)
+	return exp
+}
+
+func (g *Guard) checkApproval(req Request, token string, now time.Time) error {
+	if token == "" {
+		return fmt.Errorf("%w: %s %v", ErrApprovalRequired, req.Quantity, req.Coin)
+	}
+	if _, used := g.state.Tokens[token]; used {
+		return fmt.Errorf("%w: token already used", ErrInvalidApproval)
+	}
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return ErrInvalidApproval
+	}
+	exp, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return ErrInvalidApproval
+	}
+	if now.Unix() > exp {
+		return fmt.Errorf("%w: token expired", ErrInvalidApproval)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ErrInvalidApproval
+	}
+	for _, pub := range g.approvers {
+		if ed25519.Verify(pub, req.message(exp), sig) {
+			return nil
+		}
+	}
+	return ErrInvalidApproval
+}
+
+// rollDay resets the usage on a new day and drops expired tokens.
+func (g *Guard) rollDay(now time.Time) {
+	day := now.UTC().Format("2006-01-02")
+	if g.state.Day != day {
+		g.state.Day = day
+		g.state.Used = make(map[string]string)
+	}
+	for t, exp := range g.state.Tokens {
+		if now.Unix() > exp {
+			delete(g.state.Tokens, t)
+		}
+	}
+}
+
+func (g *Guard) load() error {
+	g.state = state{Used: make(map[string]string), Tokens: make(map[string]int64)}
+	if g.path == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(g.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, &g.state); err != nil {
+		return fmt.Errorf("guard: invalid state %s: %v", g.path, err)
+	}
+	if g.state.Used == nil {
+		g.state.Used = make(map[string]string)
+	}
+	if g.state.Tokens == nil {
+		g.state.Tokens = make(map[string]int64)
+	}
+	return nil
+}
+
+func (g *Guard) save() error {
+	if g.path == "" {
+		return nil
+	}
+	b, err := json.Marshal(g.state)
+	if err != nil {
+		return err
+	}
+	tmp := g.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, g.path)
+}
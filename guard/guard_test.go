@@ -0,0 +1,223 @@
+package guard
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	tapi "github.com/rschio/mb-tapi"
+)
+
+type fakeAPI struct {
+	tapi.API
+	withdrawals int
+	err         error
+	noLimits    bool
+}
+
+func (f *fakeAPI) GetAccountInfo() (*tapi.AccountInfo, error) {
+	info := &tapi.AccountInfo{}
+	if f.noLimits {
+		return info, nil
+	}
+	info.WithdrawalLimits.BTC.Available = "3.00000000"
+	info.WithdrawalLimits.XRP.Available = "100.00000000"
+	info.WithdrawalLimits.BRL.Available = "988.00"
+	return info, nil
+}
+
+func (f *fakeAPI) WithdrawCrypto(coin tapi.Coin, desc string, i *tapi.WithdrawInfo) (*tapi.Withdrawal, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.withdrawals++
+	return &tapi.Withdrawal{ID: f.withdrawals, Coin: coin.String(), Quantity: i.Quantity}, nil
+}
+
+func (f *fakeAPI) WithdrawBRL(desc, qt, accRef string) (*tapi.Withdrawal, error) {
+	f.withdrawals++
+	return &tapi.Withdrawal{ID: f.withdrawals, Coin: "BRL", Quantity: qt}, nil
+}
+
+const (
+	btcAddr = "1G38ybvfUyn96aJbKnzkifX2eEMH9N87ww"
+	xrpAddr = "rPT1Sjq2YGrBMTttX4GZHjKu9dyfzbpAYe"
+)
+
+func newTestGuard(t *testing.T, statePath string) (*Guard, *fakeAPI, ed25519.PrivateKey) {
+	treasuryPub, treasury, _ := ed25519.GenerateKey(nil)
+	approverPub, approver, _ := ed25519.GenerateKey(nil)
+	allow := &Allowlist{Entries: []Entry{
+		{Coin: "BTC", Address: btcAddr, Label: "cold wallet"},
+		{Coin: "XRP", Address: xrpAddr, DestinationTag: 42},
+		{Coin: "BRL", Address: "001122"},
+	}}
+	if err := allow.Sign(treasury); err != nil {
+		t.Fatal(err)
+	}
+	api := &fakeAPI{}
+	g, err := New(api, Config{
+		Allowlist:          allow,
+		AllowlistKey:       treasuryPub,
+		DailyCaps:          map[tapi.Coin]string{tapi.BTC: "2", tapi.BRL: "1000"},
+		ApprovalThresholds: map[tapi.Coin]string{tapi.BTC: "1"},
+		Approvers:          []ed25519.PublicKey{approverPub},
+		StatePath:          statePath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g, api, approver
+}
+
+func TestAllowlist(t *testing.T) {
+	g, api, _ := newTestGuard(t, "")
+	tests := []struct {
+		coin tapi.Coin
+		i    *tapi.WithdrawInfo
+		err  error
+	}{
+		{tapi.BTC, &tapi.WithdrawInfo{Address: btcAddr, Quantity: "0.5"}, nil},
+		{tapi.BTC, &tapi.WithdrawInfo{Address: xrpAddr, Quantity: "0.5"}, ErrNotAllowlisted},
+		{tapi.XRP, &tapi.WithdrawInfo{Address: xrpAddr, Quantity: "10", DestinationTag: 42}, nil},
+		{tapi.XRP, &tapi.WithdrawInfo{Address: xrpAddr, Quantity: "10", DestinationTag: 7}, ErrNotAllowlisted},
+		{tapi.XRP, &tapi.WithdrawInfo{Address: xrpAddr, Quantity: "200", DestinationTag: 42}, ErrExchangeLimit},
+	}
+	for _, tt := range tests {
+		_, err := g.WithdrawCrypto(tt.coin, "", tt.i)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%v %+v: got error %v, expected %v", tt.coin, tt.i, err, tt.err)
+		}
+	}
+	if api.withdrawals != 2 {
+		t.Errorf("got %d withdrawals, expected %d", api.withdrawals, 2)
+	}
+	if _, err := g.WithdrawBRL("", "100", "999"); !errors.Is(err, ErrNotAllowlisted) {
+		t.Errorf("got error %v, expected %v", err, ErrNotAllowlisted)
+	}
+}
+
+func TestBadSignature(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	allow := &Allowlist{Entries: []Entry{{Coin: "BTC", Address: btcAddr}}}
+	if err := allow.Sign(priv); err != nil {
+		t.Fatal(err)
+	}
+	allow.Entries[0].Address = xrpAddr
+	if err := allow.Verify(pub); err != ErrBadSignature {
+		t.Errorf("got error %v, expected %v", err, ErrBadSignature)
+	}
+}
+
+func TestNewVerifiesAllowlist(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	other, _, _ := ed25519.GenerateKey(nil)
+	allow := &Allowlist{Entries: []Entry{{Coin: "BTC", Address: btcAddr}}}
+	if err := allow.Sign(priv); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(&fakeAPI{}, Config{Allowlist: allow, AllowlistKey: other}); err != ErrBadSignature {
+		t.Errorf("got error %v, expected %v", err, ErrBadSignature)
+	}
+	if _, err := New(&fakeAPI{}, Config{Allowlist: allow}); err == nil {
+		t.Error("got guard without allowlist key")
+	}
+	if _, err := New(&fakeAPI{}, Config{Allowlist: allow, AllowlistKey: pub}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAmbiguousFailureCounts(t *testing.T) {
+	g, api, _ := newTestGuard(t, "")
+	i := &tapi.WithdrawInfo{Address: btcAddr, Quantity: "1"}
+	api.err = &tapi.Error{Code: 400}
+	if _, err := g.WithdrawCrypto(tapi.BTC, "", i); err == nil {
+		t.Fatal("got no error")
+	}
+	// A timeout may have withdrawn, so it uses the cap.
+	api.err = errors.New("timeout")
+	if _, err := g.WithdrawCrypto(tapi.BTC, "", i); err != api.err {
+		t.Fatalf("got error %v, expected %v", err, api.err)
+	}
+	api.err = nil
+	if _, err := g.WithdrawCrypto(tapi.BTC, "", i); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.WithdrawCrypto(tapi.BTC, "", i); !errors.Is(err, ErrDailyCap) {
+		t.Errorf("got error %v, expected %v", err, ErrDailyCap)
+	}
+}
+
+func TestExchangeLimit(t *testing.T) {
+	g, api, _ := newTestGuard(t, "")
+	if _, err := g.WithdrawBRL("", "990", "001122"); !errors.Is(err, ErrExchangeLimit) {
+		t.Errorf("got error %v, expected %v", err, ErrExchangeLimit)
+	}
+	if _, err := g.WithdrawBRL("", "-500", "001122"); err == nil {
+		t.Error("got no error for a negative quantity")
+	}
+	// An unknown limit, as on ClientV4, is not checked.
+	api.noLimits = true
+	if _, err := g.WithdrawBRL("", "990", "001122"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestApprovalAndDailyCap(t *testing.T) {
+	g, _, approver := newTestGuard(t, "")
+	i := &tapi.WithdrawInfo{Address: btcAddr, Quantity: "1.5"}
+	if _, err := g.WithdrawCrypto(tapi.BTC, "", i); !errors.Is(err, ErrApprovalRequired) {
+		t.Fatalf("got error %v, expected %v", err, ErrApprovalRequired)
+	}
+	req := Request{Coin: tapi.BTC, Address: btcAddr, Quantity: "1.5"}
+	expired := Approve(approver, req, time.Now().Add(-time.Minute))
+	if _, err := g.WithdrawCryptoApproved(tapi.BTC, "", i, expired); !errors.Is(err, ErrInvalidApproval) {
+		t.Fatalf("got error %v, expected %v", err, ErrInvalidApproval)
+	}
+	other := Approve(approver, Request{Coin: tapi.BTC, Address: btcAddr, Quantity: "1.4"}, time.Now().Add(time.Hour))
+	if _, err := g.WithdrawCryptoApproved(tapi.BTC, "", i, other); !errors.Is(err, ErrInvalidApproval) {
+		t.Fatalf("got error %v, expected %v", err, ErrInvalidApproval)
+	}
+	token := Approve(approver, req, time.Now().Add(time.Hour))
+	if _, err := g.WithdrawCryptoApproved(tapi.BTC, "", i, token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.WithdrawCryptoApproved(tapi.BTC, "", i, token); !errors.Is(err, ErrInvalidApproval) {
+		t.Fatalf("reused token: got error %v, expected %v", err, ErrInvalidApproval)
+	}
+	i.Quantity = "0.6"
+	if _, err := g.WithdrawCrypto(tapi.BTC, "", i); !errors.Is(err, ErrDailyCap) {
+		t.Fatalf("got error %v, expected %v", err, ErrDailyCap)
+	}
+	i.Quantity = "0.5"
+	if _, err := g.WithdrawCrypto(tapi.BTC, "", i); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new day resets the usage.
+	g.now = func() time.Time { return time.Now().Add(24 * time.Hour) }
+	if _, err := g.WithdrawCrypto(tapi.BTC, "", i); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "guard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+	g, _, _ := newTestGuard(t, path)
+	if _, err := g.WithdrawBRL("", "900", "001122"); err != nil {
+		t.Fatal(err)
+	}
+	g, _, _ = newTestGuard(t, path)
+	if _, err := g.WithdrawBRL("", "200", "001122"); !errors.Is(err, ErrDailyCap) {
+		t.Errorf("got error %v, expected %v", err, ErrDailyCap)
+	}
+}
@@ -0,0 +1,397 @@
+// Package idem makes the limit order placement of a tapi.API idempotent.
+//
+// The tapi has no client order ID, so when placing an order fails in
+// transport there is no way to ask whether the exchange accepted it.
+// The API of this package records the intent of each order with a
+// reference before sending it. After an ambiguous failure it looks for
+// the order with ListOrders, matching pair, side, quantity, limit price
+// and creation time, and returns it instead of placing a duplicate. If
+// the order is not listed yet, the intent stays pending and the next calls
+// with the same reference look for it again, failing with ErrUnconfirmed
+// until the window has passed and the order is placed again.
+//
+// If the API is a tapi.Normalizer, the intents record the quantity and
+// limit price as sent, after the rounding of WithRounding, so they match
+// the listed orders.
+package idem
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	tapi "github.com/rschio/mb-tapi"
+	"github.com/rschio/mb-tapi/internal/dec"
+)
+
+// DefaultWindow is the max difference between the time of an intent and
+// the creation time of its order.
+const DefaultWindow = time.Minute
+
+// retention is how long resolved intents are kept.
+const retention = 7 * 24 * time.Hour
+
+var (
+	// ErrUnconfirmed is returned when the placement failed in an
+	// ambiguous way and the order could not be found, until the window
+	// has passed. Retrying with the same reference is safe.
+	ErrUnconfirmed = errors.New("idem: order placement unconfirmed")
+
+	// ErrRefMismatch is returned when a reference is reused for a
+	// different order.
+	ErrRefMismatch = errors.New("idem: reference used by another order")
+)
+
+// Intent states.
+const (
+	StatePending = "pending"
+	StatePlaced  = "placed"
+	StateFailed  = "failed"
+)
+
+// Intent is an order placement recorded before it is sent.
+type Intent struct {
+	Ref        string `json:"ref"`
+	Pair       string `json:"pair"`
+	Type       int    `json:"type"`
+	Quantity   string `json:"quantity"`
+	LimitPrice string `json:"limit_price"`
+
+	// Time is the unix time, in seconds, the order was sent.
+	Time int64 `json:"time"`
+
+	State   string `json:"state"`
+	OrderID int    `json:"order_id,omitempty"`
+}
+
+func (i *Intent) same(o *Intent) bool {
+	return i.Pair == o.Pair && i.Type == o.Type &&
+		equal(i.Quantity, o.Quantity) && equal(i.LimitPrice, o.LimitPrice)
+}
+
+// Config configures an API.
+type Config struct {
+	// StatePath is the file where the intents are saved. Empty means
+	// keep them only in memory, so they do not survive a restart.
+	StatePath string
+
+	// Window is the max difference between the time of an intent and
+	// the creation time of the order. Zero means DefaultWindow.
+	Window time.Duration
+
+	// Now returns the time of the exchange, which stamps the creation
+	// time of the orders, as the Now of a tapi.Client with a Clock. Nil
+	// means time.Now.
+	Now func() time.Time
+}
+
+// API is a tapi.API with idempotent limit order placement. The market
+// order methods are not changed, the API does not return the quantity
+// of a market buy to match it with.
+type API struct {
+	tapi.API
+
+	path   string
+	window time.Duration
+	now    func() time.Time
+
+	mu       sync.Mutex
+	intents  map[string]*Intent
+	inflight map[string]chan struct{}
+}
+
+// New creates an API that places the limit orders of api idempotently.
+func New(api tapi.API, cfg Config) (*API, error) {
+	a := &API{
+		API:      api,
+		path:     cfg.StatePath,
+		window:   cfg.Window,
+		now:      cfg.Now,
+		intents:  make(map[string]*Intent),
+		inflight: make(map[string]chan struct{}),
+	}
+	if a.window == 0 {
+		a.window = DefaultWindow
+	}
+	if a.now == nil {
+		a.now = time.Now
+	}
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// NewRef returns a random reference.
+func NewRef() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// PlaceBuyOrder places a buy order with a new reference.
+func (a *API) PlaceBuyOrder(c1, c2 tapi.Coin, qt, limit string) (*tapi.Order, error) {
+	return a.PlaceBuyOrderRef(NewRef(), c1, c2, qt, limit)
+}
+
+// PlaceSellOrder places a sell order with a new reference.
+func (a *API) PlaceSellOrder(c1, c2 tapi.Coin, qt, limit string) (*tapi.Order, error) {
+	return a.PlaceSellOrderRef(NewRef(), c1, c2, qt, limit)
+}
+
+// PlaceBuyOrderRef places a buy order identified by ref. Calling it
+// again with the same ref returns the order already placed.
+func (a *API) PlaceBuyOrderRef(ref string, c1, c2 tapi.Coin, qt, limit string) (*tapi.Order, error) {
+	return a.place(ref, c1, c2, tapi.OrderTypeBuy, qt, limit)
+}
+
+// PlaceSellOrderRef places a sell order identified by ref. Calling it
+// again with the same ref returns the order already placed.
+func (a *API) PlaceSellOrderRef(ref string, c1, c2 tapi.Coin, qt, limit string) (*tapi.Order, error) {
+	return a.place(ref, c1, c2, tapi.OrderTypeSell, qt, limit)
+}
+
+// Intent returns the intent of ref.
+func (a *API) Intent(ref string) (Intent, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	i, ok := a.intents[ref]
+	if !ok {
+		return Intent{}, false
+	}
+	return *i, true
+}
+
+// lock makes the calls with ref run one at a time, without holding mu
+// across the requests. It returns the unlock function.
+func (a *API) lock(ref string) func() {
+	for {
+		a.mu.Lock()
+		ch, busy := a.inflight[ref]
+		if !busy {
+			ch = make(chan struct{})
+			a.inflight[ref] = ch
+			a.mu.Unlock()
+			return func() {
+				a.mu.Lock()
+				delete(a.inflight, ref)
+				a.mu.Unlock()
+				close(ch)
+			}
+		}
+		a.mu.Unlock()
+		<-ch
+	}
+}
+
+func (a *API) place(ref string, c1, c2 tapi.Coin, typ int, qt, limit string) (*tapi.Order, error) {
+	unlock := a.lock(ref)
+	defer unlock()
+
+	if n, ok := a.API.(tapi.Normalizer); ok {
+		// Invalid params are sent as given and rejected by the API.
+		if q, l, _, err := n.NormalizeOrder(c1, c2, typ == tapi.OrderTypeBuy, qt, limit, ""); err == nil {
+			qt, limit = q, l
+		}
+	}
+
+	in := &Intent{
+		Ref:        ref,
+		Pair:       c1.String() + c2.String(),
+		Type:       typ,
+		Quantity:   qt,
+		LimitPrice: limit,
+	}
+	a.mu.Lock()
+	old, ok := a.intents[ref]
+	a.mu.Unlock()
+	if ok {
+		// Only the holder of the ref lock changes its intent.
+		if !old.same(in) {
+			return nil, fmt.Errorf("%w: %s", ErrRefMismatch, ref)
+		}
+		switch old.State {
+		case StatePlaced:
+			return a.API.GetOrder(c1, c2, old.OrderID)
+		case StatePending:
+			o, err := a.reconcile(c1, c2, old)
+			if o != nil {
+				return o, err
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s: %v", ErrUnconfirmed, ref, err)
+			}
+			// An order created later than the window is not
+			// matched, so only then it is placed again.
+			if a.now().Sub(time.Unix(old.Time, 0)) <= a.window {
+				return nil, fmt.Errorf("%w: %s: not listed yet", ErrUnconfirmed, ref)
+			}
+		}
+	}
+
+	in.Time = a.now().Unix()
+	in.State = StatePending
+	a.mu.Lock()
+	a.intents[ref] = in
+	err := a.save()
+	if err != nil {
+		delete(a.intents, ref)
+	}
+	a.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var o *tapi.Order
+	if typ == tapi.OrderTypeBuy {
+		o, err = a.API.PlaceBuyOrder(c1, c2, qt, limit)
+	} else {
+		o, err = a.API.PlaceSellOrder(c1, c2, qt, limit)
+	}
+	switch {
+	case err == nil:
+		a.mu.Lock()
+		in.State = StatePlaced
+		in.OrderID = o.ID
+		err = a.save()
+		a.mu.Unlock()
+		if err != nil {
+			return o, fmt.Errorf("idem: order %d placed but intent not saved: %v", o.ID, err)
+		}
+		return o, nil
+	case !tapi.Ambiguous(err):
+		a.mu.Lock()
+		in.State = StateFailed
+		a.save()
+		a.mu.Unlock()
+		return nil, err
+	}
+	// The order may be listed late, so the intent stays pending and is
+	// reconciled again by the next call with ref.
+	o, rerr := a.reconcile(c1, c2, in)
+	if o != nil {
+		return o, rerr
+	}
+	return nil, fmt.Errorf("%w: %s: %v", ErrUnconfirmed, ref, err)
+}
+
+// reconcile looks for the order of in and marks in as placed if it is
+// found. It returns nil if there is none. Orders already matched with
+// other intents are skipped, so two equal orders placed close in time are
+// not confused.
+func (a *API) reconcile(c1, c2 tapi.Coin, in *Intent) (*tapi.Order, error) {
+	from := time.Unix(in.Time, 0).Add(-a.window)
+	opts := &tapi.ListOrdersOpts{
+		OrderType:     1,
+		FromTimestamp: strconv.FormatInt(from.Unix(), 10),
+	}
+	if in.Type == tapi.OrderTypeSell {
+		opts.OrderType = -1
+	}
+	orders, err := a.API.ListOrders(c1, c2, opts)
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	claimed := make(map[int]bool)
+	for _, i := range a.intents {
+		if i.State == StatePlaced {
+			claimed[i.OrderID] = true
+		}
+	}
+	var found *tapi.Order
+	for k := range orders {
+		o := &orders[k]
+		if claimed[o.ID] || o.Type != in.Type ||
+			!equal(o.Quantity, in.Quantity) || !equal(o.LimitPrice, in.LimitPrice) {
+			continue
+		}
+		t, err := tapi.ParseTimestamp(o.CreatedTimestamp)
+		if err != nil {
+			continue
+		}
+		if d := t.Sub(time.Unix(in.Time, 0)); d < -a.window || d > a.window {
+			continue
+		}
+		if found == nil || o.ID < found.ID {
+			found = o
+		}
+	}
+	if found == nil {
+		return nil, nil
+	}
+	in.State = StatePlaced
+	in.OrderID = found.ID
+	if err := a.save(); err != nil {
+		return found, fmt.Errorf("idem: order %d placed but intent not saved: %v", found.ID, err)
+	}
+	return found, nil
+}
+
+func equal(x, y string) bool {
+	a, err := dec.Parse(x)
+	if err != nil {
+		return x == y
+	}
+	b, err := dec.Parse(y)
+	if err != nil {
+		return false
+	}
+	return a.Cmp(b) == 0
+}
+
+func (a *API) load() error {
+	if a.path == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(a.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var intents []*Intent
+	if err := json.Unmarshal(b, &intents); err != nil {
+		return fmt.Errorf("idem: invalid state %s: %v", a.path, err)
+	}
+	for _, i := range intents {
+		a.intents[i.Ref] = i
+	}
+	return nil
+}
+
+// save writes the intents, dropping the old resolved ones.
+func (a *API) save() error {
+	old := a.now().Add(-retention).Unix()
+	intents := make([]*Intent, 0, len(a.intents))
+	for ref, i := range a.intents {
+		if i.State != StatePending && i.Time < old {
+			delete(a.intents, ref)
+			continue
+		}
+		intents = append(intents, i)
+	}
+	if a.path == "" {
+		return nil
+	}
+	b, err := json.Marshal(intents)
+	if err != nil {
+		return err
+	}
+	tmp := a.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, a.path)
+}
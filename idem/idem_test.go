@@ -0,0 +1,259 @@
+package idem
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	tapi "github.com/rschio/mb-tapi"
+)
+
+// fakeAPI accepts every order, but fails in transport while lost is set.
+type fakeAPI struct {
+	tapi.API
+
+	now    time.Time
+	orders []tapi.Order
+	lost   bool
+	late   bool
+	placed int
+	listed int
+}
+
+func (f *fakeAPI) PlaceBuyOrder(c1, c2 tapi.Coin, qt, limit string) (*tapi.Order, error) {
+	if limit == "0" {
+		return nil, &tapi.Error{Code: 217, Err: "invalid limit price"}
+	}
+	f.placed++
+	o := tapi.Order{
+		ID:               len(f.orders) + 1,
+		CoinPair:         c1.String() + c2.String(),
+		Type:             tapi.OrderTypeBuy,
+		Status:           tapi.OrderStatusOpen,
+		Quantity:         qt + "000",
+		LimitPrice:       limit,
+		CreatedTimestamp: strconv.FormatInt(f.now.Unix()+2, 10),
+	}
+	f.orders = append(f.orders, o)
+	if f.lost {
+		return nil, errors.New("connection reset by peer")
+	}
+	return &o, nil
+}
+
+func (f *fakeAPI) ListOrders(c1, c2 tapi.Coin, opts *tapi.ListOrdersOpts) ([]tapi.Order, error) {
+	f.listed++
+	if f.late {
+		return nil, nil
+	}
+	return f.orders, nil
+}
+
+func (f *fakeAPI) GetOrder(c1, c2 tapi.Coin, id int) (*tapi.Order, error) {
+	return &f.orders[id-1], nil
+}
+
+func TestPlace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "idem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "intents")
+
+	f := &fakeAPI{now: time.Unix(1600000000, 0)}
+	a, err := New(f, Config{StatePath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.now = func() time.Time { return f.now }
+
+	o, err := a.PlaceBuyOrderRef("a", tapi.BRL, tapi.BTC, "0.1", "100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The same ref returns the same order.
+	o2, err := a.PlaceBuyOrderRef("a", tapi.BRL, tapi.BTC, "0.1", "100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o2.ID != o.ID || f.placed != 1 {
+		t.Errorf("got order %d and %d placed, expected order %d and 1 placed", o2.ID, f.placed, o.ID)
+	}
+	if _, err := a.PlaceBuyOrderRef("a", tapi.BRL, tapi.BTC, "0.2", "100"); !errors.Is(err, ErrRefMismatch) {
+		t.Errorf("got error %v, expected %v", err, ErrRefMismatch)
+	}
+
+	// An accepted order lost in transport is found, skipping the equal
+	// order already matched with ref a.
+	f.lost = true
+	o, err = a.PlaceBuyOrderRef("b", tapi.BRL, tapi.BTC, "0.1", "100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.ID != 2 || f.placed != 2 || f.listed != 1 {
+		t.Errorf("got order %d, %d placed and %d listed, expected 2, 2 and 1", o.ID, f.placed, f.listed)
+	}
+	if in, _ := a.Intent("b"); in.State != StatePlaced || in.OrderID != 2 {
+		t.Errorf("got intent %+v, expected placed order 2", in)
+	}
+
+	// An order listed late stays unconfirmed and is found by the next
+	// call instead of placed again.
+	f.late = true
+	if _, err := a.PlaceBuyOrderRef("c", tapi.BRL, tapi.BTC, "0.1", "100"); !errors.Is(err, ErrUnconfirmed) {
+		t.Errorf("got error %v, expected %v", err, ErrUnconfirmed)
+	}
+	if in, _ := a.Intent("c"); in.State != StatePending {
+		t.Errorf("got intent %+v, expected pending", in)
+	}
+	if _, err := a.PlaceBuyOrderRef("c", tapi.BRL, tapi.BTC, "0.1", "100"); !errors.Is(err, ErrUnconfirmed) || f.placed != 3 {
+		t.Errorf("got error %v and %d placed, expected %v and 3", err, f.placed, ErrUnconfirmed)
+	}
+	f.late, f.lost = false, false
+	o, err = a.PlaceBuyOrderRef("c", tapi.BRL, tapi.BTC, "0.1", "100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.ID != 3 || f.placed != 3 {
+		t.Errorf("got order %d and %d placed, expected 3 and 3", o.ID, f.placed)
+	}
+
+	// A rejected order is not looked up.
+	n := f.listed
+	if _, err := a.PlaceBuyOrder(tapi.BRL, tapi.BTC, "0.1", "0"); !errors.Is(err, &tapi.Error{Code: 217}) {
+		t.Errorf("got error %v, expected code 217", err)
+	}
+	if f.listed != n {
+		t.Errorf("got %d listed, expected %d", f.listed, n)
+	}
+
+	// The intents survive a restart.
+	a, err = New(f, Config{StatePath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err = a.PlaceBuyOrderRef("b", tapi.BRL, tapi.BTC, "0.1", "100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.ID != 2 || f.placed != 3 {
+		t.Errorf("got order %d and %d placed, expected 2 and 3", o.ID, f.placed)
+	}
+}
+
+// roundingAPI rounds the quantity to 2 decimals like a client with
+// WithRounding.
+type roundingAPI struct {
+	*fakeAPI
+}
+
+func (r roundingAPI) NormalizeOrder(c1, c2 tapi.Coin, buy bool, qt, limit, cost string) (string, string, string, error) {
+	if qt == "0.123" {
+		qt = "0.12"
+	}
+	return qt, limit, cost, nil
+}
+
+func TestPlaceNormalized(t *testing.T) {
+	f := &fakeAPI{now: time.Unix(1600000000, 0), lost: true}
+	a, err := New(roundingAPI{f}, Config{Now: func() time.Time { return f.now }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := a.PlaceBuyOrderRef("a", tapi.BRL, tapi.BTC, "0.123", "100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.ID != 1 || f.placed != 1 {
+		t.Errorf("got order %d and %d placed, expected 1 and 1", o.ID, f.placed)
+	}
+	if in, _ := a.Intent("a"); in.Quantity != "0.12" {
+		t.Errorf("got intent quantity %s, expected 0.12", in.Quantity)
+	}
+}
+
+func TestPendingWindow(t *testing.T) {
+	f := &fakeAPI{now: time.Unix(1600000000, 0), lost: true, late: true}
+	a, err := New(f, Config{Now: func() time.Time { return f.now }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := a.PlaceBuyOrderRef("a", tapi.BRL, tapi.BTC, "0.1", "100"); !errors.Is(err, ErrUnconfirmed) {
+			t.Errorf("got error %v, expected %v", err, ErrUnconfirmed)
+		}
+	}
+	if f.placed != 1 {
+		t.Errorf("got %d placed inside the window, expected 1", f.placed)
+	}
+	// An order not listed after the window was not placed, so it is
+	// placed again.
+	f.now = f.now.Add(2 * DefaultWindow)
+	f.lost = false
+	if _, err := a.PlaceBuyOrderRef("a", tapi.BRL, tapi.BTC, "0.1", "100"); err != nil {
+		t.Fatal(err)
+	}
+	if f.placed != 2 {
+		t.Errorf("got %d placed after the window, expected 2", f.placed)
+	}
+}
+
+func TestReconcileWindow(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	f := &fakeAPI{now: now, orders: []tapi.Order{
+		{ID: 1, Type: tapi.OrderTypeBuy, Quantity: "0.1", LimitPrice: "100",
+			CreatedTimestamp: strconv.FormatInt(now.Add(-time.Hour).Unix(), 10)},
+		{ID: 2, Type: tapi.OrderTypeSell, Quantity: "0.1", LimitPrice: "100",
+			CreatedTimestamp: strconv.FormatInt(now.Unix(), 10)},
+		{ID: 3, Type: tapi.OrderTypeBuy, Quantity: "0.1", LimitPrice: "101",
+			CreatedTimestamp: strconv.FormatInt(now.Unix(), 10)},
+	}}
+	a, err := New(f, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := &Intent{Pair: "BRLBTC", Type: tapi.OrderTypeBuy, Quantity: "0.10", LimitPrice: "100", Time: now.Unix()}
+	o, err := a.reconcile(tapi.BRL, tapi.BTC, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o != nil {
+		t.Errorf("got order %d, expected none", o.ID)
+	}
+	in.LimitPrice = "101.0"
+	o, err = a.reconcile(tapi.BRL, tapi.BTC, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o == nil || o.ID != 3 {
+		t.Errorf("got order %v, expected 3", o)
+	}
+}
+
+func TestPlaceConcurrent(t *testing.T) {
+	f := &fakeAPI{now: time.Unix(1600000000, 0)}
+	a, err := New(f, Config{Now: func() time.Time { return f.now }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := a.PlaceBuyOrderRef("a", tapi.BRL, tapi.BTC, "0.1", "100"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if f.placed != 1 {
+		t.Errorf("got %d placed, expected 1", f.placed)
+	}
+}
@@ -0,0 +1,47 @@
+// Package dec has helpers to do exact math with the decimal strings
+// returned by the API.
+package dec
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// Parse parses the decimal string s. An empty string is parsed as zero.
+func Parse(s string) (*big.Rat, error) {
+	if s == "" {
+		return new(big.Rat), nil
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, fmt.Errorf("invalid decimal %q", s)
+	}
+	return r, nil
+}
+
+// Zero returns a new zero value.
+func Zero() *big.Rat { return new(big.Rat) }
+
+// Add returns x + y.
+func Add(x, y *big.Rat) *big.Rat { return new(big.Rat).Add(x, y) }
+
+// Sub returns x - y.
+func Sub(x, y *big.Rat) *big.Rat { return new(big.Rat).Sub(x, y) }
+
+// Mul returns x * y.
+func Mul(x, y *big.Rat) *big.Rat { return new(big.Rat).Mul(x, y) }
+
+// Quo returns x / y. It panics if y is zero.
+func Quo(x, y *big.Rat) *big.Rat { return new(big.Rat).Quo(x, y) }
+
+// Percent returns x * p / 100.
+func Percent(x, p *big.Rat) *big.Rat {
+	r := new(big.Rat).Mul(x, p)
+	return r.Quo(r, big.NewRat(100, 1))
+}
+
+// Format formats x with prec decimal places, rounding half away
+// from zero.
+func Format(x *big.Rat, prec int) string {
+	return x.FloatString(prec)
+}
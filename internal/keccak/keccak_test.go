@@ -0,0 +1,24 @@
+package keccak
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestSum256(t *testing.T) {
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{"", "c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470"},
+		{"abc", "4e03657aea45a94fc7d47ba826c8d667c0d1e6e33a64a036ec44f58fa12d6c45"},
+		{strings.Repeat("\x00", 300), "347b017cb0632f78c0c51dfedd8e31b8d2c31e5bf282c1e8c370e45ef8b0f7f0"},
+	}
+	for _, tt := range tests {
+		sum := Sum256([]byte(tt.in))
+		if got := hex.EncodeToString(sum[:]); got != tt.out {
+			t.Errorf("Sum256(%q) = %s, expected %s", tt.in, got, tt.out)
+		}
+	}
+}
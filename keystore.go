@@ -0,0 +1,176 @@
+package tapi
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// PBKDF2 iteration counts. The count of a keystore is read from the
+// file, so it is bounded: a low count weakens the key and a huge one
+// hangs the process.
+const (
+	keystoreIter    = 200000
+	keystoreMinIter = 100000
+	keystoreMaxIter = 10000000
+)
+
+// ErrKeystorePassphrase is returned when the keystore can not be
+// decrypted with the passphrase.
+var ErrKeystorePassphrase = errors.New("keystore: wrong passphrase or corrupted file")
+
+// keystoreFile is the format of a keystore on disk. Data is the AES-GCM
+// encrypted JSON of the entries, with a key derived from the passphrase
+// by PBKDF2-HMAC-SHA256.
+type keystoreFile struct {
+	Salt  []byte `json:"salt"`
+	Iter  int    `json:"iter"`
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+// WriteKeystore encrypts entries, credentials by name, with passphrase
+// and saves them to path with 0600 permissions. Writing to the path of
+// an open Keystore rotates its credentials.
+func WriteKeystore(path string, passphrase []byte, entries map[string]Credentials) error {
+	plain, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	kf := keystoreFile{Salt: make([]byte, 16), Iter: keystoreIter}
+	if _, err := rand.Read(kf.Salt); err != nil {
+		return err
+	}
+	gcm, err := keystoreCipher(passphrase, kf.Salt, kf.Iter)
+	if err != nil {
+		return err
+	}
+	kf.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(kf.Nonce); err != nil {
+		return err
+	}
+	kf.Data = gcm.Seal(nil, kf.Nonce, plain, nil)
+	b, err := json.Marshal(kf)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Keystore is a CredentialProvider of an entry of an encrypted keystore
+// file. The file is decrypted again only when it changes. It is safe for
+// concurrent use.
+type Keystore struct {
+	path string
+	name string
+	pass []byte
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	creds   Credentials
+}
+
+// OpenKeystore creates a Keystore of the entry name of the keystore in
+// path.
+func OpenKeystore(path, name string, passphrase []byte) (*Keystore, error) {
+	k := &Keystore{path: path, name: name, pass: passphrase}
+	if _, err := k.Credentials(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Credentials returns the credentials of the entry.
+func (k *Keystore) Credentials() (Credentials, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	fi, err := os.Stat(k.path)
+	if err != nil {
+		return Credentials{}, err
+	}
+	if fi.ModTime().Equal(k.modTime) && fi.Size() == k.size {
+		return k.creds, nil
+	}
+	b, err := readPrivateFile(k.path)
+	if err != nil {
+		return Credentials{}, err
+	}
+	var kf keystoreFile
+	if err := json.Unmarshal(b, &kf); err != nil {
+		return Credentials{}, fmt.Errorf("keystore: invalid file %s: %v", k.path, err)
+	}
+	gcm, err := keystoreCipher(k.pass, kf.Salt, kf.Iter)
+	if err != nil {
+		return Credentials{}, err
+	}
+	if len(kf.Nonce) != gcm.NonceSize() {
+		return Credentials{}, ErrKeystorePassphrase
+	}
+	plain, err := gcm.Open(nil, kf.Nonce, kf.Data, nil)
+	if err != nil {
+		return Credentials{}, ErrKeystorePassphrase
+	}
+	var entries map[string]Credentials
+	if err := json.Unmarshal(plain, &entries); err != nil {
+		return Credentials{}, fmt.Errorf("keystore: invalid entries: %v", err)
+	}
+	c, ok := entries[k.name]
+	if !ok {
+		return Credentials{}, fmt.Errorf("%w: no entry %q in %s", ErrNoCredentials, k.name, k.path)
+	}
+	k.creds, k.modTime, k.size = c, fi.ModTime(), fi.Size()
+	return c, nil
+}
+
+func keystoreCipher(passphrase, salt []byte, iter int) (cipher.AEAD, error) {
+	if iter < keystoreMinIter || iter > keystoreMaxIter {
+		return nil, fmt.Errorf("keystore: iteration count %d out of the range %d to %d",
+			iter, keystoreMinIter, keystoreMaxIter)
+	}
+	block, err := aes.NewCipher(pbkdf2(passphrase, salt, iter, 32))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// pbkdf2 derives a key of size keyLen with PBKDF2-HMAC-SHA256 (RFC 8018).
+func pbkdf2(password, salt []byte, iter, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	n := (keyLen + prf.Size() - 1) / prf.Size()
+	out := make([]byte, 0, n*prf.Size())
+	var idx [4]byte
+	for block := 1; block <= n; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(idx[:], uint32(block))
+		prf.Write(idx[:])
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < iter; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		out = append(out, t...)
+	}
+	return out[:keyLen]
+}
@@ -0,0 +1,212 @@
+package tapi
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+// CancelFilter selects the orders cancelled by CancelAll. Any field with
+// zero value means not set.
+type CancelFilter struct {
+	// Pairs are the coin pairs, such as {BRL, BTC}. Not set means BRL
+	// against every digital coin.
+	Pairs [][2]Coin
+
+	// OrderType filters the orders by type, as in ListOrdersOpts.
+	OrderType int
+
+	// Workers is the number of concurrent cancellations, 4 if not
+	// set. The rate limiter of the client is still respected.
+	Workers int
+
+	// Retries is the number of retries of a failed cancellation, 3
+	// if not set.
+	Retries int
+
+	// RetryDelay is the wait before a retry, one second if not set.
+	RetryDelay time.Duration
+}
+
+// CancelFailure is an order that could not be cancelled.
+type CancelFailure struct {
+	Order Order
+	Err   error
+}
+
+// CancelReport is the result of CancelAll.
+type CancelReport struct {
+	// Cancelled are the orders cancelled.
+	Cancelled []Order
+
+	// Filled are the orders filled before they were cancelled.
+	Filled []Order
+
+	// Failed are the orders still open after the retries.
+	Failed []CancelFailure
+
+	// ListErrors are the pairs whose open orders could not be listed.
+	ListErrors map[string]error
+}
+
+// OK reports whether every open order was cancelled or filled.
+func (r *CancelReport) OK() bool {
+	return len(r.Failed) == 0 && len(r.ListErrors) == 0
+}
+
+func (r *CancelReport) String() string {
+	return fmt.Sprintf("%d cancelled, %d filled, %d failed, %d pairs not listed",
+		len(r.Cancelled), len(r.Filled), len(r.Failed), len(r.ListErrors))
+}
+
+// CancelAll cancels the open orders of c selected by f. See the
+// function CancelAll.
+func (c *Client) CancelAll(ctx context.Context, f *CancelFilter) (*CancelReport, error) {
+	return CancelAll(ctx, c, f)
+}
+
+// CancelAll is a kill switch: it lists the open orders of every pair of
+// f and cancels them concurrently, retrying the failures. Use f = nil to
+// cancel every open order. The error is only set when ctx is done, the
+// report has the orders that failed.
+func CancelAll(ctx context.Context, api API, f *CancelFilter) (*CancelReport, error) {
+	cf := CancelFilter{}
+	if f != nil {
+		cf = *f
+	}
+	if len(cf.Pairs) == 0 {
+		for _, c := range Coins {
+			if c != BRL {
+				cf.Pairs = append(cf.Pairs, [2]Coin{BRL, c})
+			}
+		}
+	}
+	if cf.Workers <= 0 {
+		cf.Workers = 4
+	}
+	if cf.Retries <= 0 {
+		cf.Retries = 3
+	}
+	if cf.RetryDelay <= 0 {
+		cf.RetryDelay = time.Second
+	}
+
+	type job struct {
+		c1, c2 Coin
+		order  Order
+	}
+	report := &CancelReport{ListErrors: make(map[string]error)}
+	var jobs []job
+	for _, p := range cf.Pairs {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		opts := &ListOrdersOpts{OrderType: cf.OrderType, StatusList: [3]int{1, 0, 0}}
+		orders, err := listAllOrders(api, p[0], p[1], opts)
+		if err != nil {
+			report.ListErrors[p[0].String()+p[1].String()] = err
+			continue
+		}
+		for _, o := range orders {
+			jobs = append(jobs, job{p[0], p[1], o})
+		}
+	}
+
+	var mu sync.Mutex
+	ch := make(chan job)
+	var wg sync.WaitGroup
+	for i := 0; i < cf.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range ch {
+				o, err := cancelOrder(ctx, api, j.c1, j.c2, j.order.ID, &cf)
+				mu.Lock()
+				switch {
+				case err != nil:
+					report.Failed = append(report.Failed, CancelFailure{Order: j.order, Err: err})
+				case o.Status == OrderStatusFilled:
+					report.Filled = append(report.Filled, *o)
+				default:
+					report.Cancelled = append(report.Cancelled, *o)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for k, j := range jobs {
+		select {
+		case ch <- j:
+			continue
+		case <-ctx.Done():
+		}
+		mu.Lock()
+		for _, j := range jobs[k:] {
+			report.Failed = append(report.Failed, CancelFailure{Order: j.order, Err: ctx.Err()})
+		}
+		mu.Unlock()
+		break
+	}
+	close(ch)
+	wg.Wait()
+	return report, ctx.Err()
+}
+
+// cancelOrder cancels order id. After a failure the order is fetched, so
+// an order filled or cancelled meanwhile is not retried.
+func cancelOrder(ctx context.Context, api API, c1, c2 Coin, id int, f *CancelFilter) (*Order, error) {
+	var err error
+	for i := 0; ; i++ {
+		var o *Order
+		o, err = api.CancelOrder(c1, c2, id)
+		if err == nil && o.Status != OrderStatusOpen {
+			return o, nil
+		}
+		if o, gerr := api.GetOrder(c1, c2, id); gerr == nil && o.Status != OrderStatusOpen {
+			return o, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("order %d still open", id)
+		}
+		if i == f.Retries {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(f.RetryDelay):
+		}
+	}
+}
+
+// CancelAllOnSignal calls CancelAll with api and f when one of the
+// signals arrives, os.Interrupt if none is given, and passes the result
+// to done. The handler is removed by stop.
+func CancelAllOnSignal(api API, f *CancelFilter, done func(*CancelReport, error), sig ...os.Signal) (stop func()) {
+	if len(sig) == 0 {
+		sig = []os.Signal{os.Interrupt}
+	}
+	ch := make(chan os.Signal, 1)
+	quit := make(chan struct{})
+	signal.Notify(ch, sig...)
+	go func() {
+		for {
+			select {
+			case <-ch:
+				done(CancelAll(context.Background(), api, f))
+			case <-quit:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			close(quit)
+		})
+	}
+}
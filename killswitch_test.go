@@ -0,0 +1,94 @@
+package tapi
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// killAPI has open orders in BRLBTC and BRLETH. Order 2 is filled
+// before the cancellation, order 3 fails twice and order 4 always fails.
+type killAPI struct {
+	API
+
+	mu       sync.Mutex
+	orders   map[string][]Order
+	statuses map[int]int
+	fails    map[int]int
+	listed   []ListOrdersOpts
+}
+
+func (k *killAPI) ListOrders(c1, c2 Coin, opts *ListOrdersOpts) ([]Order, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.listed = append(k.listed, *opts)
+	if c2 == LTC {
+		return nil, errors.New("timeout")
+	}
+	return k.orders[c1.String()+c2.String()], nil
+}
+
+func (k *killAPI) CancelOrder(c1, c2 Coin, id int) (*Order, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.statuses[id] == OrderStatusFilled {
+		return nil, &Error{Code: 204, Err: "order already filled"}
+	}
+	if k.fails[id] > 0 {
+		k.fails[id]--
+		return nil, errors.New("connection reset")
+	}
+	k.statuses[id] = OrderStatusCancelled
+	return &Order{ID: id, Status: OrderStatusCancelled}, nil
+}
+
+func (k *killAPI) GetOrder(c1, c2 Coin, id int) (*Order, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return &Order{ID: id, Status: k.statuses[id]}, nil
+}
+
+func TestCancelAll(t *testing.T) {
+	k := &killAPI{
+		orders: map[string][]Order{
+			"BRLBTC": {{ID: 1}, {ID: 2}, {ID: 3}},
+			"BRLETH": {{ID: 4}},
+		},
+		statuses: map[int]int{1: OrderStatusOpen, 2: OrderStatusFilled, 3: OrderStatusOpen, 4: OrderStatusOpen},
+		fails:    map[int]int{3: 2, 4: 100},
+	}
+	f := &CancelFilter{
+		Pairs:      [][2]Coin{{BRL, BTC}, {BRL, LTC}, {BRL, ETH}},
+		OrderType:  1,
+		RetryDelay: time.Millisecond,
+	}
+	r, err := CancelAll(context.Background(), k, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, opts := range k.listed {
+		if opts.StatusList != [3]int{1, 0, 0} || opts.OrderType != 1 {
+			t.Errorf("got list options %+v, expected open buy orders", opts)
+		}
+	}
+	if len(r.Cancelled) != 2 || len(r.Filled) != 1 || r.Filled[0].ID != 2 {
+		t.Errorf("got %v, expected 2 cancelled and order 2 filled", r)
+	}
+	if len(r.Failed) != 1 || r.Failed[0].Order.ID != 4 {
+		t.Errorf("got failed %+v, expected order 4", r.Failed)
+	}
+	if _, ok := r.ListErrors["BRLLTC"]; !ok || len(r.ListErrors) != 1 {
+		t.Errorf("got list errors %v, expected BRLLTC", r.ListErrors)
+	}
+	if r.OK() {
+		t.Error("got OK report, expected failures")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := CancelAll(ctx, k, nil); err != context.Canceled {
+		t.Errorf("got error %v, expected %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,261 @@
+package tapi
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/rschio/mb-tapi/internal/dec"
+)
+
+// Market contains the order rules of a coin pair. Empty fields are not
+// checked.
+type Market struct {
+	// PriceTick is the min increment of limit_price in BRL.
+	PriceTick string
+
+	// QuantityDecimals is the max number of decimals of quantity.
+	QuantityDecimals int
+
+	// CostDecimals is the max number of decimals of the cost of
+	// market buy orders.
+	CostDecimals int
+
+	// MinQuantity is the min quantity of digital coin of an order.
+	MinQuantity string
+
+	// MinValue is the min value in BRL of an order, quantity times
+	// limit_price or the cost of market buy orders.
+	MinValue string
+}
+
+// DefaultMarkets has the precision and min quantities described in the
+// tapi v3 documentation.
+var DefaultMarkets = map[Coin]Market{
+	BTC: {PriceTick: "0.00001", QuantityDecimals: 8, CostDecimals: 5, MinQuantity: "0.001"},
+	LTC: {PriceTick: "0.00001", QuantityDecimals: 8, CostDecimals: 5, MinQuantity: "0.009"},
+	BCH: {PriceTick: "0.00001", QuantityDecimals: 8, CostDecimals: 5, MinQuantity: "0.001"},
+	XRP: {PriceTick: "0.00001", QuantityDecimals: 8, CostDecimals: 5, MinQuantity: "0.1"},
+	ETH: {PriceTick: "0.00001", QuantityDecimals: 8, CostDecimals: 5, MinQuantity: "0.004"},
+}
+
+// Reasons of a ValidationError.
+var (
+	ErrInvalidNumber = errors.New("invalid number")
+	ErrPrecision     = errors.New("too many decimals")
+	ErrTickSize      = errors.New("not a multiple of the tick size")
+	ErrMinQuantity   = errors.New("below the min quantity")
+	ErrMinValue      = errors.New("below the min order value")
+)
+
+// ValidationError is returned by the order methods when a parameter
+// breaks the rules of the market. The request is not sent.
+type ValidationError struct {
+	Pair  string
+	Param string
+	Value string
+	Err   error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s %q of %s: %v", e.Param, e.Value, e.Pair, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error { return e.Err }
+
+// orderRules are the market rules checked before sending an order. Both
+// Client and ClientV4 embed them.
+type orderRules struct {
+	markets map[Coin]Market
+	round   bool
+}
+
+// Markets returns the rules checked before sending an order,
+// DefaultMarkets unless set with WithMarkets.
+func (c *orderRules) Markets() map[Coin]Market {
+	return c.markets
+}
+
+// WithMarkets replaces DefaultMarkets as the rules of each digital coin
+// used to validate the orders before sending them. Pairs without rules
+// are not validated, so WithMarkets(nil) disables the validation.
+func WithMarkets(m map[Coin]Market) Option {
+	return func(c *Client) { c.markets = m }
+}
+
+// WithRounding makes the client round the quantity down to the market
+// precision and the limit price to the tick, down on buys and up on
+// sells, instead of failing.
+func WithRounding() Option {
+	return func(c *Client) { c.round = true }
+}
+
+// Normalizer is implemented by Client and ClientV4, so the decorators
+// of an API can see the params actually sent for an order.
+type Normalizer interface {
+	// NormalizeOrder returns the qt, limit and cost that are sent for an
+	// order of the pair c1 and c2, rounded with WithRounding, or the
+	// *ValidationError the order fails with. Empty params are returned
+	// unchanged.
+	NormalizeOrder(c1, c2 Coin, buy bool, qt, limit, cost string) (string, string, string, error)
+}
+
+// NormalizeOrder implements Normalizer.
+func (c *orderRules) NormalizeOrder(c1, c2 Coin, buy bool, qt, limit, cost string) (string, string, string, error) {
+	return c.checkOrder(c1, c2, buy, qt, limit, cost)
+}
+
+// checkOrder validates and, if rounding is enabled, rounds and formats
+// the params of an order to the market precision. Without rounding the
+// params are sent as given. Empty qt, limit or cost are not checked.
+func (c *orderRules) checkOrder(c1, c2 Coin, buy bool, qt, limit, cost string) (string, string, string, error) {
+	m, ok := c.markets[c2]
+	if !ok {
+		return qt, limit, cost, nil
+	}
+	pair := c1.String() + c2.String()
+	bad := func(param, value string, err error) error {
+		return &ValidationError{Pair: pair, Param: param, Value: value, Err: err}
+	}
+	var q, p *big.Rat
+	var err error
+	if qt != "" {
+		if q, err = parsePositive(qt); err != nil {
+			return "", "", "", bad("quantity", qt, err)
+		}
+		if m.QuantityDecimals > 0 {
+			r, err := c.roundTo(q, pow10(m.QuantityDecimals), false)
+			if err != nil {
+				return "", "", "", bad("quantity", qt, ErrPrecision)
+			}
+			if c.round {
+				q, qt = r, dec.Format(r, m.QuantityDecimals)
+			}
+		}
+		if err := checkMin(q, m.MinQuantity, ErrMinQuantity); err != nil {
+			return "", "", "", bad("quantity", qt, err)
+		}
+	}
+	if limit != "" {
+		if p, err = parsePositive(limit); err != nil {
+			return "", "", "", bad("limit_price", limit, err)
+		}
+		if m.PriceTick != "" {
+			tick, err := dec.Parse(m.PriceTick)
+			if err != nil || tick.Sign() <= 0 {
+				return "", "", "", fmt.Errorf("invalid price tick %q of %s", m.PriceTick, pair)
+			}
+			r, err := c.roundTo(p, tick, !buy)
+			if err != nil {
+				return "", "", "", bad("limit_price", limit, ErrTickSize)
+			}
+			if c.round {
+				p, limit = r, dec.Format(r, decimals(m.PriceTick))
+			}
+		}
+		if q != nil {
+			if err := checkMin(dec.Mul(q, p), m.MinValue, ErrMinValue); err != nil {
+				return "", "", "", bad("limit_price", limit, err)
+			}
+		}
+	}
+	if cost != "" {
+		v, err := parsePositive(cost)
+		if err != nil {
+			return "", "", "", bad("cost", cost, err)
+		}
+		if m.CostDecimals > 0 {
+			r, err := c.roundTo(v, pow10(m.CostDecimals), false)
+			if err != nil {
+				return "", "", "", bad("cost", cost, ErrPrecision)
+			}
+			if c.round {
+				v, cost = r, dec.Format(r, m.CostDecimals)
+			}
+		}
+		if err := checkMin(v, m.MinValue, ErrMinValue); err != nil {
+			return "", "", "", bad("cost", cost, err)
+		}
+	}
+	return qt, limit, cost, nil
+}
+
+// parsePositive parses a positive plain decimal, such as "0.5". The
+// fractions and exponents accepted by big.Rat, such as "1/3" and "1e3",
+// are not sent by the API and are rejected.
+func parsePositive(s string) (*big.Rat, error) {
+	if !isDecimal(s) {
+		return nil, ErrInvalidNumber
+	}
+	x, ok := new(big.Rat).SetString(s)
+	if !ok || x.Sign() <= 0 {
+		return nil, ErrInvalidNumber
+	}
+	return x, nil
+}
+
+// isDecimal reports whether s is digits with an optional fraction.
+func isDecimal(s string) bool {
+	digits, dot := 0, false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c >= '0' && c <= '9':
+			digits++
+		case c == '.' && !dot && digits > 0 && i < len(s)-1:
+			dot = true
+		default:
+			return false
+		}
+	}
+	return digits > 0
+}
+
+// checkMin returns reason if x is below min.
+func checkMin(x *big.Rat, min string, reason error) error {
+	if min == "" {
+		return nil
+	}
+	m, err := dec.Parse(min)
+	if err != nil {
+		return err
+	}
+	if x.Cmp(m) < 0 {
+		return fmt.Errorf("%w %s", reason, min)
+	}
+	return nil
+}
+
+// roundTo returns x if it is a multiple of step. Otherwise it fails or,
+// with rounding enabled, rounds x down or up to a multiple of step. It
+// fails if x rounds down to zero.
+func (c *orderRules) roundTo(x, step *big.Rat, up bool) (*big.Rat, error) {
+	n := dec.Quo(x, step)
+	if n.IsInt() {
+		return x, nil
+	}
+	if !c.round {
+		return nil, errors.New("not a multiple")
+	}
+	i := new(big.Int).Quo(n.Num(), n.Denom())
+	if up {
+		i.Add(i, big.NewInt(1))
+	}
+	if i.Sign() == 0 {
+		return nil, errors.New("rounds to zero")
+	}
+	return dec.Mul(new(big.Rat).SetInt(i), step), nil
+}
+
+func pow10(n int) *big.Rat {
+	return new(big.Rat).SetFrac(big.NewInt(1), new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil))
+}
+
+// decimals returns the number of decimals of the decimal string s.
+func decimals(s string) int {
+	i := strings.IndexByte(s, '.')
+	if i < 0 {
+		return 0
+	}
+	return len(s) - i - 1
+}
@@ -0,0 +1,117 @@
+package tapi
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOrderValidation(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+	markets := map[Coin]Market{
+		BTC: {PriceTick: "0.01", QuantityDecimals: 4, CostDecimals: 2,
+			MinQuantity: "0.001", MinValue: "10"},
+	}
+	c := NewClient(srv.URL, fakeID, fakeKey, nil, WithMarkets(markets))
+	tests := []struct {
+		method string
+		qt     string
+		limit  string
+		err    error
+	}{
+		{"buy", "0.00015", "50000", ErrPrecision},
+		{"buy", "0.0001", "50000", ErrMinQuantity},
+		{"buy", "0.001", "5000", ErrMinValue},
+		{"buy", "0.001", "50000.001", ErrTickSize},
+		{"sell", "abc", "50000", ErrInvalidNumber},
+		{"sell", "-1", "50000", ErrInvalidNumber},
+		{"sell", "1/3", "50000", ErrInvalidNumber},
+		{"sell", "1e3", "50000", ErrInvalidNumber},
+		{"sell", ".5", "50000", ErrInvalidNumber},
+		{"sell", "1", "5e4", ErrInvalidNumber},
+		{"marketbuy", "", "9.99", ErrMinValue},
+		{"marketbuy", "", "10.001", ErrPrecision},
+		{"marketsell", "0.00011", "", ErrPrecision},
+	}
+	for _, tt := range tests {
+		var err error
+		switch tt.method {
+		case "buy":
+			_, err = c.PlaceBuyOrder(BRL, BTC, tt.qt, tt.limit)
+		case "sell":
+			_, err = c.PlaceSellOrder(BRL, BTC, tt.qt, tt.limit)
+		case "marketbuy":
+			_, err = c.PlaceMarketBuyOrder(BRL, BTC, tt.limit)
+		case "marketsell":
+			_, err = c.PlaceMarketSellOrder(BRL, BTC, tt.qt)
+		}
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s %s %s: got error %v, expected %v", tt.method, tt.qt, tt.limit, err, tt.err)
+		}
+		var verr *ValidationError
+		if !errors.As(err, &verr) {
+			t.Errorf("%s %s %s: got error %T, expected *ValidationError", tt.method, tt.qt, tt.limit, err)
+		}
+	}
+
+	// The clients validate with DefaultMarkets unless WithMarkets is set.
+	c = NewClient(srv.URL, fakeID, fakeKey, nil)
+	if _, err := c.PlaceBuyOrder(BRL, BTC, "0.0001", "50000"); !errors.Is(err, ErrMinQuantity) {
+		t.Errorf("got error %v, expected %v", err, ErrMinQuantity)
+	}
+	v4 := NewClientV4(srv.URL, NewStaticProvider(fakeID, fakeKey), "", nil)
+	if _, err := v4.PlaceSellOrder(BRL, BTC, "0.000000001", "50000"); !errors.Is(err, ErrPrecision) {
+		t.Errorf("got error %v, expected %v", err, ErrPrecision)
+	}
+	if calls != 0 {
+		t.Errorf("got %d requests, expected 0", calls)
+	}
+}
+
+func TestOrderRounding(t *testing.T) {
+	markets := map[Coin]Market{BTC: {PriceTick: "0.05", QuantityDecimals: 4, CostDecimals: 2}}
+	tests := []struct {
+		method string
+		qt     string
+		limit  string
+		strs   []string
+	}{
+		{"buy", "0.123456", "100.07", []string{"quantity", "0.1234", "limit_price", "100.05"}},
+		{"sell", "0.123456", "100.07", []string{"quantity", "0.1234", "limit_price", "100.10"}},
+		{"sell", "0.5", "100", []string{"quantity", "0.5000", "limit_price", "100.00"}},
+	}
+	for _, tt := range tests {
+		fn := tPlaceBuyOrder
+		if tt.method == "sell" {
+			fn = tPlaceSellOrder
+		}
+		srv := httptest.NewServer(handler(fn, jsonGetOrder, tt.strs...))
+		c := NewClient(srv.URL, fakeID, fakeKey, nil, WithMarkets(markets), WithRounding())
+		var err error
+		if tt.method == "buy" {
+			_, err = c.PlaceBuyOrder(BRL, BTC, tt.qt, tt.limit)
+		} else {
+			_, err = c.PlaceSellOrder(BRL, BTC, tt.qt, tt.limit)
+		}
+		if err != nil {
+			t.Errorf("%s %s %s: %v", tt.method, tt.qt, tt.limit, err)
+		}
+		srv.Close()
+	}
+
+	srv := httptest.NewServer(handler(tPlaceMarketBuyOrder, jsonGetOrder, "cost", "10.99"))
+	defer srv.Close()
+	c := NewClient(srv.URL, fakeID, fakeKey, nil, WithMarkets(markets), WithRounding())
+	if _, err := c.PlaceMarketBuyOrder(BRL, BTC, "10.999"); err != nil {
+		t.Error(err)
+	}
+	if _, err := c.PlaceMarketBuyOrder(BRL, BTC, "0.001"); !errors.Is(err, ErrPrecision) {
+		t.Errorf("got error %v, expected %v", err, ErrPrecision)
+	}
+}
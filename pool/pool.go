@@ -0,0 +1,270 @@
+// Package pool manages several accounts of the exchange.
+//
+// Each account is a tapi.API, usually a tapi.Client with its own
+// credentials and tapi.RateLimiter, so the accounts never share nonces
+// or request budget.
+package pool
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+
+	tapi "github.com/rschio/mb-tapi"
+	"github.com/rschio/mb-tapi/internal/dec"
+)
+
+var (
+	// ErrUnknownAccount is returned when there is no account with the
+	// name.
+	ErrUnknownAccount = errors.New("pool: unknown account")
+
+	// ErrInsufficientBalance is returned when no account has enough
+	// available balance for an order.
+	ErrInsufficientBalance = errors.New("pool: no account with enough balance")
+)
+
+// Pool is a set of accounts keyed by name. It is safe for concurrent
+// use.
+type Pool struct {
+	mu       sync.RWMutex
+	accounts map[string]tapi.API
+}
+
+// New creates an empty Pool.
+func New() *Pool {
+	return &Pool{accounts: make(map[string]tapi.API)}
+}
+
+// Add adds the account name.
+func (p *Pool) Add(name string, api tapi.API) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.accounts[name]; ok {
+		return fmt.Errorf("pool: account %q already exists", name)
+	}
+	p.accounts[name] = api
+	return nil
+}
+
+// Remove removes the account name.
+func (p *Pool) Remove(name string) {
+	p.mu.Lock()
+	delete(p.accounts, name)
+	p.mu.Unlock()
+}
+
+// Account returns the account name.
+func (p *Pool) Account(name string) (tapi.API, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	api, ok := p.accounts[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownAccount, name)
+	}
+	return api, nil
+}
+
+// Names returns the sorted names of the accounts.
+func (p *Pool) Names() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	names := make([]string, 0, len(p.accounts))
+	for n := range p.accounts {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// each calls fn concurrently for every account and returns the error of
+// the first account, by name, that failed.
+func (p *Pool) each(fn func(name string, api tapi.API) error) error {
+	names := p.Names()
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, n := range names {
+		api, err := p.Account(n)
+		if err != nil {
+			// Removed after Names.
+			continue
+		}
+		wg.Add(1)
+		go func(i int, n string, api tapi.API) {
+			defer wg.Done()
+			errs[i] = fn(n, api)
+		}(i, n, api)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("pool: account %q: %w", names[i], err)
+		}
+	}
+	return nil
+}
+
+// Balance is the balance of a coin.
+type Balance struct {
+	Available *big.Rat
+	Total     *big.Rat
+}
+
+// Balances is the result of GetAccountInfo of all accounts.
+type Balances struct {
+	// Accounts is the AccountInfo of each account.
+	Accounts map[string]*tapi.AccountInfo
+
+	// Total is the sum of the balances of all accounts.
+	Total map[tapi.Coin]Balance
+}
+
+// Balances returns the consolidated balances of all accounts.
+func (p *Pool) Balances() (*Balances, error) {
+	var mu sync.Mutex
+	b := &Balances{
+		Accounts: make(map[string]*tapi.AccountInfo),
+		Total:    make(map[tapi.Coin]Balance),
+	}
+	err := p.each(func(name string, api tapi.API) error {
+		info, err := api.GetAccountInfo()
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		b.Accounts[name] = info
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range tapi.Coins {
+		total := Balance{Available: dec.Zero(), Total: dec.Zero()}
+		for name, info := range b.Accounts {
+			amt := info.BalanceOf(c)
+			av, err := dec.Parse(amt.Available)
+			if err != nil {
+				return nil, fmt.Errorf("pool: account %q: %v", name, err)
+			}
+			tot, err := dec.Parse(amt.Total)
+			if err != nil {
+				return nil, fmt.Errorf("pool: account %q: %v", name, err)
+			}
+			total.Available.Add(total.Available, av)
+			total.Total.Add(total.Total, tot)
+		}
+		b.Total[c] = total
+	}
+	return b, nil
+}
+
+// AccountOrder is an order of an account.
+type AccountOrder struct {
+	Account string
+	tapi.Order
+}
+
+// OpenOrders returns the open orders of the pair c1 and c2 of all
+// accounts, sorted by account and order ID.
+func (p *Pool) OpenOrders(c1, c2 tapi.Coin) ([]AccountOrder, error) {
+	var mu sync.Mutex
+	var orders []AccountOrder
+	opts := tapi.ListOrdersOpts{StatusList: [3]int{1, 0, 0}}
+	err := p.each(func(name string, api tapi.API) error {
+		return tapi.WalkOrders(api, c1, c2, &opts, func(list []tapi.Order) error {
+			mu.Lock()
+			for _, o := range list {
+				orders = append(orders, AccountOrder{Account: name, Order: o})
+			}
+			mu.Unlock()
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		if orders[i].Account != orders[j].Account {
+			return orders[i].Account < orders[j].Account
+		}
+		return orders[i].ID < orders[j].ID
+	})
+	return orders, nil
+}
+
+// Route returns the account with the most available balance to place
+// an order of the pair c1 and c2: BRL qt*limit on buys or qt of the
+// digital coin on sells.
+func (p *Pool) Route(c1, c2 tapi.Coin, buy bool, qt, limit string) (string, error) {
+	need, err := dec.Parse(qt)
+	if err != nil {
+		return "", err
+	}
+	coin := c2
+	if buy {
+		price, err := dec.Parse(limit)
+		if err != nil {
+			return "", err
+		}
+		need = dec.Mul(need, price)
+		coin = c1
+	}
+	b, err := p.Balances()
+	if err != nil {
+		return "", err
+	}
+	best, bestAv := "", dec.Zero()
+	for _, name := range p.Names() {
+		info, ok := b.Accounts[name]
+		if !ok {
+			continue
+		}
+		av, err := dec.Parse(info.BalanceOf(coin).Available)
+		if err != nil {
+			return "", err
+		}
+		if av.Cmp(need) >= 0 && (best == "" || av.Cmp(bestAv) > 0) {
+			best, bestAv = name, av
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("%w: need %s %v", ErrInsufficientBalance, dec.Format(need, 8), coin)
+	}
+	return best, nil
+}
+
+// PlaceBuyOrder places a buy order in the account chosen by Route and
+// returns its name.
+func (p *Pool) PlaceBuyOrder(c1, c2 tapi.Coin, qt, limit string) (string, *tapi.Order, error) {
+	return p.place(c1, c2, true, qt, limit)
+}
+
+// PlaceSellOrder places a sell order in the account chosen by Route and
+// returns its name.
+func (p *Pool) PlaceSellOrder(c1, c2 tapi.Coin, qt, limit string) (string, *tapi.Order, error) {
+	return p.place(c1, c2, false, qt, limit)
+}
+
+func (p *Pool) place(c1, c2 tapi.Coin, buy bool, qt, limit string) (string, *tapi.Order, error) {
+	name, err := p.Route(c1, c2, buy, qt, limit)
+	if err != nil {
+		return "", nil, err
+	}
+	api, err := p.Account(name)
+	if err != nil {
+		return "", nil, err
+	}
+	var o *tapi.Order
+	if buy {
+		o, err = api.PlaceBuyOrder(c1, c2, qt, limit)
+	} else {
+		o, err = api.PlaceSellOrder(c1, c2, qt, limit)
+	}
+	if err != nil {
+		return name, nil, err
+	}
+	return name, o, nil
+}
@@ -0,0 +1,154 @@
+package pool
+
+import (
+	"errors"
+	"sort"
+	"testing"
+
+	tapi "github.com/rschio/mb-tapi"
+)
+
+type fakeAPI struct {
+	tapi.API
+	brl, btc string
+	orders   []tapi.Order
+	placed   int
+}
+
+func (f *fakeAPI) GetAccountInfo() (*tapi.AccountInfo, error) {
+	info := &tapi.AccountInfo{}
+	info.Balance.BRL = tapi.Amount{Available: f.brl, Total: f.brl}
+	info.Balance.BTC.Amount = tapi.Amount{Available: f.btc, Total: "10"}
+	return info, nil
+}
+
+// ListOrders returns pages of up to 200 orders, newest first, like the
+// exchange.
+func (f *fakeAPI) ListOrders(c1, c2 tapi.Coin, opts *tapi.ListOrdersOpts) ([]tapi.Order, error) {
+	var page []tapi.Order
+	for _, o := range f.orders {
+		if opts.ToID == 0 || o.ID <= opts.ToID {
+			page = append(page, o)
+		}
+	}
+	sort.Slice(page, func(i, j int) bool { return page[i].ID > page[j].ID })
+	if len(page) > 200 {
+		page = page[:200]
+	}
+	return page, nil
+}
+
+func (f *fakeAPI) PlaceBuyOrder(c1, c2 tapi.Coin, qt, limit string) (*tapi.Order, error) {
+	f.placed++
+	return &tapi.Order{ID: 1}, nil
+}
+
+func (f *fakeAPI) PlaceSellOrder(c1, c2 tapi.Coin, qt, limit string) (*tapi.Order, error) {
+	f.placed++
+	return &tapi.Order{ID: 2}, nil
+}
+
+func newTestPool(t *testing.T) (*Pool, *fakeAPI, *fakeAPI) {
+	a := &fakeAPI{brl: "100.00", btc: "2", orders: []tapi.Order{{ID: 5}, {ID: 3}}}
+	b := &fakeAPI{brl: "900.00", btc: "0.5", orders: []tapi.Order{{ID: 4}}}
+	p := New()
+	if err := p.Add("a", a); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Add("b", b); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Add("a", b); err == nil {
+		t.Error("expected error adding a duplicated account")
+	}
+	return p, a, b
+}
+
+func TestBalances(t *testing.T) {
+	p, _, _ := newTestPool(t)
+	b, err := p.Balances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := b.Total[tapi.BRL].Available.FloatString(2); got != "1000.00" {
+		t.Errorf("got BRL %s, expected %s", got, "1000.00")
+	}
+	if got := b.Total[tapi.BTC].Available.FloatString(1); got != "2.5" {
+		t.Errorf("got BTC available %s, expected %s", got, "2.5")
+	}
+	if got := b.Total[tapi.BTC].Total.FloatString(0); got != "20" {
+		t.Errorf("got BTC total %s, expected %s", got, "20")
+	}
+	if len(b.Accounts) != 2 {
+		t.Errorf("got %d accounts, expected %d", len(b.Accounts), 2)
+	}
+}
+
+func TestOpenOrders(t *testing.T) {
+	p, a, _ := newTestPool(t)
+	orders, err := p.OpenOrders(tapi.BRL, tapi.BTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []AccountOrder{
+		{"a", tapi.Order{ID: 3}}, {"a", tapi.Order{ID: 5}}, {"b", tapi.Order{ID: 4}},
+	}
+	if len(orders) != len(expected) {
+		t.Fatalf("got %d orders, expected %d", len(orders), len(expected))
+	}
+	for i := range orders {
+		if orders[i].Account != expected[i].Account || orders[i].ID != expected[i].ID {
+			t.Errorf("got %s/%d, expected %s/%d", orders[i].Account, orders[i].ID,
+				expected[i].Account, expected[i].ID)
+		}
+	}
+
+	// More open orders than a page are all listed.
+	a.orders = nil
+	for id := 1; id <= 250; id++ {
+		a.orders = append(a.orders, tapi.Order{ID: id})
+	}
+	if orders, err = p.OpenOrders(tapi.BRL, tapi.BTC); err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 251 {
+		t.Errorf("got %d orders, expected 251", len(orders))
+	}
+}
+
+func TestRoute(t *testing.T) {
+	p, a, b := newTestPool(t)
+	tests := []struct {
+		buy       bool
+		qt, limit string
+		account   string
+		err       error
+	}{
+		{true, "0.001", "50000", "b", nil},
+		{true, "0.1", "50000", "", ErrInsufficientBalance},
+		{false, "0.4", "50000", "a", nil},
+		{false, "1.5", "50000", "a", nil},
+		{false, "3", "50000", "", ErrInsufficientBalance},
+	}
+	for _, tt := range tests {
+		var name string
+		var err error
+		if tt.buy {
+			name, _, err = p.PlaceBuyOrder(tapi.BRL, tapi.BTC, tt.qt, tt.limit)
+		} else {
+			name, _, err = p.PlaceSellOrder(tapi.BRL, tapi.BTC, tt.qt, tt.limit)
+		}
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%v %s: got error %v, expected %v", tt.buy, tt.qt, err, tt.err)
+		}
+		if name != tt.account {
+			t.Errorf("%v %s: got account %q, expected %q", tt.buy, tt.qt, name, tt.account)
+		}
+	}
+	if a.placed != 2 || b.placed != 1 {
+		t.Errorf("got %d and %d orders, expected 2 and 1", a.placed, b.placed)
+	}
+	if _, err := p.Account("c"); !errors.Is(err, ErrUnknownAccount) {
+		t.Errorf("got error %v, expected %v", err, ErrUnknownAccount)
+	}
+}
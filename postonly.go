@@ -0,0 +1,178 @@
+package tapi
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rschio/mb-tapi/internal/dec"
+)
+
+// ErrWouldTake is returned by PlacePostOnly when the order would match
+// the best price of the other side of the book.
+var ErrWouldTake = errors.New("post-only: order would take liquidity")
+
+// Fee rates, in percent, of the base tier of the exchange. They are the
+// rates of fee.DefaultSchedule.
+const (
+	DefaultMakerFeeRate = "0.30"
+	DefaultTakerFeeRate = "0.70"
+)
+
+// PostOnly configures PlacePostOnly.
+type PostOnly struct {
+	// Reprice moves a limit price that would take liquidity to one
+	// tick from the best price of the other side, instead of failing.
+	Reprice bool
+
+	// Tick is the price tick used to reprice. Empty means the tick of
+	// DefaultMarkets.
+	Tick string
+
+	// MakerFeeRate is the fee rate of the maker executions. Empty
+	// means DefaultMakerFeeRate.
+	MakerFeeRate string
+}
+
+// Liquidity is how an order executed.
+type Liquidity int
+
+const (
+	// Resting is an order without executions.
+	Resting Liquidity = iota
+
+	// Maker is an order whose executions all have the maker fee rate.
+	Maker
+
+	// Taker is an order with an execution above the maker fee rate,
+	// so it took liquidity even with the check of the book.
+	Taker
+)
+
+func (l Liquidity) String() string {
+	switch l {
+	case Resting:
+		return "resting"
+	case Maker:
+		return "maker"
+	case Taker:
+		return "taker"
+	}
+	return fmt.Sprintf("Liquidity(%d)", int(l))
+}
+
+// PostOnlyResult is the result of PlacePostOnly.
+type PostOnlyResult struct {
+	Order *Order
+
+	// LimitPrice is the limit price sent, which differs from the
+	// requested one if Repriced.
+	LimitPrice string
+	Repriced   bool
+
+	Liquidity Liquidity
+}
+
+// PlacePostOnly places a post-only limit order with c. See the function
+// PlacePostOnly.
+func (c *Client) PlacePostOnly(c1, c2 Coin, buy bool, qt, limit string, p *PostOnly) (*PostOnlyResult, error) {
+	o := PostOnly{}
+	if p != nil {
+		o = *p
+	}
+	if m, ok := c.markets[c2]; ok && o.Tick == "" {
+		o.Tick = m.PriceTick
+	}
+	return PlacePostOnly(c, c1, c2, buy, qt, limit, &o)
+}
+
+// PlacePostOnly places a limit order only if it rests in the book. A buy
+// at or above the best ask, or a sell at or below the best bid, fails
+// with ErrWouldTake, or is repriced if p.Reprice is set. Use p = nil for
+// the defaults.
+//
+// The book may change between the check and the placement, so the fee
+// rates of the executions of the placed order are checked and reported
+// as its Liquidity.
+func PlacePostOnly(api API, c1, c2 Coin, buy bool, qt, limit string, p *PostOnly) (*PostOnlyResult, error) {
+	o := PostOnly{}
+	if p != nil {
+		o = *p
+	}
+	if o.Tick == "" {
+		o.Tick = DefaultMarkets[c2].PriceTick
+	}
+	if o.MakerFeeRate == "" {
+		o.MakerFeeRate = DefaultMakerFeeRate
+	}
+	l, err := dec.Parse(limit)
+	if err != nil {
+		return nil, err
+	}
+	ob, err := api.ListOrderbook(c1, c2, false)
+	if err != nil {
+		return nil, err
+	}
+	res := &PostOnlyResult{LimitPrice: limit}
+	best := ob.Asks
+	if !buy {
+		best = ob.Bids
+	}
+	if len(best) > 0 {
+		b, err := dec.Parse(best[0].LimitPrice)
+		if err != nil {
+			return nil, err
+		}
+		if (buy && l.Cmp(b) >= 0) || (!buy && l.Cmp(b) <= 0) {
+			if !o.Reprice {
+				return nil, fmt.Errorf("%w: limit %s, best %s", ErrWouldTake, limit, best[0].LimitPrice)
+			}
+			tick, err := dec.Parse(o.Tick)
+			if err != nil || tick.Sign() <= 0 {
+				return nil, fmt.Errorf("invalid price tick %q", o.Tick)
+			}
+			if buy {
+				l = dec.Sub(b, tick)
+			} else {
+				l = dec.Add(b, tick)
+			}
+			if l.Sign() <= 0 {
+				return nil, fmt.Errorf("%w: best %s", ErrWouldTake, best[0].LimitPrice)
+			}
+			res.LimitPrice = dec.Format(l, decimals(o.Tick))
+			res.Repriced = true
+		}
+	}
+
+	if buy {
+		res.Order, err = api.PlaceBuyOrder(c1, c2, qt, res.LimitPrice)
+	} else {
+		res.Order, err = api.PlaceSellOrder(c1, c2, qt, res.LimitPrice)
+	}
+	if err != nil {
+		return nil, err
+	}
+	res.Liquidity, err = liquidity(res.Order, o.MakerFeeRate)
+	if err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+func liquidity(o *Order, makerRate string) (Liquidity, error) {
+	maker, err := dec.Parse(makerRate)
+	if err != nil {
+		return Resting, err
+	}
+	l := Resting
+	for _, op := range o.Operations {
+		r, err := dec.Parse(op.FeeRate)
+		if err != nil {
+			return Resting, err
+		}
+		if r.Cmp(maker) > 0 {
+			return Taker, nil
+		}
+		l = Maker
+	}
+	return l, nil
+}
@@ -0,0 +1,76 @@
+package tapi
+
+import (
+	"errors"
+	"testing"
+)
+
+// postAPI has a book of bid 99 and ask 100, and places orders with the
+// executions ops.
+type postAPI struct {
+	API
+
+	ops    []Operation
+	placed []string
+}
+
+func (p *postAPI) ListOrderbook(c1, c2 Coin, full bool) (*Orderbook, error) {
+	return &Orderbook{
+		Bids: []OrderInfo{{Quantity: "1", LimitPrice: "99.00000"}},
+		Asks: []OrderInfo{{Quantity: "1", LimitPrice: "100.00000"}},
+	}, nil
+}
+
+func (p *postAPI) PlaceBuyOrder(c1, c2 Coin, qt, limit string) (*Order, error) {
+	p.placed = append(p.placed, "buy@"+limit)
+	return &Order{ID: 1, Operations: p.ops}, nil
+}
+
+func (p *postAPI) PlaceSellOrder(c1, c2 Coin, qt, limit string) (*Order, error) {
+	p.placed = append(p.placed, "sell@"+limit)
+	return &Order{ID: 1, Operations: p.ops}, nil
+}
+
+func TestPlacePostOnly(t *testing.T) {
+	tests := []struct {
+		name    string
+		buy     bool
+		limit   string
+		opts    *PostOnly
+		ops     []Operation
+		err     error
+		placed  string
+		liq     Liquidity
+		reprice bool
+	}{
+		{"buy below ask", true, "99.5", nil, nil, nil, "buy@99.5", Resting, false},
+		{"buy at ask", true, "100", nil, nil, ErrWouldTake, "", Resting, false},
+		{"sell at bid", false, "98", nil, nil, ErrWouldTake, "", Resting, false},
+		{"buy repriced", true, "101", &PostOnly{Reprice: true, Tick: "0.01"}, nil, nil, "buy@99.99", Resting, true},
+		{"sell repriced", false, "99", &PostOnly{Reprice: true}, nil, nil, "sell@99.00001", Resting, true},
+		{"maker fill", false, "100", nil, []Operation{{FeeRate: "0.30"}}, nil, "sell@100", Maker, false},
+		{"taker fill", false, "100", nil, []Operation{{FeeRate: "0.30"}, {FeeRate: "0.70"}}, nil, "sell@100", Taker, false},
+	}
+	for _, tt := range tests {
+		p := &postAPI{ops: tt.ops}
+		res, err := PlacePostOnly(p, BRL, BTC, tt.buy, "1", tt.limit, tt.opts)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: got error %v, expected %v", tt.name, err, tt.err)
+			continue
+		}
+		placed := ""
+		if len(p.placed) > 0 {
+			placed = p.placed[0]
+		}
+		if placed != tt.placed {
+			t.Errorf("%s: got placed %q, expected %q", tt.name, placed, tt.placed)
+		}
+		if err != nil {
+			continue
+		}
+		if res.Liquidity != tt.liq || res.Repriced != tt.reprice {
+			t.Errorf("%s: got %v repriced %v, expected %v repriced %v",
+				tt.name, res.Liquidity, res.Repriced, tt.liq, tt.reprice)
+		}
+	}
+}
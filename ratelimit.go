@@ -0,0 +1,61 @@
+package tapi
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket that lets n requests pass per period,
+// with bursts of up to n. It is safe for concurrent use.
+type RateLimiter struct {
+	mu     sync.Mutex
+	n      float64
+	per    time.Duration
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+	sleep  func(time.Duration)
+}
+
+// NewRateLimiter creates a RateLimiter of n requests per period. It
+// panics if n or per is not positive.
+func NewRateLimiter(n int, per time.Duration) *RateLimiter {
+	if n <= 0 || per <= 0 {
+		panic(fmt.Sprintf("tapi: non-positive rate %d per %v for NewRateLimiter", n, per))
+	}
+	return &RateLimiter{
+		n:      float64(n),
+		per:    per,
+		tokens: float64(n),
+		now:    time.Now,
+		sleep:  time.Sleep,
+	}
+}
+
+// Wait blocks until a request can be made.
+func (l *RateLimiter) Wait() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() / l.per.Seconds() * l.n
+		if l.tokens > l.n {
+			l.tokens = l.n
+		}
+	}
+	l.last = now
+	if l.tokens < 1 {
+		d := time.Duration((1 - l.tokens) / l.n * float64(l.per))
+		l.sleep(d)
+		l.last = l.last.Add(d)
+		l.tokens = 1
+	}
+	l.tokens--
+}
+
+// WithRateLimiter makes the client wait for l before each request.
+// Clients of different accounts should not share a RateLimiter.
+func WithRateLimiter(l *RateLimiter) Option {
+	return func(c *Client) { c.limiter = l }
+}
@@ -0,0 +1,71 @@
+package tapi
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	var slept time.Duration
+	l := NewRateLimiter(2, time.Second)
+	l.now = func() time.Time { return now }
+	l.sleep = func(d time.Duration) { slept += d; now = now.Add(d) }
+
+	l.Wait()
+	l.Wait()
+	if slept != 0 {
+		t.Fatalf("burst: got sleep %v, expected 0", slept)
+	}
+	l.Wait()
+	if slept != 500*time.Millisecond {
+		t.Fatalf("got sleep %v, expected %v", slept, 500*time.Millisecond)
+	}
+	now = now.Add(time.Second)
+	slept = 0
+	l.Wait()
+	l.Wait()
+	if slept != 0 {
+		t.Errorf("after refill: got sleep %v, expected 0", slept)
+	}
+}
+
+func TestNonceUnique(t *testing.T) {
+	c := NewClient(DefaultService, fakeID, fakeKey, nil)
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				n := c.Nonce()
+				mu.Lock()
+				if seen[n] {
+					t.Errorf("repeated nonce %s", n)
+				}
+				seen[n] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRateLimiterInvalid(t *testing.T) {
+	for _, tt := range []struct {
+		n   int
+		per time.Duration
+	}{{0, time.Second}, {-1, time.Second}, {1, 0}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%d per %v: expected panic", tt.n, tt.per)
+				}
+			}()
+			NewRateLimiter(tt.n, tt.per)
+		}()
+	}
+}
@@ -0,0 +1,696 @@
+// Package risk checks the orders of a tapi.API against pre-trade limits.
+//
+// An Engine checks every order placement against the limits of a
+// Config: the max quantity, notional and open orders of each pair, the
+// price band around the mid price of the orderbook, the max position of
+// each coin and a daily loss limit. A refused order fails with a
+// *LimitError. The config file is reloaded when it changes, so the
+// limits can be changed without a restart. The orders being placed
+// through the Engine count against the open orders and position limits
+// until their placement returns, so concurrent orders can not exceed
+// them together.
+//
+// The daily loss is the fall of the account value since the start of
+// the UTC day. The value of the start of the day is the last value seen
+// before the day boundary, or the first value of the day if none was
+// seen the day before, and it is saved in the StatePath of the Config,
+// so a restart keeps it. Call Snapshot periodically to keep it close to
+// the boundary when there are few orders. Deposits and withdrawals
+// change the value as profits and losses do: a withdrawal counts as a
+// loss and a deposit offsets losses, so call ResetBaseline after a
+// transfer to start the day again from the new value.
+package risk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	tapi "github.com/rschio/mb-tapi"
+	"github.com/rschio/mb-tapi/internal/dec"
+)
+
+// Reasons of a LimitError.
+var (
+	ErrMaxQuantity   = errors.New("risk: max order quantity exceeded")
+	ErrMaxNotional   = errors.New("risk: max order notional exceeded")
+	ErrMaxOpenOrders = errors.New("risk: max open orders reached")
+	ErrMaxPosition   = errors.New("risk: max position exceeded")
+	ErrPriceBand     = errors.New("risk: price outside band")
+	ErrDailyLoss     = errors.New("risk: daily loss limit reached")
+	ErrNoPrice       = errors.New("risk: no price in orderbook")
+)
+
+// LimitError is returned when an order violates a limit. Value is the
+// value of the order, or of the account, checked against Limit.
+type LimitError struct {
+	Pair  string
+	Value string
+	Limit string
+	Err   error
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%v: %s %s, limit %s", e.Err, e.Pair, e.Value, e.Limit)
+}
+
+func (e *LimitError) Unwrap() error { return e.Err }
+
+// PairLimits are the limits of a coin pair. Empty fields are not
+// checked.
+type PairLimits struct {
+	// MaxQuantity is the max quantity of digital coin of an order.
+	MaxQuantity string `json:"max_quantity"`
+
+	// MaxNotional is the max value in BRL of an order.
+	MaxNotional string `json:"max_notional"`
+
+	// MaxOpenOrders is the max number of open orders of the digital
+	// coin, as reported by the account info.
+	MaxOpenOrders int `json:"max_open_orders"`
+
+	// PriceBand is the max distance, in percent, of the price of an
+	// order from the mid price of the orderbook. The price of market
+	// orders is the best price of the other side.
+	PriceBand string `json:"price_band"`
+}
+
+// Config are the limits of an Engine.
+type Config struct {
+	// Pairs are the limits by coin pair, such as "BRLBTC".
+	Pairs map[string]PairLimits `json:"pairs"`
+
+	// MaxPosition is the max total balance of each coin, such as
+	// "BTC", plus the quantity not executed of its open buy orders,
+	// after a buy.
+	MaxPosition map[string]string `json:"max_position"`
+
+	// DailyLossLimit is the max loss in BRL of the account value in a
+	// UTC day. The account value is the BRL balance plus the balance
+	// of each coin at its mid price, so checking it reads the
+	// orderbook of each coin held.
+	DailyLossLimit string `json:"daily_loss_limit"`
+
+	// StatePath is the file where the value of the start of the day is
+	// saved. Empty means keep it only in memory.
+	StatePath string `json:"state_path"`
+}
+
+// LoadConfig reads the config in path.
+func LoadConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := json.Unmarshal(b, cfg); err != nil {
+		return nil, fmt.Errorf("risk: invalid config %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// Engine is a tapi.API that checks the limits before placing orders.
+// It is safe for concurrent use.
+type Engine struct {
+	tapi.API
+
+	path string
+	now  func() time.Time
+
+	mu      sync.Mutex
+	cfg     *Config
+	modTime time.Time
+	size    int64
+	state   *state
+
+	// The orders being placed are reserved, so the concurrent checks
+	// count them before the account info does.
+	clock    uint64
+	checks   int
+	reserved []*reservation
+}
+
+// reservation is an order from its check to the end of its placement.
+// A released reservation is still counted by the checks that read the
+// account info before the release, which may not include the order.
+type reservation struct {
+	coin tapi.Coin
+	buy  *big.Rat // quantity of a buy, nil on sells.
+	done uint64   // clock of the release, zero while placing.
+}
+
+// state is the account value of the start of the day and the last value
+// seen in the day, saved in Config.StatePath.
+type state struct {
+	path  string
+	Day   string `json:"day"`
+	Start string `json:"start"`
+	Last  string `json:"last"`
+}
+
+// New creates an Engine of api with the config in path, reloaded when
+// the file changes.
+func New(api tapi.API, path string) (*Engine, error) {
+	e := &Engine{API: api, path: path, now: time.Now}
+	if err := e.reload(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// NewWithConfig creates an Engine of api with cfg.
+func NewWithConfig(api tapi.API, cfg *Config) *Engine {
+	e := &Engine{API: api, now: time.Now}
+	e.SetConfig(cfg)
+	return e
+}
+
+// SetConfig replaces the limits. Use cfg = nil to remove them.
+func (e *Engine) SetConfig(cfg *Config) {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.cfg = cfg
+}
+
+// reload loads the config file if it changed. An invalid file fails
+// the orders until it is fixed, instead of keeping stale limits.
+func (e *Engine) reload() error {
+	if e.path == "" {
+		return nil
+	}
+	fi, err := os.Stat(e.path)
+	if err != nil {
+		return err
+	}
+	if e.cfg != nil && fi.ModTime().Equal(e.modTime) && fi.Size() == e.size {
+		return nil
+	}
+	cfg, err := LoadConfig(e.path)
+	if err != nil {
+		return err
+	}
+	e.cfg, e.modTime, e.size = cfg, fi.ModTime(), fi.Size()
+	return nil
+}
+
+// order is an order being checked. A nil limit is a market order, and a
+// non nil cost a market buy.
+type order struct {
+	buy   bool
+	qt    *big.Rat
+	limit *big.Rat
+	cost  *big.Rat
+}
+
+// config returns the current limits, reloading the file if it changed.
+// The Config is not changed after loaded, so it is used without e.mu.
+func (e *Engine) config() (*Config, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.reload(); err != nil {
+		return nil, err
+	}
+	return e.cfg, nil
+}
+
+// check checks o against the limits and reserves it. The returned
+// function releases the reservation and must be called when the
+// placement returns.
+func (e *Engine) check(c1, c2 tapi.Coin, o *order) (func(), error) {
+	start := e.beginCheck()
+	defer e.endCheck()
+	cfg, err := e.config()
+	if err != nil {
+		return nil, err
+	}
+	pair := c1.String() + c2.String()
+	lim := cfg.Pairs[pair]
+	limit := func(value *big.Rat, max string, reason error) error {
+		return &LimitError{Pair: pair, Value: dec.Format(value, 8), Limit: max, Err: reason}
+	}
+
+	var ob *tapi.Orderbook
+	price := o.limit
+	if price == nil || lim.PriceBand != "" {
+		if ob, err = e.API.ListOrderbook(c1, c2, false); err != nil {
+			return nil, err
+		}
+	}
+	if price == nil {
+		side := ob.Asks
+		if !o.buy {
+			side = ob.Bids
+		}
+		if len(side) == 0 {
+			return nil, &LimitError{Pair: pair, Err: ErrNoPrice}
+		}
+		p, err := dec.Parse(side[0].LimitPrice)
+		if err != nil {
+			return nil, err
+		}
+		price = p
+	}
+	qt, value := o.qt, o.cost
+	if o.cost != nil {
+		qt = dec.Quo(o.cost, price)
+	} else {
+		value = dec.Mul(o.qt, price)
+	}
+
+	if max, ok, err := parse(lim.MaxQuantity); err != nil {
+		return nil, err
+	} else if ok && qt.Cmp(max) > 0 {
+		return nil, limit(qt, lim.MaxQuantity, ErrMaxQuantity)
+	}
+	if max, ok, err := parse(lim.MaxNotional); err != nil {
+		return nil, err
+	} else if ok && value.Cmp(max) > 0 {
+		return nil, limit(value, lim.MaxNotional, ErrMaxNotional)
+	}
+	if band, ok, err := parse(lim.PriceBand); err != nil {
+		return nil, err
+	} else if ok {
+		mid, err := midPrice(ob)
+		if err != nil {
+			return nil, &LimitError{Pair: pair, Err: err}
+		}
+		d := new(big.Rat).Abs(dec.Sub(price, mid))
+		if d.Cmp(dec.Percent(mid, band)) > 0 {
+			return nil, &LimitError{Pair: pair, Value: dec.Format(price, 8),
+				Limit: lim.PriceBand + "% of " + dec.Format(mid, 8), Err: ErrPriceBand}
+		}
+	}
+
+	maxPos, posOK, err := parse(cfg.MaxPosition[c2.String()])
+	if err != nil {
+		return nil, err
+	}
+	var buy *big.Rat
+	if o.buy {
+		buy = qt
+	}
+	if lim.MaxOpenOrders == 0 && !(posOK && o.buy) && cfg.DailyLossLimit == "" {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		return e.reserve(c2, buy), nil
+	}
+	info, err := e.API.GetAccountInfo()
+	if err != nil {
+		return nil, err
+	}
+	var pos *big.Rat
+	if posOK && o.buy {
+		total, err := dec.Parse(info.BalanceOf(c2).Total)
+		if err != nil {
+			return nil, err
+		}
+		open, err := e.openBuys(c1, c2)
+		if err != nil {
+			return nil, err
+		}
+		pos = dec.Add(dec.Add(total, open), qt)
+	}
+	if cfg.DailyLossLimit != "" {
+		if err := e.checkLoss(cfg, c1, info); err != nil {
+			return nil, err
+		}
+	}
+
+	// The reservations are counted and o reserved under the lock, so
+	// two concurrent orders can not both pass a limit.
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	n, buys := e.pending(c2, start)
+	if lim.MaxOpenOrders > 0 {
+		if n += openOrders(info, c2); n >= lim.MaxOpenOrders {
+			return nil, &LimitError{Pair: pair, Value: fmt.Sprint(n),
+				Limit: fmt.Sprint(lim.MaxOpenOrders), Err: ErrMaxOpenOrders}
+		}
+	}
+	if pos != nil {
+		if pos = dec.Add(pos, buys); pos.Cmp(maxPos) > 0 {
+			return nil, limit(pos, cfg.MaxPosition[c2.String()], ErrMaxPosition)
+		}
+	}
+	return e.reserve(c2, buy), nil
+}
+
+// beginCheck starts a check and returns its clock.
+func (e *Engine) beginCheck() uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.clock++
+	e.checks++
+	return e.clock
+}
+
+// endCheck ends a check.
+func (e *Engine) endCheck() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.checks--
+	e.prune()
+}
+
+// prune drops the released reservations when no check can still count
+// them. It must be called with e.mu held.
+func (e *Engine) prune() {
+	if e.checks > 0 {
+		return
+	}
+	placing := e.reserved[:0]
+	for _, r := range e.reserved {
+		if r.done == 0 {
+			placing = append(placing, r)
+		}
+	}
+	e.reserved = placing
+}
+
+// pending returns the number of orders and the quantity of the buys of
+// coin reserved and not in the account info of a check started at
+// start. It must be called with e.mu held.
+func (e *Engine) pending(coin tapi.Coin, start uint64) (int, *big.Rat) {
+	n, buys := 0, dec.Zero()
+	for _, r := range e.reserved {
+		if r.coin != coin || (r.done != 0 && r.done < start) {
+			continue
+		}
+		n++
+		if r.buy != nil {
+			buys = dec.Add(buys, r.buy)
+		}
+	}
+	return n, buys
+}
+
+// reserve reserves an order of coin, a buy of quantity buy if not nil,
+// and returns the function that releases it. It must be called with e.mu
+// held.
+func (e *Engine) reserve(coin tapi.Coin, buy *big.Rat) func() {
+	r := &reservation{coin: coin, buy: buy}
+	e.reserved = append(e.reserved, r)
+	return func() {
+		e.mu.Lock()
+		e.clock++
+		r.done = e.clock
+		e.prune()
+		e.mu.Unlock()
+	}
+}
+
+// openBuys returns the quantity not executed of the open buy orders of
+// the pair c1 and c2.
+func (e *Engine) openBuys(c1, c2 tapi.Coin) (*big.Rat, error) {
+	sum := dec.Zero()
+	opts := &tapi.ListOrdersOpts{OrderType: 1, StatusList: [3]int{1, 0, 0}}
+	err := tapi.WalkOrders(e.API, c1, c2, opts, func(orders []tapi.Order) error {
+		for _, o := range orders {
+			if o.Type != tapi.OrderTypeBuy || o.Status != tapi.OrderStatusOpen {
+				continue
+			}
+			qt, err := dec.Parse(o.Quantity)
+			if err != nil {
+				return err
+			}
+			exec, err := dec.Parse(o.ExecutedQuantity)
+			if err != nil {
+				return err
+			}
+			if rem := dec.Sub(qt, exec); rem.Sign() > 0 {
+				sum = dec.Add(sum, rem)
+			}
+		}
+		return nil
+	})
+	return sum, err
+}
+
+// value returns the account value in c1: its balance plus the balance
+// of each coin at its mid price.
+func (e *Engine) value(c1 tapi.Coin, info *tapi.AccountInfo) (*big.Rat, error) {
+	value, err := dec.Parse(info.BalanceOf(c1).Total)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range tapi.Coins {
+		if c == c1 {
+			continue
+		}
+		total, err := dec.Parse(info.BalanceOf(c).Total)
+		if err != nil {
+			return nil, err
+		}
+		if total.Sign() == 0 {
+			continue
+		}
+		ob, err := e.API.ListOrderbook(c1, c, false)
+		if err != nil {
+			return nil, err
+		}
+		mid, err := midPrice(ob)
+		if err != nil {
+			return nil, fmt.Errorf("risk: value of %v: %w", c, err)
+		}
+		value = dec.Add(value, dec.Mul(total, mid))
+	}
+	return value, nil
+}
+
+// checkLoss compares the account value with the value at the start of
+// the day.
+func (e *Engine) checkLoss(cfg *Config, c1 tapi.Coin, info *tapi.AccountInfo) error {
+	max, err := dec.Parse(cfg.DailyLossLimit)
+	if err != nil {
+		return err
+	}
+	value, err := e.value(c1, info)
+	if err != nil {
+		return err
+	}
+	start, err := e.observe(cfg.StatePath, value)
+	if err != nil {
+		return err
+	}
+	if loss := dec.Sub(start, value); loss.Cmp(max) >= 0 {
+		return &LimitError{Pair: "account", Value: dec.Format(loss, 2),
+			Limit: cfg.DailyLossLimit, Err: ErrDailyLoss}
+	}
+	return nil
+}
+
+// observe records the account value and returns the value of the start
+// of the day.
+func (e *Engine) observe(path string, value *big.Rat) (*big.Rat, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.state == nil || e.state.path != path {
+		st, err := loadState(path)
+		if err != nil {
+			return nil, err
+		}
+		e.state = st
+	}
+	st := e.state
+	now := e.now().UTC()
+	day := now.Format("2006-01-02")
+	v := dec.Format(value, 8)
+	if st.Day != day {
+		start := v
+		if st.Day == now.AddDate(0, 0, -1).Format("2006-01-02") && st.Last != "" {
+			start = st.Last
+		}
+		st.Day, st.Start = day, start
+	}
+	if st.Start == "" {
+		st.Start = v
+	}
+	st.Last = v
+	if err := st.save(); err != nil {
+		return nil, err
+	}
+	return dec.Parse(st.Start)
+}
+
+// Snapshot records the account value, valued in BRL, so the next day
+// starts from a value close to the one at the day boundary. It does
+// nothing without a DailyLossLimit.
+func (e *Engine) Snapshot() error {
+	cfg, err := e.config()
+	if err != nil || cfg.DailyLossLimit == "" {
+		return err
+	}
+	info, err := e.API.GetAccountInfo()
+	if err != nil {
+		return err
+	}
+	value, err := e.value(tapi.BRL, info)
+	if err != nil {
+		return err
+	}
+	_, err = e.observe(cfg.StatePath, value)
+	return err
+}
+
+// ResetBaseline makes the next check start the day from the account
+// value of that moment, as after a deposit or a withdrawal.
+func (e *Engine) ResetBaseline() error {
+	cfg, err := e.config()
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	st, err := loadState(cfg.StatePath)
+	if err != nil {
+		return err
+	}
+	st.Day, st.Start, st.Last = "", "", ""
+	e.state = st
+	return st.save()
+}
+
+func loadState(path string) (*state, error) {
+	st := &state{path: path}
+	if path == "" {
+		return st, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, st); err != nil {
+		return nil, fmt.Errorf("risk: invalid state %s: %v", path, err)
+	}
+	return st, nil
+}
+
+func (st *state) save() error {
+	if st.path == "" {
+		return nil
+	}
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	tmp := st.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, st.path)
+}
+
+func midPrice(ob *tapi.Orderbook) (*big.Rat, error) {
+	if len(ob.Bids) == 0 || len(ob.Asks) == 0 {
+		return nil, ErrNoPrice
+	}
+	bid, err := dec.Parse(ob.Bids[0].LimitPrice)
+	if err != nil {
+		return nil, err
+	}
+	ask, err := dec.Parse(ob.Asks[0].LimitPrice)
+	if err != nil {
+		return nil, err
+	}
+	return dec.Quo(dec.Add(bid, ask), big.NewRat(2, 1)), nil
+}
+
+func openOrders(info *tapi.AccountInfo, c tapi.Coin) int {
+	switch c {
+	case tapi.BTC:
+		return info.Balance.BTC.OpenOrders
+	case tapi.LTC:
+		return info.Balance.LTC.OpenOrders
+	case tapi.BCH:
+		return info.Balance.BCH.OpenOrders
+	case tapi.XRP:
+		return info.Balance.XRP.OpenOrders
+	case tapi.ETH:
+		return info.Balance.ETH.OpenOrders
+	}
+	return 0
+}
+
+// parse parses an optional limit.
+func parse(s string) (*big.Rat, bool, error) {
+	if s == "" {
+		return nil, false, nil
+	}
+	x, err := dec.Parse(s)
+	if err != nil {
+		return nil, false, fmt.Errorf("risk: invalid limit %q: %v", s, err)
+	}
+	return x, true, nil
+}
+
+func (e *Engine) limitOrder(c1, c2 tapi.Coin, buy bool, qt, limit string) (func(), error) {
+	q, err := dec.Parse(qt)
+	if err != nil {
+		return nil, err
+	}
+	l, err := dec.Parse(limit)
+	if err != nil {
+		return nil, err
+	}
+	return e.check(c1, c2, &order{buy: buy, qt: q, limit: l})
+}
+
+// PlaceBuyOrder checks the limits and places a buy order.
+func (e *Engine) PlaceBuyOrder(c1, c2 tapi.Coin, qt, limit string) (*tapi.Order, error) {
+	release, err := e.limitOrder(c1, c2, true, qt, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return e.API.PlaceBuyOrder(c1, c2, qt, limit)
+}
+
+// PlaceSellOrder checks the limits and places a sell order.
+func (e *Engine) PlaceSellOrder(c1, c2 tapi.Coin, qt, limit string) (*tapi.Order, error) {
+	release, err := e.limitOrder(c1, c2, false, qt, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return e.API.PlaceSellOrder(c1, c2, qt, limit)
+}
+
+// PlaceMarketBuyOrder checks the limits and places a market buy order.
+func (e *Engine) PlaceMarketBuyOrder(c1, c2 tapi.Coin, cost string) (*tapi.Order, error) {
+	v, err := dec.Parse(cost)
+	if err != nil {
+		return nil, err
+	}
+	release, err := e.check(c1, c2, &order{buy: true, cost: v})
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return e.API.PlaceMarketBuyOrder(c1, c2, cost)
+}
+
+// PlaceMarketSellOrder checks the limits and places a market sell
+// order.
+func (e *Engine) PlaceMarketSellOrder(c1, c2 tapi.Coin, qt string) (*tapi.Order, error) {
+	q, err := dec.Parse(qt)
+	if err != nil {
+		return nil, err
+	}
+	release, err := e.check(c1, c2, &order{qt: q})
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return e.API.PlaceMarketSellOrder(c1, c2, qt)
+}
@@ -0,0 +1,282 @@
+package risk
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	tapi "github.com/rschio/mb-tapi"
+)
+
+// fakeAPI has a book of bid 99 and ask 101 in every pair, 1 BTC, 2 open
+// ETH orders, brl BRL and the open orders.
+type fakeAPI struct {
+	tapi.API
+
+	brl    string
+	open   []tapi.Order
+	placed int
+
+	// placing, if not nil, is sent to by PlaceBuyOrder, which then
+	// blocks until release is closed.
+	placing chan struct{}
+	release chan struct{}
+	mu      sync.Mutex
+}
+
+func (f *fakeAPI) ListOrders(c1, c2 tapi.Coin, opts *tapi.ListOrdersOpts) ([]tapi.Order, error) {
+	return f.open, nil
+}
+
+func (f *fakeAPI) ListOrderbook(c1, c2 tapi.Coin, full bool) (*tapi.Orderbook, error) {
+	return &tapi.Orderbook{
+		Bids: []tapi.OrderInfo{{Quantity: "1", LimitPrice: "99"}},
+		Asks: []tapi.OrderInfo{{Quantity: "1", LimitPrice: "101"}},
+	}, nil
+}
+
+func (f *fakeAPI) GetAccountInfo() (*tapi.AccountInfo, error) {
+	info := &tapi.AccountInfo{}
+	info.Balance.BRL = tapi.Amount{Available: f.brl, Total: f.brl}
+	info.Balance.BTC.Amount = tapi.Amount{Available: "1", Total: "1"}
+	info.Balance.ETH.OpenOrders = 2
+	return info, nil
+}
+
+func (f *fakeAPI) PlaceBuyOrder(c1, c2 tapi.Coin, qt, limit string) (*tapi.Order, error) {
+	if f.placing != nil {
+		f.placing <- struct{}{}
+		<-f.release
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.placed++
+	return &tapi.Order{ID: f.placed}, nil
+}
+
+func (f *fakeAPI) PlaceMarketBuyOrder(c1, c2 tapi.Coin, cost string) (*tapi.Order, error) {
+	f.placed++
+	return &tapi.Order{ID: f.placed}, nil
+}
+
+func (f *fakeAPI) PlaceMarketSellOrder(c1, c2 tapi.Coin, qt string) (*tapi.Order, error) {
+	f.placed++
+	return &tapi.Order{ID: f.placed}, nil
+}
+
+func TestConcurrentOrders(t *testing.T) {
+	cfg := &Config{
+		Pairs:       map[string]PairLimits{"BRLETH": {MaxOpenOrders: 3}},
+		MaxPosition: map[string]string{"BTC": "2.5"},
+	}
+	f := &fakeAPI{brl: "1000", placing: make(chan struct{}), release: make(chan struct{})}
+	e := NewWithConfig(f, cfg)
+	done := make(chan error, 2)
+	go func() {
+		_, err := e.PlaceBuyOrder(tapi.BRL, tapi.BTC, "1", "100")
+		done <- err
+	}()
+	<-f.placing
+	go func() {
+		_, err := e.PlaceBuyOrder(tapi.BRL, tapi.ETH, "1", "100")
+		done <- err
+	}()
+	<-f.placing
+
+	// The orders being placed count against the limits.
+	if _, err := e.PlaceBuyOrder(tapi.BRL, tapi.BTC, "1", "100"); !errors.Is(err, ErrMaxPosition) {
+		t.Errorf("got error %v, expected %v", err, ErrMaxPosition)
+	}
+	if _, err := e.PlaceMarketBuyOrder(tapi.BRL, tapi.ETH, "100"); !errors.Is(err, ErrMaxOpenOrders) {
+		t.Errorf("got error %v, expected %v", err, ErrMaxOpenOrders)
+	}
+	close(f.release)
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Once placed, the orders are left to the account info.
+	f.placing = nil
+	if _, err := e.PlaceBuyOrder(tapi.BRL, tapi.BTC, "1", "100"); err != nil {
+		t.Errorf("got error %v after the placements returned", err)
+	}
+	if len(e.reserved) != 0 {
+		t.Errorf("got %d reservations, expected 0", len(e.reserved))
+	}
+}
+
+func TestLimits(t *testing.T) {
+	cfg := &Config{
+		Pairs: map[string]PairLimits{
+			"BRLBTC": {MaxQuantity: "2", MaxNotional: "150", PriceBand: "5"},
+			"BRLETH": {MaxOpenOrders: 2},
+		},
+		MaxPosition: map[string]string{"BTC": "2.2"},
+	}
+	f := &fakeAPI{brl: "1000"}
+	e := NewWithConfig(f, cfg)
+	tests := []struct {
+		name  string
+		place func() error
+		err   error
+	}{
+		{"ok", func() error {
+			_, err := e.PlaceBuyOrder(tapi.BRL, tapi.BTC, "1", "100")
+			return err
+		}, nil},
+		{"quantity", func() error {
+			_, err := e.PlaceMarketSellOrder(tapi.BRL, tapi.BTC, "3")
+			return err
+		}, ErrMaxQuantity},
+		{"notional", func() error {
+			_, err := e.PlaceBuyOrder(tapi.BRL, tapi.BTC, "1.6", "100")
+			return err
+		}, ErrMaxNotional},
+		{"market notional", func() error {
+			_, err := e.PlaceMarketBuyOrder(tapi.BRL, tapi.BTC, "151")
+			return err
+		}, ErrMaxNotional},
+		{"band", func() error {
+			_, err := e.PlaceBuyOrder(tapi.BRL, tapi.BTC, "0.1", "106")
+			return err
+		}, ErrPriceBand},
+		{"position", func() error {
+			_, err := e.PlaceBuyOrder(tapi.BRL, tapi.BTC, "1.4", "100")
+			return err
+		}, ErrMaxPosition},
+		{"open orders", func() error {
+			_, err := e.PlaceBuyOrder(tapi.BRL, tapi.ETH, "1", "100")
+			return err
+		}, ErrMaxOpenOrders},
+	}
+	for _, tt := range tests {
+		err := tt.place()
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: got error %v, expected %v", tt.name, err, tt.err)
+		}
+		var lerr *LimitError
+		if tt.err != nil && !errors.As(err, &lerr) {
+			t.Errorf("%s: got error of type %T, expected *LimitError", tt.name, err)
+		}
+	}
+	if f.placed != 1 {
+		t.Errorf("got %d placed, expected 1", f.placed)
+	}
+
+	// The open buys count in the position.
+	f.open = []tapi.Order{{ID: 1, Type: tapi.OrderTypeBuy, Status: tapi.OrderStatusOpen,
+		Quantity: "0.5", ExecutedQuantity: "0.2"}}
+	if _, err := e.PlaceBuyOrder(tapi.BRL, tapi.BTC, "1", "100"); !errors.Is(err, ErrMaxPosition) {
+		t.Errorf("got error %v, expected %v", err, ErrMaxPosition)
+	}
+	if _, err := e.PlaceBuyOrder(tapi.BRL, tapi.BTC, "0.9", "100"); err != nil {
+		t.Errorf("got error %v, expected nil", err)
+	}
+}
+
+func TestDailyLoss(t *testing.T) {
+	f := &fakeAPI{brl: "1000"}
+	e := NewWithConfig(f, &Config{DailyLossLimit: "50"})
+	now := time.Date(2020, 9, 13, 10, 0, 0, 0, time.UTC)
+	e.now = func() time.Time { return now }
+
+	// The day starts with 1000 BRL and 1 BTC at mid 100.
+	if _, err := e.PlaceBuyOrder(tapi.BRL, tapi.BTC, "0.1", "100"); err != nil {
+		t.Fatal(err)
+	}
+	f.brl = "1050"
+	if _, err := e.PlaceBuyOrder(tapi.BRL, tapi.BTC, "0.1", "100"); err != nil {
+		t.Fatal(err)
+	}
+	f.brl = "950"
+	if _, err := e.PlaceBuyOrder(tapi.BRL, tapi.BTC, "0.1", "100"); !errors.Is(err, ErrDailyLoss) {
+		t.Errorf("got error %v, expected %v", err, ErrDailyLoss)
+	}
+	now = now.Add(24 * time.Hour)
+	if _, err := e.PlaceBuyOrder(tapi.BRL, tapi.BTC, "0.1", "100"); err != nil {
+		t.Errorf("got error %v on a new day, expected nil", err)
+	}
+}
+
+func TestDailyBaseline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "risk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := &Config{DailyLossLimit: "50", StatePath: filepath.Join(dir, "state.json")}
+	f := &fakeAPI{brl: "1000"}
+	now := time.Date(2020, 9, 13, 23, 50, 0, 0, time.UTC)
+	newEngine := func() *Engine {
+		e := NewWithConfig(f, cfg)
+		e.now = func() time.Time { return now }
+		return e
+	}
+
+	// The value seen before the boundary starts the next day, even
+	// after a restart.
+	e := newEngine()
+	if err := e.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(20 * time.Minute)
+	f.brl = "940"
+	e = newEngine()
+	if _, err := e.PlaceBuyOrder(tapi.BRL, tapi.BTC, "0.1", "100"); !errors.Is(err, ErrDailyLoss) {
+		t.Errorf("got error %v, expected %v", err, ErrDailyLoss)
+	}
+
+	// A withdrawal restarts the day from the new value.
+	if err := e.ResetBaseline(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.PlaceBuyOrder(tapi.BRL, tapi.BTC, "0.1", "100"); err != nil {
+		t.Errorf("got error %v after reset, expected nil", err)
+	}
+
+	// Without values of the day before, the day starts with the first.
+	now = now.Add(48 * time.Hour)
+	f.brl = "500"
+	if _, err := newEngine().PlaceBuyOrder(tapi.BRL, tapi.BTC, "0.1", "100"); err != nil {
+		t.Errorf("got error %v, expected nil", err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "risk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "risk.json")
+	write := func(s string, mod time.Time) {
+		if err := ioutil.WriteFile(path, []byte(s), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mod := time.Now()
+	write(`{"pairs": {"BRLBTC": {"max_quantity": "1"}}}`, mod)
+	e, err := New(&fakeAPI{}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.PlaceBuyOrder(tapi.BRL, tapi.BTC, "2", "100"); !errors.Is(err, ErrMaxQuantity) {
+		t.Errorf("got error %v, expected %v", err, ErrMaxQuantity)
+	}
+	write(`{"pairs": {"BRLBTC": {"max_quantity": "10"}}}`, mod.Add(time.Second))
+	if _, err := e.PlaceBuyOrder(tapi.BRL, tapi.BTC, "2", "100"); err != nil {
+		t.Errorf("got error %v after reload, expected nil", err)
+	}
+	write(`{"pairs": `, mod.Add(2*time.Second))
+	if _, err := e.PlaceBuyOrder(tapi.BRL, tapi.BTC, "2", "100"); err == nil {
+		t.Error("got nil error with an invalid config")
+	}
+}
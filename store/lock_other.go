@@ -0,0 +1,32 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package store
+
+import (
+	"os"
+	"time"
+)
+
+// staleLock is the age of a lock file left by a crashed process.
+const staleLock = time.Minute
+
+// lockFile takes an exclusive lock on path, waiting for the other
+// holders. The lock file is created exclusively and removed by unlock.
+func lockFile(path string) (unlock func() error, err error) {
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			f.Close()
+			return func() error { return os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if fi, err := os.Stat(path); err == nil && time.Since(fi.ModTime()) > staleLock {
+			os.Remove(path)
+			continue
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
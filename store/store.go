@@ -0,0 +1,385 @@
+// Package store keeps a local copy of the orders of one or more
+// accounts.
+//
+// A Store is a single file that mirrors every Order, with its
+// Operations, of the synced coin pairs. Sync fetches the orders created
+// since the last sync and the changes of the ones still open, so
+// strategies can query the order state locally without spending the
+// rate budget, and the copy survives restarts.
+//
+// Several processes can open the same file. The changes are loaded
+// when the file changes, and Sync holds a lock file while it merges and
+// saves, so concurrent syncs do not lose updates.
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	tapi "github.com/rschio/mb-tapi"
+)
+
+type pair struct {
+	// Orders by ID.
+	Orders map[int]*tapi.Order `json:"orders"`
+
+	// LastID is the greatest order ID synced.
+	LastID int `json:"last_id"`
+}
+
+// data is the content of the file, by account and coin pair.
+type data map[string]map[string]*pair
+
+// Store is a file-based store of orders. It is safe for concurrent use.
+type Store struct {
+	path string
+
+	mu      sync.Mutex
+	data    data
+	modTime time.Time
+	size    int64
+}
+
+// Open opens the store in path, creating it on the first sync.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, data: make(data)}
+	if err := s.reload(false); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reload loads the file if it was changed by another process, or always
+// if force is set.
+func (s *Store) reload(force bool) error {
+	fi, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !force && fi.ModTime().Equal(s.modTime) && fi.Size() == s.size {
+		return nil
+	}
+	b, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	d := make(data)
+	if err := json.Unmarshal(b, &d); err != nil {
+		return fmt.Errorf("store: invalid file %s: %v", s.path, err)
+	}
+	s.data, s.modTime, s.size = d, fi.ModTime(), fi.Size()
+	return nil
+}
+
+func (s *Store) save() error {
+	b, err := json.Marshal(s.data)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	s.modTime, s.size = fi.ModTime(), fi.Size()
+	return nil
+}
+
+func (s *Store) pair(account, p string) *pair {
+	pairs, ok := s.data[account]
+	if !ok {
+		pairs = make(map[string]*pair)
+		s.data[account] = pairs
+	}
+	ps, ok := pairs[p]
+	if !ok {
+		ps = &pair{Orders: make(map[int]*tapi.Order)}
+		pairs[p] = ps
+	}
+	return ps
+}
+
+// Sync updates the orders of coin pair c1 and c2 of account with api.
+// It lists the orders created since the last sync and gets each order
+// still open by ID, so its cost does not grow with the age of the
+// oldest open order. It returns the number of orders added or changed.
+//
+// The HasFills filter is not used: the store mirrors every order, also
+// the ones without fills, so the new orders are all listed, and the
+// fills of the known orders can only change while they are open, which
+// are read by ID with their operations.
+func (s *Store) Sync(account string, api tapi.API, c1, c2 tapi.Coin) (int, error) {
+	p := c1.String() + c2.String()
+	s.mu.Lock()
+	if err := s.reload(false); err != nil {
+		s.mu.Unlock()
+		return 0, err
+	}
+	ps := s.pair(account, p)
+	lastID := ps.LastID
+	var open []int
+	for id, o := range ps.Orders {
+		if o.Status == tapi.OrderStatusOpen {
+			open = append(open, id)
+		}
+	}
+	s.mu.Unlock()
+	sort.Ints(open)
+
+	// The requests are made without the lock, so queries are not
+	// blocked by the API.
+	var fetched []tapi.Order
+	collect := func(orders []tapi.Order) error {
+		fetched = append(fetched, orders...)
+		return nil
+	}
+	if err := tapi.WalkOrders(api, c1, c2, &tapi.ListOrdersOpts{FromID: lastID + 1}, collect); err != nil {
+		return 0, err
+	}
+	for _, id := range open {
+		o, err := api.GetOrder(c1, c2, id)
+		if err != nil {
+			return 0, fmt.Errorf("store: get order %d of %s: %v", id, p, err)
+		}
+		fetched = append(fetched, *o)
+	}
+
+	unlock, err := lockFile(s.path + ".lock")
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Another process may have saved since the last reload, with the
+	// same size and modification time.
+	if err := s.reload(true); err != nil {
+		return 0, err
+	}
+	ps = s.pair(account, p)
+	n := 0
+	for i := range fetched {
+		o := fetched[i]
+		if o.CoinPair == "" {
+			o.CoinPair = p
+		}
+		if old, ok := ps.Orders[o.ID]; ok && !changed(old, &o) {
+			continue
+		}
+		ps.Orders[o.ID] = &o
+		if o.ID > ps.LastID {
+			ps.LastID = o.ID
+		}
+		n++
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, s.save()
+}
+
+// changed reports whether o is a newer state of old. An older state,
+// fetched by a concurrent sync before old was saved, is not.
+func changed(old, o *tapi.Order) bool {
+	if old.Status != tapi.OrderStatusOpen && o.Status == tapi.OrderStatusOpen {
+		return false
+	}
+	if len(o.Operations) < len(old.Operations) {
+		return false
+	}
+	return old.Status != o.Status || old.UpdatedTimestamp != o.UpdatedTimestamp ||
+		len(old.Operations) != len(o.Operations)
+}
+
+// Job is a coin pair of an account synced by Run.
+type Job struct {
+	Account string
+	API     tapi.API
+	C1, C2  tapi.Coin
+}
+
+// Run syncs the jobs every interval until ctx is done. The errors of
+// Sync are passed to errf, if not nil, and do not stop Run.
+func (s *Store) Run(ctx context.Context, every time.Duration, errf func(Job, error), jobs ...Job) error {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		for _, j := range jobs {
+			if _, err := s.Sync(j.Account, j.API, j.C1, j.C2); err != nil && errf != nil {
+				errf(j, err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Query filters the orders of the store. Any field with zero value
+// means not set.
+type Query struct {
+	Account string
+
+	// Pair is the coin pair, such as "BRLBTC".
+	Pair string
+
+	// Statuses are the order statuses, such as tapi.OrderStatusOpen.
+	Statuses []int
+
+	// From and To filter by creation time, To exclusive.
+	From, To time.Time
+}
+
+func (q *Query) match(o *tapi.Order) bool {
+	if len(q.Statuses) > 0 {
+		ok := false
+		for _, st := range q.Statuses {
+			ok = ok || o.Status == st
+		}
+		if !ok {
+			return false
+		}
+	}
+	if q.From.IsZero() && q.To.IsZero() {
+		return true
+	}
+	t, err := tapi.ParseTimestamp(o.CreatedTimestamp)
+	if err != nil {
+		return false
+	}
+	return (q.From.IsZero() || !t.Before(q.From)) && (q.To.IsZero() || t.Before(q.To))
+}
+
+// AccountOrder is an order of an account.
+type AccountOrder struct {
+	Account string
+	tapi.Order
+}
+
+// Orders returns the orders matching q sorted by account, pair and ID.
+func (s *Store) Orders(q Query) ([]AccountOrder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(false); err != nil {
+		return nil, err
+	}
+	var res []AccountOrder
+	for account, pairs := range s.data {
+		if q.Account != "" && account != q.Account {
+			continue
+		}
+		for p, ps := range pairs {
+			if q.Pair != "" && p != q.Pair {
+				continue
+			}
+			for _, o := range ps.Orders {
+				if q.match(o) {
+					res = append(res, AccountOrder{Account: account, Order: *o})
+				}
+			}
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+		if a.Account != b.Account {
+			return a.Account < b.Account
+		}
+		if a.CoinPair != b.CoinPair {
+			return a.CoinPair < b.CoinPair
+		}
+		return a.ID < b.ID
+	})
+	return res, nil
+}
+
+// Order returns the order id of pair of account.
+func (s *Store) Order(account, pair string, id int) (*tapi.Order, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(false); err != nil {
+		return nil, false, err
+	}
+	ps, ok := s.data[account][pair]
+	if !ok {
+		return nil, false, nil
+	}
+	o, ok := ps.Orders[id]
+	if !ok {
+		return nil, false, nil
+	}
+	c := *o
+	return &c, true, nil
+}
+
+// Execution is an operation of an order.
+type Execution struct {
+	Account string
+	Pair    string
+	OrderID int
+	Type    int
+	tapi.Operation
+}
+
+// Executions returns the operations of the orders matching q, sorted by
+// execution time. The time range of q filters the executions, not the
+// orders.
+func (s *Store) Executions(q Query) ([]Execution, error) {
+	from, to := q.From, q.To
+	q.From, q.To = time.Time{}, time.Time{}
+	orders, err := s.Orders(q)
+	if err != nil {
+		return nil, err
+	}
+	var res []Execution
+	var times []int64
+	for _, o := range orders {
+		for _, op := range o.Operations {
+			t, err := tapi.ParseTimestamp(op.ExecutedTimestamp)
+			if err != nil {
+				return nil, fmt.Errorf("store: order %d: %v", o.ID, err)
+			}
+			if (!from.IsZero() && t.Before(from)) || (!to.IsZero() && !t.Before(to)) {
+				continue
+			}
+			res = append(res, Execution{
+				Account:   o.Account,
+				Pair:      o.CoinPair,
+				OrderID:   o.ID,
+				Type:      o.Type,
+				Operation: op,
+			})
+			times = append(times, t.Unix())
+		}
+	}
+	sort.Stable(byTime{res, times})
+	return res, nil
+}
+
+type byTime struct {
+	e []Execution
+	t []int64
+}
+
+func (b byTime) Len() int           { return len(b.e) }
+func (b byTime) Less(i, j int) bool { return b.t[i] < b.t[j] }
+func (b byTime) Swap(i, j int) {
+	b.e[i], b.e[j] = b.e[j], b.e[i]
+	b.t[i], b.t[j] = b.t[j], b.t[i]
+}
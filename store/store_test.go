@@ -0,0 +1,210 @@
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	tapi "github.com/rschio/mb-tapi"
+)
+
+// fakeAPI filters its orders by FromID, ToID, HasFills and the
+// cancelled status.
+type fakeAPI struct {
+	tapi.API
+
+	orders []tapi.Order
+	calls  []tapi.ListOrdersOpts
+	gets   []int
+}
+
+func (f *fakeAPI) GetOrder(c1, c2 tapi.Coin, id int) (*tapi.Order, error) {
+	f.gets = append(f.gets, id)
+	for _, o := range f.orders {
+		if o.ID == id {
+			return &o, nil
+		}
+	}
+	return nil, &tapi.Error{Code: 215}
+}
+
+func (f *fakeAPI) ListOrders(c1, c2 tapi.Coin, opts *tapi.ListOrdersOpts) ([]tapi.Order, error) {
+	f.calls = append(f.calls, *opts)
+	var res []tapi.Order
+	for _, o := range f.orders {
+		switch {
+		case o.ID < opts.FromID, opts.ToID != 0 && o.ID > opts.ToID,
+			opts.HasFills > 0 && !o.HasFills,
+			opts.StatusList[1] != 0 && o.Status != tapi.OrderStatusCancelled:
+			continue
+		}
+		res = append(res, o)
+	}
+	return res, nil
+}
+
+func order(id, status int, created string, ops ...tapi.Operation) tapi.Order {
+	return tapi.Order{
+		ID: id, Status: status, Type: tapi.OrderTypeBuy, HasFills: len(ops) > 0,
+		CreatedTimestamp: created, UpdatedTimestamp: created, Operations: ops,
+	}
+}
+
+func TestSync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "orders")
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeAPI{orders: []tapi.Order{
+		order(1, tapi.OrderStatusFilled, "1600000000", tapi.Operation{ID: 1, ExecutedTimestamp: "1600000010"}),
+		order(2, tapi.OrderStatusOpen, "1600000100"),
+		order(3, tapi.OrderStatusOpen, "1600000200"),
+	}}
+	n, err := s.Sync("main", f, tapi.BRL, tapi.BTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("got %d synced, expected 3", n)
+	}
+
+	// Order 2 is filled, 3 cancelled and 4 created.
+	f.orders[1] = order(2, tapi.OrderStatusFilled, "1600000100", tapi.Operation{ID: 2, ExecutedTimestamp: "1600000300"})
+	f.orders[1].UpdatedTimestamp = "1600000300"
+	f.orders[2].Status = tapi.OrderStatusCancelled
+	f.orders[2].UpdatedTimestamp = "1600000400"
+	f.orders = append(f.orders, order(4, tapi.OrderStatusOpen, "1600000500"))
+	f.calls = nil
+	n, err = s.Sync("main", f, tapi.BRL, tapi.BTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("got %d synced, expected 3", n)
+	}
+	// The new orders are listed and the open ones fetched by ID.
+	if len(f.calls) != 1 || f.calls[0] != (tapi.ListOrdersOpts{FromID: 4}) {
+		t.Errorf("got calls %+v, expected from ID 4", f.calls)
+	}
+	if len(f.gets) != 2 || f.gets[0] != 2 || f.gets[1] != 3 {
+		t.Errorf("got orders %v fetched by ID, expected [2 3]", f.gets)
+	}
+
+	// A reader in another process sees the synced orders.
+	r, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		q   Query
+		ids []int
+	}{
+		{Query{}, []int{1, 2, 3, 4}},
+		{Query{Account: "other"}, nil},
+		{Query{Pair: "BRLBTC", Statuses: []int{tapi.OrderStatusFilled, tapi.OrderStatusCancelled}}, []int{1, 2, 3}},
+		{Query{From: time.Unix(1600000100, 0), To: time.Unix(1600000500, 0)}, []int{2, 3}},
+	}
+	for _, tt := range tests {
+		orders, err := r.Orders(tt.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []int
+		for _, o := range orders {
+			ids = append(ids, o.ID)
+		}
+		if len(ids) != len(tt.ids) {
+			t.Errorf("%+v: got %v, expected %v", tt.q, ids, tt.ids)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != tt.ids[i] {
+				t.Errorf("%+v: got %v, expected %v", tt.q, ids, tt.ids)
+				break
+			}
+		}
+	}
+
+	execs, err := r.Executions(Query{From: time.Unix(1600000100, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(execs) != 1 || execs[0].OrderID != 2 || execs[0].Pair != "BRLBTC" {
+		t.Errorf("got %+v, expected the operation of order 2", execs)
+	}
+
+	// Nothing changed, so the file is not written.
+	f.calls = nil
+	if n, err := s.Sync("main", f, tapi.BRL, tapi.BTC); err != nil || n != 0 {
+		t.Errorf("got %d synced and error %v, expected 0", n, err)
+	}
+	if o, ok, _ := r.Order("main", "BRLBTC", 4); !ok || o.Status != tapi.OrderStatusOpen {
+		t.Errorf("got %+v, expected open order 4", o)
+	}
+}
+
+func TestSyncConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "orders")
+
+	// Several processes sync their accounts into the same file.
+	var accounts []string
+	for i := 0; i < 32; i++ {
+		accounts = append(accounts, fmt.Sprint("account", i))
+	}
+	errs := make(chan error, len(accounts))
+	for _, account := range accounts {
+		s, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := &fakeAPI{orders: []tapi.Order{order(1, tapi.OrderStatusOpen, "1600000000")}}
+		go func(account string) {
+			_, err := s.Sync(account, f, tapi.BRL, tapi.BTC)
+			errs <- err
+		}(account)
+	}
+	for range accounts {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, account := range accounts {
+		if _, ok, _ := r.Order(account, "BRLBTC", 1); !ok {
+			t.Errorf("got order of account %s lost", account)
+		}
+	}
+}
+
+func TestChanged(t *testing.T) {
+	open := order(1, tapi.OrderStatusOpen, "1600000000")
+	filled := order(1, tapi.OrderStatusFilled, "1600000000", tapi.Operation{ID: 1})
+	filled.UpdatedTimestamp = "1600000100"
+	if !changed(&open, &filled) {
+		t.Error("got filled order unchanged")
+	}
+	if changed(&filled, &open) {
+		t.Error("got older open state as a change")
+	}
+	if changed(&open, &open) {
+		t.Error("got same state as a change")
+	}
+}
@@ -0,0 +1,279 @@
+// Package stp prevents the orders of a tapi.API from trading against
+// the resting orders of the same account.
+//
+// Before each order placement the API reads the full orderbook and
+// walks the opposite side as the new order would, skipping the levels
+// marked with OrderInfo.IsOwner. The own orders reached are the self
+// trades, handled by the Policy of the API. Books without the order IDs,
+// as the ones of the v4 API, have no IsOwner either, so the orders are
+// rejected with ErrNoOwnership instead of placed unchecked.
+package stp
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	tapi "github.com/rschio/mb-tapi"
+	"github.com/rschio/mb-tapi/internal/dec"
+)
+
+// Policy is what the API does with a self trade.
+type Policy int
+
+const (
+	// Reject fails the new order with a *SelfTradeError.
+	Reject Policy = iota
+
+	// CancelResting cancels the own resting orders reached, then
+	// places the new order.
+	CancelResting
+
+	// Shrink reduces the new order to the quantity, or cost, before
+	// the first own order reached. It fails with a *SelfTradeError if
+	// nothing is left.
+	Shrink
+)
+
+func (p Policy) String() string {
+	switch p {
+	case Reject:
+		return "reject"
+	case CancelResting:
+		return "cancel resting"
+	case Shrink:
+		return "shrink"
+	}
+	return fmt.Sprintf("Policy(%d)", int(p))
+}
+
+var (
+	// ErrSelfTrade is matched by the errors of self trades.
+	ErrSelfTrade = errors.New("stp: order would trade with own order")
+
+	// ErrNoOwnership is returned when the orderbook does not tell the
+	// own orders.
+	ErrNoOwnership = errors.New("stp: orderbook without order ownership")
+)
+
+// SelfTradeError is the error of an order that would trade against the
+// own Orders.
+type SelfTradeError struct {
+	Pair   string
+	Orders []tapi.OrderInfo
+}
+
+func (e *SelfTradeError) Error() string {
+	return fmt.Sprintf("%v: %s order %d at %s", ErrSelfTrade, e.Pair,
+		e.Orders[0].OrderID, e.Orders[0].LimitPrice)
+}
+
+func (e *SelfTradeError) Unwrap() error { return ErrSelfTrade }
+
+// API is a tapi.API with self-trade prevention.
+type API struct {
+	tapi.API
+
+	policy  Policy
+	markets map[tapi.Coin]tapi.Market
+}
+
+// New creates an API that applies policy to the self trades of api. The
+// shrunk orders have the precision of the markets of api, if it is a
+// tapi client, or of tapi.DefaultMarkets.
+func New(api tapi.API, policy Policy) *API {
+	a := &API{API: api, policy: policy}
+	if m, ok := api.(interface {
+		Markets() map[tapi.Coin]tapi.Market
+	}); ok {
+		a.markets = m.Markets()
+	}
+	return a
+}
+
+// decimals returns the decimals of the quantity, or of the cost if cost
+// is set, of the orders of c2.
+func (a *API) decimals(c2 tapi.Coin, cost bool) int {
+	for _, m := range []tapi.Market{a.markets[c2], tapi.DefaultMarkets[c2]} {
+		d := m.QuantityDecimals
+		if cost {
+			d = m.CostDecimals
+		}
+		if d > 0 {
+			return d
+		}
+	}
+	return 8
+}
+
+// order is a new order. Limit nil is a market order, and cost non nil a
+// market buy by value.
+type order struct {
+	buy   bool
+	qt    *big.Rat
+	limit *big.Rat
+	cost  *big.Rat
+}
+
+// walk returns the own orders reached by o in the book and the quantity
+// and cost that o trades before the first one.
+func walk(ob *tapi.Orderbook, o *order) (own []tapi.OrderInfo, qt, cost *big.Rat, err error) {
+	side := ob.Asks
+	if !o.buy {
+		side = ob.Bids
+	}
+	qt, cost = dec.Zero(), dec.Zero()
+	left := o.qt
+	if o.cost != nil {
+		left = o.cost
+	}
+	for _, oi := range side {
+		if left.Sign() <= 0 {
+			break
+		}
+		if oi.OrderID == 0 {
+			return nil, nil, nil, ErrNoOwnership
+		}
+		p, err := dec.Parse(oi.LimitPrice)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if o.limit != nil && ((o.buy && p.Cmp(o.limit) > 0) || (!o.buy && p.Cmp(o.limit) < 0)) {
+			break
+		}
+		if oi.IsOwner {
+			own = append(own, oi)
+			continue
+		}
+		q, err := dec.Parse(oi.Quantity)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		v := dec.Mul(q, p)
+		if o.cost != nil && v.Cmp(left) > 0 {
+			v, q = left, dec.Quo(left, p)
+		} else if o.cost == nil && q.Cmp(left) > 0 {
+			q, v = left, dec.Mul(left, p)
+		}
+		if len(own) == 0 {
+			qt, cost = dec.Add(qt, q), dec.Add(cost, v)
+		}
+		if o.cost != nil {
+			left = dec.Sub(left, v)
+		} else {
+			left = dec.Sub(left, q)
+		}
+	}
+	return own, qt, cost, nil
+}
+
+// check applies the policy to o. It returns the shrunk quantity or cost
+// of o with prec decimals, or "" if unchanged.
+func (a *API) check(c1, c2 tapi.Coin, o *order, prec int) (string, error) {
+	ob, err := a.API.ListOrderbook(c1, c2, true)
+	if err != nil {
+		return "", err
+	}
+	own, qt, cost, err := walk(ob, o)
+	if err != nil || len(own) == 0 {
+		return "", err
+	}
+	serr := &SelfTradeError{Pair: c1.String() + c2.String(), Orders: own}
+	switch a.policy {
+	case CancelResting:
+		for _, oi := range own {
+			if _, err := a.API.CancelOrder(c1, c2, oi.OrderID); err != nil {
+				return "", fmt.Errorf("%v: cancel order %d: %w", serr, oi.OrderID, err)
+			}
+		}
+		return "", nil
+	case Shrink:
+		if o.cost != nil {
+			qt = cost
+		}
+		s, zero := floor(qt, prec)
+		if zero {
+			return "", serr
+		}
+		return s, nil
+	}
+	return "", serr
+}
+
+func (a *API) limit(c1, c2 tapi.Coin, buy bool, qt, limit string) (string, error) {
+	q, err := dec.Parse(qt)
+	if err != nil {
+		return "", err
+	}
+	l, err := dec.Parse(limit)
+	if err != nil {
+		return "", err
+	}
+	s, err := a.check(c1, c2, &order{buy: buy, qt: q, limit: l}, a.decimals(c2, false))
+	if err != nil || s == "" {
+		return qt, err
+	}
+	return s, nil
+}
+
+// PlaceBuyOrder places a buy order after applying the policy.
+func (a *API) PlaceBuyOrder(c1, c2 tapi.Coin, qt, limit string) (*tapi.Order, error) {
+	qt, err := a.limit(c1, c2, true, qt, limit)
+	if err != nil {
+		return nil, err
+	}
+	return a.API.PlaceBuyOrder(c1, c2, qt, limit)
+}
+
+// PlaceSellOrder places a sell order after applying the policy.
+func (a *API) PlaceSellOrder(c1, c2 tapi.Coin, qt, limit string) (*tapi.Order, error) {
+	qt, err := a.limit(c1, c2, false, qt, limit)
+	if err != nil {
+		return nil, err
+	}
+	return a.API.PlaceSellOrder(c1, c2, qt, limit)
+}
+
+// PlaceMarketBuyOrder places a market buy order after applying the
+// policy. Shrink reduces the cost.
+func (a *API) PlaceMarketBuyOrder(c1, c2 tapi.Coin, cost string) (*tapi.Order, error) {
+	v, err := dec.Parse(cost)
+	if err != nil {
+		return nil, err
+	}
+	s, err := a.check(c1, c2, &order{buy: true, cost: v}, a.decimals(c2, true))
+	if err != nil {
+		return nil, err
+	}
+	if s != "" {
+		cost = s
+	}
+	return a.API.PlaceMarketBuyOrder(c1, c2, cost)
+}
+
+// PlaceMarketSellOrder places a market sell order after applying the
+// policy.
+func (a *API) PlaceMarketSellOrder(c1, c2 tapi.Coin, qt string) (*tapi.Order, error) {
+	q, err := dec.Parse(qt)
+	if err != nil {
+		return nil, err
+	}
+	s, err := a.check(c1, c2, &order{buy: false, qt: q}, a.decimals(c2, false))
+	if err != nil {
+		return nil, err
+	}
+	if s != "" {
+		qt = s
+	}
+	return a.API.PlaceMarketSellOrder(c1, c2, qt)
+}
+
+// floor formats the positive x with prec decimal places rounding down,
+// so a shrunk order never reaches the own order. It reports whether the
+// result is zero.
+func floor(x *big.Rat, prec int) (string, bool) {
+	m := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(prec)), nil)
+	n := new(big.Int).Mul(x.Num(), m)
+	n.Quo(n, x.Denom())
+	return dec.Format(new(big.Rat).SetFrac(n, m), prec), n.Sign() == 0
+}
@@ -0,0 +1,145 @@
+package stp
+
+import (
+	"errors"
+	"testing"
+
+	tapi "github.com/rschio/mb-tapi"
+)
+
+// fakeAPI has our ask 7 at 101 between asks of others.
+type fakeAPI struct {
+	tapi.API
+
+	cancelled []int
+	placed    string
+	markets   map[tapi.Coin]tapi.Market
+	noIDs     bool
+}
+
+func (f *fakeAPI) Markets() map[tapi.Coin]tapi.Market {
+	return f.markets
+}
+
+func (f *fakeAPI) ListOrderbook(c1, c2 tapi.Coin, full bool) (*tapi.Orderbook, error) {
+	if f.noIDs {
+		// As the v4 books.
+		return &tapi.Orderbook{Asks: []tapi.OrderInfo{{Quantity: "0.5", LimitPrice: "100"}}}, nil
+	}
+	return &tapi.Orderbook{
+		Asks: []tapi.OrderInfo{
+			{OrderID: 5, Quantity: "0.5", LimitPrice: "100"},
+			{OrderID: 7, Quantity: "1", LimitPrice: "101", IsOwner: true},
+			{OrderID: 9, Quantity: "2", LimitPrice: "102"},
+		},
+		Bids: []tapi.OrderInfo{
+			{OrderID: 8, Quantity: "1", LimitPrice: "99", IsOwner: true},
+		},
+	}, nil
+}
+
+func (f *fakeAPI) CancelOrder(c1, c2 tapi.Coin, id int) (*tapi.Order, error) {
+	f.cancelled = append(f.cancelled, id)
+	return &tapi.Order{ID: id, Status: tapi.OrderStatusCancelled}, nil
+}
+
+func (f *fakeAPI) PlaceBuyOrder(c1, c2 tapi.Coin, qt, limit string) (*tapi.Order, error) {
+	f.placed = "buy " + qt + "@" + limit
+	return &tapi.Order{ID: 1}, nil
+}
+
+func (f *fakeAPI) PlaceSellOrder(c1, c2 tapi.Coin, qt, limit string) (*tapi.Order, error) {
+	f.placed = "sell " + qt + "@" + limit
+	return &tapi.Order{ID: 1}, nil
+}
+
+func (f *fakeAPI) PlaceMarketBuyOrder(c1, c2 tapi.Coin, cost string) (*tapi.Order, error) {
+	f.placed = "market buy " + cost
+	return &tapi.Order{ID: 1}, nil
+}
+
+func (f *fakeAPI) PlaceMarketSellOrder(c1, c2 tapi.Coin, qt string) (*tapi.Order, error) {
+	f.placed = "market sell " + qt
+	return &tapi.Order{ID: 1}, nil
+}
+
+func TestSelfTrade(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    Policy
+		place     func(a *API) error
+		err       error
+		placed    string
+		cancelled int
+	}{
+		{"below own", Reject, func(a *API) error {
+			_, err := a.PlaceBuyOrder(tapi.BRL, tapi.BTC, "2", "100.5")
+			return err
+		}, nil, "buy 2@100.5", 0},
+		{"not reached", Reject, func(a *API) error {
+			_, err := a.PlaceBuyOrder(tapi.BRL, tapi.BTC, "0.5", "102")
+			return err
+		}, nil, "buy 0.5@102", 0},
+		{"reject", Reject, func(a *API) error {
+			_, err := a.PlaceBuyOrder(tapi.BRL, tapi.BTC, "1", "101")
+			return err
+		}, ErrSelfTrade, "", 0},
+		{"cancel", CancelResting, func(a *API) error {
+			_, err := a.PlaceBuyOrder(tapi.BRL, tapi.BTC, "1", "101")
+			return err
+		}, nil, "buy 1@101", 7},
+		{"shrink", Shrink, func(a *API) error {
+			_, err := a.PlaceBuyOrder(tapi.BRL, tapi.BTC, "1", "102")
+			return err
+		}, nil, "buy 0.50000000@102", 0},
+		{"shrink to zero", Shrink, func(a *API) error {
+			_, err := a.PlaceSellOrder(tapi.BRL, tapi.BTC, "1", "98")
+			return err
+		}, ErrSelfTrade, "", 0},
+		{"market buy shrink", Shrink, func(a *API) error {
+			_, err := a.PlaceMarketBuyOrder(tapi.BRL, tapi.BTC, "200")
+			return err
+		}, nil, "market buy 50.00000", 0},
+		{"market sell", Reject, func(a *API) error {
+			_, err := a.PlaceMarketSellOrder(tapi.BRL, tapi.BTC, "0.1")
+			return err
+		}, ErrSelfTrade, "", 0},
+	}
+	for _, tt := range tests {
+		f := &fakeAPI{}
+		err := tt.place(New(f, tt.policy))
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: got error %v, expected %v", tt.name, err, tt.err)
+		}
+		var serr *SelfTradeError
+		if tt.err != nil && (!errors.As(err, &serr) || len(serr.Orders) != 1) {
+			t.Errorf("%s: got error %v, expected *SelfTradeError with one order", tt.name, err)
+		}
+		if f.placed != tt.placed {
+			t.Errorf("%s: got placed %q, expected %q", tt.name, f.placed, tt.placed)
+		}
+		if tt.cancelled != 0 && (len(f.cancelled) != 1 || f.cancelled[0] != tt.cancelled) {
+			t.Errorf("%s: got cancelled %v, expected %d", tt.name, f.cancelled, tt.cancelled)
+		}
+	}
+}
+
+func TestShrinkPrecision(t *testing.T) {
+	f := &fakeAPI{markets: map[tapi.Coin]tapi.Market{tapi.BTC: {QuantityDecimals: 2}}}
+	if _, err := New(f, Shrink).PlaceBuyOrder(tapi.BRL, tapi.BTC, "1", "102"); err != nil {
+		t.Fatal(err)
+	}
+	if f.placed != "buy 0.50@102" {
+		t.Errorf("got placed %q, expected %q", f.placed, "buy 0.50@102")
+	}
+}
+
+func TestNoOwnership(t *testing.T) {
+	f := &fakeAPI{noIDs: true}
+	if _, err := New(f, Reject).PlaceBuyOrder(tapi.BRL, tapi.BTC, "0.1", "100"); !errors.Is(err, ErrNoOwnership) {
+		t.Errorf("got error %v, expected %v", err, ErrNoOwnership)
+	}
+	if f.placed != "" {
+		t.Errorf("got placed %q, expected none", f.placed)
+	}
+}
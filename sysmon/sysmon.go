@@ -0,0 +1,368 @@
+// Package sysmon monitors the system messages of the exchange.
+//
+// A Monitor polls ListSystemMessages, drops the messages already seen
+// and publishes the new ones as Events with a typed Severity and time.
+// Messages of level ERROR, or with a configured event code, are
+// incidents. A Gate blocks, or warns about, the mutating calls of a
+// tapi.API while an incident is active, so maintenance is found before
+// orders fail.
+package sysmon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	tapi "github.com/rschio/mb-tapi"
+)
+
+// Severity is the level of a system message.
+type Severity int
+
+// Severities, from the lowest.
+const (
+	SeverityUnknown Severity = iota
+	SeverityInfo
+	SeverityWarning
+	SeverityError
+)
+
+// ParseSeverity parses the level of a system message.
+func ParseSeverity(lvl string) Severity {
+	switch strings.ToUpper(lvl) {
+	case "INFO":
+		return SeverityInfo
+	case "WARNING":
+		return SeverityWarning
+	case "ERROR":
+		return SeverityError
+	}
+	return SeverityUnknown
+}
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "INFO"
+	case SeverityWarning:
+		return "WARNING"
+	case SeverityError:
+		return "ERROR"
+	}
+	return "UNKNOWN"
+}
+
+// Event is a system message.
+type Event struct {
+	Time      time.Time
+	Severity  Severity
+	EventCode int
+	Content   string
+
+	// Incident reports whether the message is an incident.
+	Incident bool
+}
+
+// DefaultIncidentTTL is how long an incident stays active after its
+// message is no longer listed, when no message ends it.
+const DefaultIncidentTTL = 30 * time.Minute
+
+// DefaultIncidentMaxAge is how long after the date of its message an
+// incident stays active.
+const DefaultIncidentMaxAge = 6 * time.Hour
+
+// Config configures a Monitor.
+type Config struct {
+	// IncidentCodes are the event codes that are incidents at any
+	// level.
+	IncidentCodes []int
+
+	// IncidentTTL is how long an incident stays active after the last
+	// poll that listed its message. Zero means DefaultIncidentTTL.
+	IncidentTTL time.Duration
+
+	// IncidentMaxAge is how long an incident stays active after the
+	// date of its message, even if it is still listed, so the old
+	// messages listed on the first poll are history and not active
+	// incidents. Zero means DefaultIncidentMaxAge.
+	IncidentMaxAge time.Duration
+}
+
+// Monitor polls the system messages. It is safe for concurrent use.
+type Monitor struct {
+	api    tapi.API
+	codes  map[int]bool
+	ttl    time.Duration
+	maxAge time.Duration
+	now    func() time.Time
+
+	mu        sync.Mutex
+	seen      map[tapi.SystemMessage]time.Time
+	incidents []incident
+	handlers  []func(Event)
+}
+
+// incident is an active incident and its message.
+type incident struct {
+	Event
+	msg tapi.SystemMessage
+}
+
+// New creates a Monitor of the system messages of api.
+func New(api tapi.API, cfg Config) *Monitor {
+	m := &Monitor{
+		api:    api,
+		codes:  make(map[int]bool),
+		ttl:    cfg.IncidentTTL,
+		maxAge: cfg.IncidentMaxAge,
+		now:    time.Now,
+		seen:   make(map[tapi.SystemMessage]time.Time),
+	}
+	if m.ttl == 0 {
+		m.ttl = DefaultIncidentTTL
+	}
+	if m.maxAge == 0 {
+		m.maxAge = DefaultIncidentMaxAge
+	}
+	for _, c := range cfg.IncidentCodes {
+		m.codes[c] = true
+	}
+	return m
+}
+
+// OnEvent adds a handler called with every new event, in time order.
+// The handlers are called by Poll and must not call the Monitor.
+func (m *Monitor) OnEvent(h func(Event)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers = append(m.handlers, h)
+}
+
+// Poll fetches the system messages and returns the new ones. A message
+// with an invalid date gets the time of the poll. The messages not listed
+// for the IncidentTTL are forgotten, and their incidents end.
+func (m *Monitor) Poll() ([]Event, error) {
+	msgs, err := m.api.ListSystemMessages("")
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	var news []incident
+	for _, msg := range msgs {
+		_, ok := m.seen[msg]
+		m.seen[msg] = now
+		if ok {
+			continue
+		}
+		t, err := tapi.ParseTimestamp(msg.MsgDate)
+		if err != nil {
+			t = now
+		}
+		sev := ParseSeverity(msg.Level)
+		news = append(news, incident{msg: msg, Event: Event{
+			Time:      t,
+			Severity:  sev,
+			EventCode: msg.EventCode,
+			Content:   msg.MsgContent,
+			Incident:  sev == SeverityError || m.codes[msg.EventCode],
+		}})
+	}
+	for msg, t := range m.seen {
+		if now.Sub(t) >= m.ttl {
+			delete(m.seen, msg)
+		}
+	}
+	m.update(nil, now)
+	sort.SliceStable(news, func(i, j int) bool { return news[i].Time.Before(news[j].Time) })
+	events := make([]Event, 0, len(news))
+	for _, in := range news {
+		m.update(&in, now)
+		for _, h := range m.handlers {
+			h(in.Event)
+		}
+		events = append(events, in.Event)
+	}
+	return events, nil
+}
+
+// update drops the incidents whose messages were forgotten or are older
+// than the IncidentMaxAge. If e is an incident not that old it is added,
+// otherwise it ends the ERROR incidents with its event code.
+func (m *Monitor) update(e *incident, now time.Time) {
+	if e != nil && e.Incident {
+		if now.Sub(e.Time) < m.maxAge {
+			m.incidents = append(m.incidents, *e)
+		}
+		return
+	}
+	active := m.incidents[:0]
+	for _, in := range m.incidents {
+		if _, ok := m.seen[in.msg]; !ok || now.Sub(in.Time) >= m.maxAge {
+			continue
+		}
+		if e != nil && in.EventCode == e.EventCode && in.Severity == SeverityError && !in.Time.After(e.Time) {
+			continue
+		}
+		active = append(active, in)
+	}
+	m.incidents = active
+}
+
+// Run polls the messages every interval until ctx is done. The errors
+// of Poll are passed to errf, if not nil, and do not stop Run.
+func (m *Monitor) Run(ctx context.Context, every time.Duration, errf func(error)) error {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		if _, err := m.Poll(); err != nil && errf != nil {
+			errf(err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Incidents returns the active incidents. An incident is active until a
+// message ends it, its message is not listed for the IncidentTTL or it
+// is older than the IncidentMaxAge.
+func (m *Monitor) Incidents() []Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	var active []Event
+	for _, in := range m.incidents {
+		if now.Sub(m.seen[in.msg]) < m.ttl && now.Sub(in.Time) < m.maxAge {
+			active = append(active, in.Event)
+		}
+	}
+	return active
+}
+
+// ErrIncident is returned by a Gate while an incident is active.
+var ErrIncident = errors.New("sysmon: exchange incident active")
+
+// IncidentError is the error of a call blocked by a Gate.
+type IncidentError struct {
+	Method    string
+	Incidents []Event
+}
+
+func (e *IncidentError) Error() string {
+	in := e.Incidents[len(e.Incidents)-1]
+	return fmt.Sprintf("%s: %v: %s %d: %s", e.Method, ErrIncident, in.Severity, in.EventCode, in.Content)
+}
+
+func (e *IncidentError) Unwrap() error { return ErrIncident }
+
+// Mode is what a Gate does with a call during an incident.
+type Mode int
+
+const (
+	// Block fails the call with an IncidentError.
+	Block Mode = iota
+
+	// Warn makes the call and reports it to the warn function.
+	Warn
+)
+
+// Gate is a tapi.API that checks the incidents of a Monitor before each
+// order placement, cancellation and withdrawal.
+type Gate struct {
+	tapi.API
+
+	m    *Monitor
+	mode Mode
+	warn func(*IncidentError)
+
+	// AllowCancel lets the cancellations through in Block mode.
+	AllowCancel bool
+}
+
+// NewGate creates a Gate of api. In Warn mode, warn is called with each
+// call made during an incident.
+func NewGate(api tapi.API, m *Monitor, mode Mode, warn func(*IncidentError)) *Gate {
+	return &Gate{API: api, m: m, mode: mode, warn: warn}
+}
+
+func (g *Gate) check(method string) error {
+	in := g.m.Incidents()
+	if len(in) == 0 {
+		return nil
+	}
+	err := &IncidentError{Method: method, Incidents: in}
+	if g.mode == Warn || (method == "cancel_order" && g.AllowCancel) {
+		if g.warn != nil {
+			g.warn(err)
+		}
+		return nil
+	}
+	return err
+}
+
+// PlaceBuyOrder places a buy order if there is no incident.
+func (g *Gate) PlaceBuyOrder(c1, c2 tapi.Coin, qt, limit string) (*tapi.Order, error) {
+	if err := g.check("place_buy_order"); err != nil {
+		return nil, err
+	}
+	return g.API.PlaceBuyOrder(c1, c2, qt, limit)
+}
+
+// PlaceSellOrder places a sell order if there is no incident.
+func (g *Gate) PlaceSellOrder(c1, c2 tapi.Coin, qt, limit string) (*tapi.Order, error) {
+	if err := g.check("place_sell_order"); err != nil {
+		return nil, err
+	}
+	return g.API.PlaceSellOrder(c1, c2, qt, limit)
+}
+
+// PlaceMarketBuyOrder places a market buy order if there is no incident.
+func (g *Gate) PlaceMarketBuyOrder(c1, c2 tapi.Coin, cost string) (*tapi.Order, error) {
+	if err := g.check("place_market_buy_order"); err != nil {
+		return nil, err
+	}
+	return g.API.PlaceMarketBuyOrder(c1, c2, cost)
+}
+
+// PlaceMarketSellOrder places a market sell order if there is no
+// incident.
+func (g *Gate) PlaceMarketSellOrder(c1, c2 tapi.Coin, qt string) (*tapi.Order, error) {
+	if err := g.check("place_market_sell_order"); err != nil {
+		return nil, err
+	}
+	return g.API.PlaceMarketSellOrder(c1, c2, qt)
+}
+
+// CancelOrder cancels an order if there is no incident or AllowCancel
+// is set.
+func (g *Gate) CancelOrder(c1, c2 tapi.Coin, id int) (*tapi.Order, error) {
+	if err := g.check("cancel_order"); err != nil {
+		return nil, err
+	}
+	return g.API.CancelOrder(c1, c2, id)
+}
+
+// WithdrawBRL requests a BRL withdrawal if there is no incident.
+func (g *Gate) WithdrawBRL(desc, qt, accRef string) (*tapi.Withdrawal, error) {
+	if err := g.check("withdraw_coin"); err != nil {
+		return nil, err
+	}
+	return g.API.WithdrawBRL(desc, qt, accRef)
+}
+
+// WithdrawCrypto requests a digital coin transfer if there is no
+// incident.
+func (g *Gate) WithdrawCrypto(coin tapi.Coin, desc string, i *tapi.WithdrawInfo) (*tapi.Withdrawal, error) {
+	if err := g.check("withdraw_coin"); err != nil {
+		return nil, err
+	}
+	return g.API.WithdrawCrypto(coin, desc, i)
+}
@@ -0,0 +1,158 @@
+package sysmon
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	tapi "github.com/rschio/mb-tapi"
+)
+
+type fakeAPI struct {
+	tapi.API
+
+	msgs   []tapi.SystemMessage
+	placed int
+}
+
+func (f *fakeAPI) ListSystemMessages(lvl string) ([]tapi.SystemMessage, error) {
+	return f.msgs, nil
+}
+
+func (f *fakeAPI) PlaceBuyOrder(c1, c2 tapi.Coin, qt, limit string) (*tapi.Order, error) {
+	f.placed++
+	return &tapi.Order{ID: f.placed}, nil
+}
+
+func (f *fakeAPI) CancelOrder(c1, c2 tapi.Coin, id int) (*tapi.Order, error) {
+	return &tapi.Order{ID: id}, nil
+}
+
+func TestMonitor(t *testing.T) {
+	f := &fakeAPI{msgs: []tapi.SystemMessage{
+		{MsgDate: "1600000000", Level: "INFO", EventCode: 1, MsgContent: "hello"},
+		{MsgDate: "1600000100", Level: "warning", EventCode: 7, MsgContent: "slow withdrawals"},
+	}}
+	now := time.Unix(1600000200, 0)
+	m := New(f, Config{IncidentCodes: []int{7}, IncidentTTL: time.Hour})
+	m.now = func() time.Time { return now }
+	var got []Event
+	m.OnEvent(func(e Event) { got = append(got, e) })
+
+	events, err := m.Poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || len(got) != 2 {
+		t.Fatalf("got %d events and %d published, expected 2", len(events), len(got))
+	}
+	if e := events[1]; e.Severity != SeverityWarning || !e.Incident || !e.Time.Equal(time.Unix(1600000100, 0)) {
+		t.Errorf("got %+v, expected warning incident", e)
+	}
+	// The same messages are not published again.
+	if events, _ := m.Poll(); len(events) != 0 {
+		t.Errorf("got %d events, expected 0", len(events))
+	}
+
+	f.msgs = append(f.msgs,
+		tapi.SystemMessage{MsgDate: "1600000150", Level: "ERROR", EventCode: 9, MsgContent: "maintenance"})
+	m.Poll()
+	if n := len(m.Incidents()); n != 2 {
+		t.Errorf("got %d incidents, expected 2", n)
+	}
+	// An INFO message of the same code ends the ERROR incident.
+	f.msgs = append(f.msgs,
+		tapi.SystemMessage{MsgDate: "1600000180", Level: "INFO", EventCode: 9, MsgContent: "back"})
+	m.Poll()
+	in := m.Incidents()
+	if len(in) != 1 || in[0].EventCode != 7 {
+		t.Errorf("got %+v, expected incident 7", in)
+	}
+	// The incidents still listed stay active after the TTL.
+	now = now.Add(time.Hour)
+	m.Poll()
+	if n := len(m.Incidents()); n != 1 {
+		t.Errorf("got %d incidents, expected 1", n)
+	}
+	// The incidents expire after the TTL without their message, and
+	// the messages are forgotten.
+	f.msgs = f.msgs[:1]
+	now = now.Add(30 * time.Minute)
+	m.Poll()
+	if n := len(m.Incidents()); n != 1 {
+		t.Errorf("got %d incidents, expected 1", n)
+	}
+	now = now.Add(30 * time.Minute)
+	m.Poll()
+	if n := len(m.Incidents()); n != 0 {
+		t.Errorf("got %d incidents, expected 0", n)
+	}
+	if len(m.seen) != 1 || len(m.incidents) != 0 {
+		t.Errorf("got %d seen and %d incidents, expected 1 and 0", len(m.seen), len(m.incidents))
+	}
+}
+
+func TestGate(t *testing.T) {
+	f := &fakeAPI{}
+	m := New(f, Config{})
+	m.now = func() time.Time { return time.Unix(1600000200, 0) }
+	g := NewGate(f, m, Block, nil)
+	if _, err := g.PlaceBuyOrder(tapi.BRL, tapi.BTC, "1", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	f.msgs = []tapi.SystemMessage{{MsgDate: "1600000100", Level: "ERROR", EventCode: 3, MsgContent: "down"}}
+	m.Poll()
+	_, err := g.PlaceBuyOrder(tapi.BRL, tapi.BTC, "1", "1")
+	var ierr *IncidentError
+	if !errors.Is(err, ErrIncident) || !errors.As(err, &ierr) || ierr.Method != "place_buy_order" {
+		t.Errorf("got error %v, expected incident of place_buy_order", err)
+	}
+	if f.placed != 1 {
+		t.Errorf("got %d placed, expected 1", f.placed)
+	}
+	if _, err := g.CancelOrder(tapi.BRL, tapi.BTC, 1); !errors.Is(err, ErrIncident) {
+		t.Errorf("got error %v, expected %v", err, ErrIncident)
+	}
+	g.AllowCancel = true
+	if _, err := g.CancelOrder(tapi.BRL, tapi.BTC, 1); err != nil {
+		t.Errorf("got error %v, expected nil", err)
+	}
+
+	var warned []string
+	w := NewGate(f, m, Warn, func(e *IncidentError) { warned = append(warned, e.Method) })
+	if _, err := w.PlaceBuyOrder(tapi.BRL, tapi.BTC, "1", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if f.placed != 2 || len(warned) != 1 {
+		t.Errorf("got %d placed and %d warnings, expected 2 and 1", f.placed, len(warned))
+	}
+}
+
+func TestIncidentMaxAge(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	f := &fakeAPI{msgs: []tapi.SystemMessage{
+		{MsgDate: "1599900000", Level: "ERROR", EventCode: 3, MsgContent: "old outage"},
+		{MsgDate: "1599999000", Level: "ERROR", EventCode: 4, MsgContent: "maintenance"},
+	}}
+	m := New(f, Config{IncidentMaxAge: time.Hour})
+	m.now = func() time.Time { return now }
+	events, err := m.Poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("got %d events, expected 2", len(events))
+	}
+	// The old message of the first poll is history.
+	in := m.Incidents()
+	if len(in) != 1 || in[0].EventCode != 4 {
+		t.Errorf("got %+v, expected incident 4", in)
+	}
+	// An incident still listed ends after the max age.
+	now = now.Add(45 * time.Minute)
+	m.Poll()
+	if n := len(m.Incidents()); n != 0 {
+		t.Errorf("got %d incidents, expected 0", n)
+	}
+}
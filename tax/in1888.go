@@ -0,0 +1,79 @@
+package tax
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/rschio/mb-tapi/internal/dec"
+)
+
+// Declarant identifies the person who files the IN 1888 report.
+type Declarant struct {
+	// CPF is the declarant CPF, only digits.
+	CPF string
+
+	// Name is the declarant full name.
+	Name string
+}
+
+// Operation codes of the IN 1888 layout.
+const (
+	opBuySell    = "I"
+	opWithdrawal = "V"
+)
+
+// WriteIN1888 writes the IN RFB 1888/2019 records of the operations of
+// year and month. Each line is a record with fields separated by "|":
+//
+//	0000|CPF|NAME|YYYYMM
+//	0110|DDMMYYYY|I|VALUE|FEE|SYMBOL|QUANTITY
+//	0410|DDMMYYYY|V|FEE|SYMBOL|QUANTITY|ADDRESS
+//	9999|COUNT 0110|TOTAL 0110|COUNT 0410
+//
+// Values are in BRL with 2 decimal places and quantities have 10
+// decimal places, both using comma as the decimal separator.
+func (r *Report) WriteIN1888(w io.Writer, d Declarant, year int, month time.Month) error {
+	bw := bufio.NewWriter(w)
+	record(bw, "0000", d.CPF, strings.ToUpper(d.Name), fmt.Sprintf("%04d%02d", year, month))
+	n0110, total := 0, dec.Zero()
+	for _, t := range r.Trades {
+		if !sameMonth(t.Time, year, month) {
+			continue
+		}
+		record(bw, "0110", date(t.Time), opBuySell, value(t.Value), value(t.Fee),
+			t.Coin.String(), quantity(t.Quantity))
+		n0110++
+		total.Add(total, t.Value)
+	}
+	n0410 := 0
+	for _, tr := range r.Transfers {
+		if !sameMonth(tr.Time, year, month) {
+			continue
+		}
+		record(bw, "0410", date(tr.Time), opWithdrawal, quantity(tr.Fee),
+			tr.Coin.String(), quantity(tr.Quantity), tr.Address)
+		n0410++
+	}
+	record(bw, "9999", fmt.Sprint(n0110), value(total), fmt.Sprint(n0410))
+	return bw.Flush()
+}
+
+func record(w *bufio.Writer, fields ...string) {
+	w.WriteString(strings.Join(fields, "|"))
+	w.WriteString("\r\n")
+}
+
+func sameMonth(t time.Time, year int, month time.Month) bool {
+	t = t.In(Location)
+	return t.Year() == year && t.Month() == month
+}
+
+func date(t time.Time) string { return t.In(Location).Format("02012006") }
+
+func value(x *big.Rat) string { return strings.Replace(dec.Format(x, 2), ".", ",", 1) }
+
+func quantity(x *big.Rat) string { return strings.Replace(dec.Format(x, 10), ".", ",", 1) }
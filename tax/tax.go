@@ -0,0 +1,322 @@
+// Package tax builds the Brazilian tax reports of the operations made
+// on Mercado Bitcoin: the monthly capital gains summary and the
+// operation records of the Receita Federal IN RFB 1888/2019 file.
+//
+// Gains are computed with the average cost method, using exact decimal
+// math and the executed timestamp of each fill.
+package tax
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"time"
+
+	tapi "github.com/rschio/mb-tapi"
+	"github.com/rschio/mb-tapi/internal/dec"
+)
+
+// ExemptionLimit is the monthly sales total, in BRL, up to which the
+// capital gains of the month are exempt.
+var ExemptionLimit = big.NewRat(35000, 1)
+
+// Location is the time zone used to group the operations by month.
+var Location = time.FixedZone("BRT", -3*60*60)
+
+// Source is the part of the tapi client used to fetch the history.
+type Source interface {
+	ListAllOrders(c1, c2 tapi.Coin, opts *tapi.ListOrdersOpts) ([]tapi.Order, error)
+	GetWithdrawal(coin tapi.Coin, id int) (*tapi.Withdrawal, error)
+}
+
+// WithdrawalRef identifies a withdrawal. The API has no method to list
+// the withdrawals, so their IDs must be known by the caller.
+type WithdrawalRef struct {
+	Coin tapi.Coin
+	ID   int
+}
+
+// Fetch walks the full order history of the pairs BRL/coin of every coin
+// in coins and fetches the withdrawals in refs.
+func Fetch(src Source, coins []tapi.Coin, refs []WithdrawalRef) ([]tapi.Order, []tapi.Withdrawal, error) {
+	opts := &tapi.ListOrdersOpts{HasFills: 1}
+	var orders []tapi.Order
+	for _, coin := range coins {
+		if coin == tapi.BRL {
+			continue
+		}
+		o, err := src.ListAllOrders(tapi.BRL, coin, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		orders = append(orders, o...)
+	}
+	ws := make([]tapi.Withdrawal, 0, len(refs))
+	for _, ref := range refs {
+		w, err := src.GetWithdrawal(ref.Coin, ref.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		ws = append(ws, *w)
+	}
+	return orders, ws, nil
+}
+
+// Trade is a fill of an order.
+type Trade struct {
+	Time time.Time
+	Coin tapi.Coin
+	Buy  bool
+
+	// Quantity is the gross quantity of the fill.
+	Quantity *big.Rat
+
+	// Price is the unit price in BRL.
+	Price *big.Rat
+
+	// Value is Quantity * Price in BRL.
+	Value *big.Rat
+
+	// Fee is the fee paid in BRL. The fee of a buy is charged in
+	// the digital coin and converted by the fill price.
+	Fee *big.Rat
+
+	// Gain is the capital gain of a sell, it is nil on buys.
+	Gain *big.Rat
+}
+
+// Transfer is a withdrawal of a digital coin.
+type Transfer struct {
+	Time     time.Time
+	Coin     tapi.Coin
+	Quantity *big.Rat
+	Fee      *big.Rat
+	Address  string
+}
+
+// Month is the summary of the trades of a month.
+type Month struct {
+	Year  int
+	Month time.Month
+
+	// Sales is the total value of the sells in BRL.
+	Sales *big.Rat
+
+	// Gain is the net capital gain of the sells in BRL.
+	Gain *big.Rat
+
+	// Exempt is true when Sales is at most ExemptionLimit.
+	Exempt bool
+}
+
+// TaxableGain returns the gain subject to tax, zero if the month is
+// exempt or has a loss.
+func (m *Month) TaxableGain() *big.Rat {
+	if m.Exempt || m.Gain.Sign() <= 0 {
+		return dec.Zero()
+	}
+	return new(big.Rat).Set(m.Gain)
+}
+
+// Report contains the trades, transfers and the monthly summaries
+// sorted by time.
+type Report struct {
+	Months    []Month
+	Trades    []Trade
+	Transfers []Transfer
+}
+
+type position struct {
+	qt   *big.Rat
+	cost *big.Rat
+}
+
+// event is a fill or a withdrawal to be processed in time order.
+type event struct {
+	t     time.Time
+	trade *Trade
+	feeRt *big.Rat
+	tr    *Transfer
+}
+
+// Generate builds the report of orders and withdrawals. Cancelled
+// withdrawals and BRL withdrawals are ignored.
+func Generate(orders []tapi.Order, ws []tapi.Withdrawal) (*Report, error) {
+	var evs []event
+	for _, o := range orders {
+		c1, coin, err := tapi.ParsePair(o.CoinPair)
+		if err != nil {
+			return nil, err
+		}
+		if c1 != tapi.BRL {
+			return nil, fmt.Errorf("tax: unsupported coin pair %s", o.CoinPair)
+		}
+		for _, op := range o.Operations {
+			ev, err := fillEvent(coin, o.Type == tapi.OrderTypeBuy, op)
+			if err != nil {
+				return nil, fmt.Errorf("tax: order %d: %v", o.ID, err)
+			}
+			evs = append(evs, ev)
+		}
+	}
+	for _, w := range ws {
+		if w.Status == tapi.WithdrawalStatusCancelled || w.Coin == tapi.BRL.String() {
+			continue
+		}
+		ev, err := transferEvent(w)
+		if err != nil {
+			return nil, fmt.Errorf("tax: withdrawal %d: %v", w.ID, err)
+		}
+		evs = append(evs, ev)
+	}
+	sort.SliceStable(evs, func(i, j int) bool { return evs[i].t.Before(evs[j].t) })
+
+	r := &Report{}
+	pos := make(map[tapi.Coin]*position)
+	for _, ev := range evs {
+		var coin tapi.Coin
+		if ev.trade != nil {
+			coin = ev.trade.Coin
+		} else {
+			coin = ev.tr.Coin
+		}
+		p := pos[coin]
+		if p == nil {
+			p = &position{qt: dec.Zero(), cost: dec.Zero()}
+			pos[coin] = p
+		}
+		if ev.tr != nil {
+			if _, err := p.sell(dec.Add(ev.tr.Quantity, ev.tr.Fee)); err != nil {
+				return nil, fmt.Errorf("tax: transfer of %s at %v: %v", coin, ev.t, err)
+			}
+			r.Transfers = append(r.Transfers, *ev.tr)
+			continue
+		}
+		t := ev.trade
+		if t.Buy {
+			// The buy fee is charged in the digital coin.
+			fee := dec.Percent(t.Quantity, ev.feeRt)
+			p.qt.Add(p.qt, dec.Sub(t.Quantity, fee))
+			p.cost.Add(p.cost, t.Value)
+		} else {
+			basis, err := p.sell(t.Quantity)
+			if err != nil {
+				return nil, fmt.Errorf("tax: sell of %s at %v: %v", coin, ev.t, err)
+			}
+			t.Gain = dec.Sub(dec.Sub(t.Value, t.Fee), basis)
+			m := r.month(t.Time)
+			m.Sales.Add(m.Sales, t.Value)
+			m.Gain.Add(m.Gain, t.Gain)
+		}
+		r.Trades = append(r.Trades, *t)
+	}
+	for i := range r.Months {
+		m := &r.Months[i]
+		m.Exempt = m.Sales.Cmp(ExemptionLimit) <= 0
+	}
+	return r, nil
+}
+
+func fillEvent(coin tapi.Coin, buy bool, op tapi.Operation) (event, error) {
+	t, err := tapi.ParseTimestamp(op.ExecutedTimestamp)
+	if err != nil {
+		return event{}, err
+	}
+	qt, err := dec.Parse(op.Quantity)
+	if err != nil {
+		return event{}, err
+	}
+	price, err := dec.Parse(op.Price)
+	if err != nil {
+		return event{}, err
+	}
+	rate, err := dec.Parse(op.FeeRate)
+	if err != nil {
+		return event{}, err
+	}
+	value := dec.Mul(qt, price)
+	trade := &Trade{
+		Time:     t,
+		Coin:     coin,
+		Buy:      buy,
+		Quantity: qt,
+		Price:    price,
+		Value:    value,
+		Fee:      dec.Percent(value, rate),
+	}
+	return event{t: t, trade: trade, feeRt: rate}, nil
+}
+
+func transferEvent(w tapi.Withdrawal) (event, error) {
+	coin, err := tapi.ParseCoin(w.Coin)
+	if err != nil {
+		return event{}, err
+	}
+	t, err := tapi.ParseTimestamp(w.CreatedTimestamp)
+	if err != nil {
+		return event{}, err
+	}
+	qt, err := dec.Parse(w.Quantity)
+	if err != nil {
+		return event{}, err
+	}
+	fee, err := dec.Parse(w.Fee)
+	if err != nil {
+		return event{}, err
+	}
+	// When the net quantity is informed, the quantity already
+	// includes the fee.
+	if w.NetQuantity != "" {
+		qt.Sub(qt, fee)
+	}
+	tr := &Transfer{Time: t, Coin: coin, Quantity: qt, Fee: fee, Address: w.Address}
+	return event{t: t, tr: tr}, nil
+}
+
+// sell removes qt from p and returns its cost basis.
+func (p *position) sell(qt *big.Rat) (*big.Rat, error) {
+	if p.qt.Cmp(qt) < 0 {
+		return nil, fmt.Errorf("quantity %s exceeds position %s",
+			dec.Format(qt, 8), dec.Format(p.qt, 8))
+	}
+	basis := dec.Quo(dec.Mul(p.cost, qt), p.qt)
+	p.qt.Sub(p.qt, qt)
+	p.cost.Sub(p.cost, basis)
+	return basis, nil
+}
+
+func (r *Report) month(t time.Time) *Month {
+	t = t.In(Location)
+	for i := range r.Months {
+		m := &r.Months[i]
+		if m.Year == t.Year() && m.Month == t.Month() {
+			return m
+		}
+	}
+	r.Months = append(r.Months, Month{
+		Year:  t.Year(),
+		Month: t.Month(),
+		Sales: dec.Zero(),
+		Gain:  dec.Zero(),
+	})
+	return &r.Months[len(r.Months)-1]
+}
+
+// WriteSummary writes the monthly summaries as CSV.
+func (r *Report) WriteSummary(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"month", "sales", "gain", "exempt", "taxable_gain"})
+	for _, m := range r.Months {
+		cw.Write([]string{
+			fmt.Sprintf("%04d-%02d", m.Year, m.Month),
+			dec.Format(m.Sales, 2),
+			dec.Format(m.Gain, 2),
+			fmt.Sprint(m.Exempt),
+			dec.Format(m.TaxableGain(), 2),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
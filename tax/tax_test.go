@@ -0,0 +1,147 @@
+package tax
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	tapi "github.com/rschio/mb-tapi"
+	"github.com/rschio/mb-tapi/internal/dec"
+)
+
+var (
+	testOrders = []tapi.Order{
+		{ID: 1, CoinPair: "BRLBTC", Type: tapi.OrderTypeBuy, Operations: []tapi.Operation{
+			{ID: 1, Quantity: "1.00000000", Price: "100000.00000", FeeRate: "0.30", ExecutedTimestamp: "1578657600"},
+		}},
+		{ID: 2, CoinPair: "BRLBTC", Type: tapi.OrderTypeSell, Operations: []tapi.Operation{
+			{ID: 2, Quantity: "0.50000000", Price: "120000.00000", FeeRate: "0.70", ExecutedTimestamp: "1581336000"},
+		}},
+		{ID: 3, CoinPair: "BRLBTC", Type: tapi.OrderTypeSell, Operations: []tapi.Operation{
+			{ID: 3, Quantity: "0.10000000", Price: "90000.00000", FeeRate: "0.70", ExecutedTimestamp: "1583841600"},
+		}},
+	}
+	testWithdrawals = []tapi.Withdrawal{
+		{ID: 1, Coin: "BTC", Quantity: "0.10000000", Fee: "0.00050000", Address: "1G38ybvfUyn96aJbKnzkifX2eEMH9N87ww",
+			Status: tapi.WithdrawalStatusDone, CreatedTimestamp: "1583928000"},
+		{ID: 2, Coin: "BTC", Quantity: "5.00000000", Fee: "0.00050000", Address: "1G38ybvfUyn96aJbKnzkifX2eEMH9N87ww",
+			Status: tapi.WithdrawalStatusCancelled, CreatedTimestamp: "1583928000"},
+		{ID: 3, Coin: "BRL", Quantity: "300.56", NetQuantity: "291.68", Fee: "8.88",
+			Status: tapi.WithdrawalStatusDone, CreatedTimestamp: "1583928000"},
+	}
+)
+
+func TestGenerate(t *testing.T) {
+	r, err := Generate(testOrders, testWithdrawals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Trades) != 3 {
+		t.Fatalf("got %d trades, expected %d", len(r.Trades), 3)
+	}
+	if len(r.Transfers) != 1 {
+		t.Fatalf("got %d transfers, expected %d", len(r.Transfers), 1)
+	}
+	tests := []struct {
+		month  time.Month
+		sales  string
+		gain   string
+		exempt bool
+	}{
+		// Basis of 0.5 is 100000 * 0.5 / 0.997.
+		{time.February, "60000.00", "9429.55", false},
+		{time.March, "9000.00", "-1093.09", true},
+	}
+	if len(r.Months) != len(tests) {
+		t.Fatalf("got %d months, expected %d", len(r.Months), len(tests))
+	}
+	for i, tt := range tests {
+		m := r.Months[i]
+		if m.Month != tt.month {
+			t.Errorf("got month %v, expected %v", m.Month, tt.month)
+		}
+		if got := dec.Format(m.Sales, 2); got != tt.sales {
+			t.Errorf("%v: got sales %s, expected %s", tt.month, got, tt.sales)
+		}
+		if got := dec.Format(m.Gain, 2); got != tt.gain {
+			t.Errorf("%v: got gain %s, expected %s", tt.month, got, tt.gain)
+		}
+		if m.Exempt != tt.exempt {
+			t.Errorf("%v: got exempt %v, expected %v", tt.month, m.Exempt, tt.exempt)
+		}
+	}
+}
+
+func TestGenerateShortPosition(t *testing.T) {
+	_, err := Generate(testOrders[1:], nil)
+	if err == nil {
+		t.Error("sell without position should fail")
+	}
+}
+
+func TestWriteSummary(t *testing.T) {
+	r, err := Generate(testOrders, testWithdrawals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := r.WriteSummary(buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := "month,sales,gain,exempt,taxable_gain\n" +
+		"2020-02,60000.00,9429.55,false,9429.55\n" +
+		"2020-03,9000.00,-1093.09,true,0.00\n"
+	if buf.String() != expected {
+		t.Errorf("got summary:\n%s\nexpected:\n%s", buf, expected)
+	}
+}
+
+func TestWriteIN1888(t *testing.T) {
+	r, err := Generate(testOrders, testWithdrawals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	d := Declarant{CPF: "12345678909", Name: "Fulano de Tal"}
+	if err := r.WriteIN1888(buf, d, 2020, time.March); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"0000|12345678909|FULANO DE TAL|202003",
+		"0110|10032020|I|9000,00|63,00|BTC|0,1000000000",
+		"0410|11032020|V|0,0005000000|BTC|0,1000000000|1G38ybvfUyn96aJbKnzkifX2eEMH9N87ww",
+		"9999|1|9000,00|1",
+		"",
+	}
+	if got := buf.String(); got != strings.Join(expected, "\r\n") {
+		t.Errorf("got records:\n%s\nexpected:\n%s", got, strings.Join(expected, "\n"))
+	}
+}
+
+type fakeSource struct{}
+
+func (fakeSource) ListAllOrders(c1, c2 tapi.Coin, opts *tapi.ListOrdersOpts) ([]tapi.Order, error) {
+	if c2 != tapi.BTC {
+		return nil, nil
+	}
+	return testOrders, nil
+}
+
+func (fakeSource) GetWithdrawal(coin tapi.Coin, id int) (*tapi.Withdrawal, error) {
+	return &testWithdrawals[id-1], nil
+}
+
+func TestFetch(t *testing.T) {
+	refs := []WithdrawalRef{{tapi.BTC, 1}, {tapi.BRL, 3}}
+	orders, ws, err := Fetch(fakeSource{}, tapi.Coins, refs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != len(testOrders) {
+		t.Errorf("got %d orders, expected %d", len(orders), len(testOrders))
+	}
+	if len(ws) != len(refs) {
+		t.Errorf("got %d withdrawals, expected %d", len(ws), len(refs))
+	}
+}
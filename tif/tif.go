@@ -0,0 +1,222 @@
+// Package tif emulates time-in-force modes on the good-till-cancelled
+// limit orders of a tapi.API.
+//
+// IOC orders are cancelled right after placed, so only the part filled
+// on arrival is kept. FOK orders are only placed when the orderbook has
+// the depth to fill them, and are cancelled if not fully filled. GTD
+// orders are cancelled at a deadline. The final state of each order is
+// read with GetOrder.
+//
+// The emulation is not atomic: an IOC or FOK order may rest in the book
+// for the time of a request, and a FOK order may end partially filled if
+// the book changes between the check and the placement.
+package tif
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	tapi "github.com/rschio/mb-tapi"
+	"github.com/rschio/mb-tapi/internal/dec"
+)
+
+var (
+	// ErrNoDepth is returned when the orderbook has not enough depth
+	// to fill a FOK order. No order is placed.
+	ErrNoDepth = errors.New("tif: not enough depth to fill")
+
+	// ErrKilled is returned when a FOK order is not fully filled on
+	// arrival. The order is cancelled, but it may have partial fills.
+	ErrKilled = errors.New("tif: order not filled, cancelled")
+
+	// ErrDeadline is returned when a GTD deadline is not in the
+	// future.
+	ErrDeadline = errors.New("tif: deadline passed")
+)
+
+// API is a tapi.API with time-in-force order placement.
+type API struct {
+	tapi.API
+
+	done      func(*tapi.Order, error)
+	now       func() time.Time
+	afterFunc func(time.Duration, func()) *time.Timer
+
+	mu     sync.Mutex
+	timers map[int]*time.Timer
+}
+
+// New creates an API of api. The final state of the GTD orders
+// cancelled at the deadline is passed to done, if not nil.
+func New(api tapi.API, done func(*tapi.Order, error)) *API {
+	return &API{
+		API:       api,
+		done:      done,
+		now:       time.Now,
+		afterFunc: time.AfterFunc,
+		timers:    make(map[int]*time.Timer),
+	}
+}
+
+func (a *API) place(c1, c2 tapi.Coin, buy bool, qt, limit string) (*tapi.Order, error) {
+	if buy {
+		return a.API.PlaceBuyOrder(c1, c2, qt, limit)
+	}
+	return a.API.PlaceSellOrder(c1, c2, qt, limit)
+}
+
+// final cancels order id, if open, and returns its final state.
+func (a *API) final(c1, c2 tapi.Coin, o *tapi.Order) (*tapi.Order, error) {
+	if o.Status != tapi.OrderStatusOpen {
+		return o, nil
+	}
+	co, cerr := a.API.CancelOrder(c1, c2, o.ID)
+	// The cancellation fails if the order was filled meanwhile, and
+	// its response may not have the last fills.
+	g, err := a.API.GetOrder(c1, c2, o.ID)
+	if err != nil {
+		if cerr == nil {
+			return co, nil
+		}
+		return o, fmt.Errorf("tif: cancel order %d: %v", o.ID, cerr)
+	}
+	if g.Status == tapi.OrderStatusOpen {
+		if cerr == nil {
+			cerr = fmt.Errorf("order %d still open", o.ID)
+		}
+		return g, fmt.Errorf("tif: cancel order %d: %v", o.ID, cerr)
+	}
+	return g, nil
+}
+
+// PlaceIOC places an immediate-or-cancel order of quantity qt at the
+// limit price and cancels the remainder. The returned order is filled,
+// or cancelled with the quantity executed on arrival.
+func (a *API) PlaceIOC(c1, c2 tapi.Coin, buy bool, qt, limit string) (*tapi.Order, error) {
+	o, err := a.place(c1, c2, buy, qt, limit)
+	if err != nil {
+		return nil, err
+	}
+	return a.final(c1, c2, o)
+}
+
+// PlaceFOK places a fill-or-kill order of quantity qt at the limit
+// price. It fails with ErrNoDepth, without placing, if the orderbook
+// has not enough quantity of others at the limit price or better, and
+// with ErrKilled if the order is not fully filled on arrival. The own
+// orders can't fill it and are left out by IsOwner, so books without it,
+// as the v4 ones, count them.
+func (a *API) PlaceFOK(c1, c2 tapi.Coin, buy bool, qt, limit string) (*tapi.Order, error) {
+	q, err := dec.Parse(qt)
+	if err != nil {
+		return nil, err
+	}
+	l, err := dec.Parse(limit)
+	if err != nil {
+		return nil, err
+	}
+	ob, err := a.API.ListOrderbook(c1, c2, true)
+	if err != nil {
+		return nil, err
+	}
+	side := ob.Asks
+	if !buy {
+		side = ob.Bids
+	}
+	depth := dec.Zero()
+	for _, oi := range side {
+		if oi.IsOwner {
+			continue
+		}
+		p, err := dec.Parse(oi.LimitPrice)
+		if err != nil {
+			return nil, err
+		}
+		if (buy && p.Cmp(l) > 0) || (!buy && p.Cmp(l) < 0) {
+			continue
+		}
+		oq, err := dec.Parse(oi.Quantity)
+		if err != nil {
+			return nil, err
+		}
+		depth = dec.Add(depth, oq)
+	}
+	if depth.Cmp(q) < 0 {
+		return nil, fmt.Errorf("%w: %s of %s", ErrNoDepth, dec.Format(depth, 8), qt)
+	}
+
+	o, err := a.place(c1, c2, buy, qt, limit)
+	if err != nil {
+		return nil, err
+	}
+	o, err = a.final(c1, c2, o)
+	if err != nil {
+		return o, err
+	}
+	if o.Status != tapi.OrderStatusFilled {
+		return o, fmt.Errorf("%w: executed %s of %s", ErrKilled, o.ExecutedQuantity, qt)
+	}
+	return o, nil
+}
+
+// PlaceGTD places an order good till the deadline, when it is cancelled
+// and its final state passed to the done function of the API. The
+// deadline is kept in memory only, it is lost if the process exits.
+func (a *API) PlaceGTD(c1, c2 tapi.Coin, buy bool, qt, limit string, deadline time.Time) (*tapi.Order, error) {
+	d := deadline.Sub(a.now())
+	if d <= 0 {
+		return nil, ErrDeadline
+	}
+	o, err := a.place(c1, c2, buy, qt, limit)
+	if err != nil {
+		return nil, err
+	}
+	if o.Status != tapi.OrderStatusOpen {
+		return o, nil
+	}
+	id := o.ID
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.timers[id] = a.afterFunc(d, func() {
+		a.mu.Lock()
+		delete(a.timers, id)
+		a.mu.Unlock()
+		f, err := a.final(c1, c2, &tapi.Order{ID: id, Status: tapi.OrderStatusOpen})
+		if a.done != nil {
+			a.done(f, err)
+		}
+	})
+	return o, nil
+}
+
+// Pending returns the IDs of the GTD orders waiting for the deadline.
+func (a *API) Pending() []int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ids := make([]int, 0, len(a.timers))
+	for id := range a.timers {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Unschedule removes the deadline of the GTD order id, which stays
+// open. It reports whether the order had a deadline.
+func (a *API) Unschedule(id int) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	t, ok := a.timers[id]
+	if !ok {
+		return false
+	}
+	delete(a.timers, id)
+	return t.Stop()
+}
+
+// CancelOrder cancels an order and removes its deadline, if any.
+func (a *API) CancelOrder(c1, c2 tapi.Coin, id int) (*tapi.Order, error) {
+	a.Unschedule(id)
+	return a.API.CancelOrder(c1, c2, id)
+}
@@ -0,0 +1,150 @@
+package tif
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	tapi "github.com/rschio/mb-tapi"
+)
+
+// fakeAPI executes fill of every order on arrival.
+type fakeAPI struct {
+	tapi.API
+
+	book      tapi.Orderbook
+	fill      string
+	orders    map[int]*tapi.Order
+	cancelled []int
+}
+
+func (f *fakeAPI) ListOrderbook(c1, c2 tapi.Coin, full bool) (*tapi.Orderbook, error) {
+	return &f.book, nil
+}
+
+func (f *fakeAPI) PlaceBuyOrder(c1, c2 tapi.Coin, qt, limit string) (*tapi.Order, error) {
+	o := &tapi.Order{ID: len(f.orders) + 1, Type: tapi.OrderTypeBuy, Status: tapi.OrderStatusOpen,
+		Quantity: qt, LimitPrice: limit, ExecutedQuantity: f.fill}
+	if f.fill == qt {
+		o.Status = tapi.OrderStatusFilled
+	}
+	f.orders[o.ID] = o
+	c := *o
+	return &c, nil
+}
+
+func (f *fakeAPI) CancelOrder(c1, c2 tapi.Coin, id int) (*tapi.Order, error) {
+	o := f.orders[id]
+	if o.Status != tapi.OrderStatusOpen {
+		return nil, &tapi.Error{Code: 204, Err: "order already closed"}
+	}
+	f.cancelled = append(f.cancelled, id)
+	o.Status = tapi.OrderStatusCancelled
+	// The response is stale, without the status change.
+	return &tapi.Order{ID: id, Status: tapi.OrderStatusOpen}, nil
+}
+
+func (f *fakeAPI) GetOrder(c1, c2 tapi.Coin, id int) (*tapi.Order, error) {
+	c := *f.orders[id]
+	return &c, nil
+}
+
+func newFake(fill string) *fakeAPI {
+	return &fakeAPI{
+		fill:   fill,
+		orders: make(map[int]*tapi.Order),
+		book: tapi.Orderbook{Asks: []tapi.OrderInfo{
+			{Quantity: "1", LimitPrice: "99", IsOwner: true},
+			{Quantity: "0.5", LimitPrice: "100"},
+			{Quantity: "0.5", LimitPrice: "101"},
+			{Quantity: "5", LimitPrice: "110"},
+		}},
+	}
+}
+
+func TestIOC(t *testing.T) {
+	f := newFake("0.3")
+	o, err := New(f, nil).PlaceIOC(tapi.BRL, tapi.BTC, true, "1", "100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Status != tapi.OrderStatusCancelled || o.ExecutedQuantity != "0.3" || len(f.cancelled) != 1 {
+		t.Errorf("got %+v, expected cancelled with 0.3 executed", o)
+	}
+
+	f = newFake("1")
+	o, err = New(f, nil).PlaceIOC(tapi.BRL, tapi.BTC, true, "1", "100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Status != tapi.OrderStatusFilled || len(f.cancelled) != 0 {
+		t.Errorf("got %+v and %d cancelled, expected filled", o, len(f.cancelled))
+	}
+}
+
+func TestFOK(t *testing.T) {
+	tests := []struct {
+		qt, limit, fill string
+		err             error
+		placed          int
+	}{
+		{"1", "101", "1", nil, 1},
+		{"1.5", "101", "", ErrNoDepth, 0},
+		// The own orders do not count.
+		{"1", "100", "", ErrNoDepth, 0},
+		{"1", "101", "0.5", ErrKilled, 1},
+	}
+	for _, tt := range tests {
+		f := newFake(tt.fill)
+		o, err := New(f, nil).PlaceFOK(tapi.BRL, tapi.BTC, true, tt.qt, tt.limit)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s@%s: got error %v, expected %v", tt.qt, tt.limit, err, tt.err)
+		}
+		if len(f.orders) != tt.placed {
+			t.Errorf("%s@%s: got %d placed, expected %d", tt.qt, tt.limit, len(f.orders), tt.placed)
+		}
+		if tt.err == ErrKilled && o.Status != tapi.OrderStatusCancelled {
+			t.Errorf("%s@%s: got status %d, expected cancelled", tt.qt, tt.limit, o.Status)
+		}
+	}
+}
+
+func TestGTD(t *testing.T) {
+	f := newFake("0.1")
+	var final *tapi.Order
+	a := New(f, func(o *tapi.Order, err error) {
+		if err != nil {
+			t.Error(err)
+		}
+		final = o
+	})
+	now := time.Unix(1600000000, 0)
+	a.now = func() time.Time { return now }
+	var fire []func()
+	a.afterFunc = func(d time.Duration, fn func()) *time.Timer {
+		if d != time.Hour {
+			t.Errorf("got duration %v, expected 1h", d)
+		}
+		fire = append(fire, fn)
+		return time.NewTimer(time.Hour)
+	}
+
+	if _, err := a.PlaceGTD(tapi.BRL, tapi.BTC, true, "1", "100", now); err != ErrDeadline {
+		t.Errorf("got error %v, expected %v", err, ErrDeadline)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := a.PlaceGTD(tapi.BRL, tapi.BTC, true, "1", "100", now.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !a.Unschedule(2) || len(a.Pending()) != 1 {
+		t.Errorf("got pending %v, expected [1]", a.Pending())
+	}
+	fire[0]()
+	if final == nil || final.ID != 1 || final.Status != tapi.OrderStatusCancelled {
+		t.Errorf("got %+v, expected order 1 cancelled", final)
+	}
+	if len(a.Pending()) != 0 || f.orders[2].Status != tapi.OrderStatusOpen {
+		t.Errorf("got pending %v and order 2 %+v, expected none and open", a.Pending(), f.orders[2])
+	}
+}
@@ -1,6 +1,11 @@
 package tapi
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
 
 type Coin uint8
 
@@ -13,6 +18,63 @@ const (
 	ETH
 )
 
+// Coins contains all the coins supported by the API.
+var Coins = []Coin{BRL, BTC, LTC, BCH, XRP, ETH}
+
+// ParseCoin returns the Coin named s, such as "BTC".
+func ParseCoin(s string) (Coin, error) {
+	for _, c := range Coins {
+		if c.String() == s {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid coin %q", s)
+}
+
+// ParsePair splits a coin pair such as "BRLBTC" into its coins.
+func ParsePair(s string) (c1, c2 Coin, err error) {
+	if len(s) != 6 {
+		return 0, 0, fmt.Errorf("invalid coin pair %q", s)
+	}
+	if c1, err = ParseCoin(s[:3]); err != nil {
+		return 0, 0, err
+	}
+	if c2, err = ParseCoin(s[3:]); err != nil {
+		return 0, 0, err
+	}
+	return c1, c2, nil
+}
+
+// ParseTimestamp parses the unix timestamps, in seconds, returned by
+// the API.
+func ParseTimestamp(s string) (time.Time, error) {
+	sec, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+	}
+	return time.Unix(sec, 0), nil
+}
+
+// Order types.
+const (
+	OrderTypeBuy  = 1
+	OrderTypeSell = 2
+)
+
+// Order status.
+const (
+	OrderStatusOpen      = 2
+	OrderStatusCancelled = 3
+	OrderStatusFilled    = 4
+)
+
+// Withdrawal status.
+const (
+	WithdrawalStatusOpen      = 1
+	WithdrawalStatusDone      = 2
+	WithdrawalStatusCancelled = 3
+)
+
 type Response struct {
 	Data                json.RawMessage `json:"response_data"`
 	StatusCode          int             `json:"status_code"`
@@ -78,6 +140,9 @@ type Order struct {
 	CreatedTimestamp string      `json:"created_timestamp"`
 	UpdatedTimestamp string      `json:"updated_timestamp"`
 	Operations       []Operation `json:"operations"`
+
+	// Ref is the ID of the order in the v4 API, empty on v3.
+	Ref string `json:"ref,omitempty"`
 }
 
 type OrderInfo struct {
@@ -107,3 +172,41 @@ type Withdrawal struct {
 	CreatedTimestamp string `json:"created_timestamp"`
 	UpdatedTimestamp string `json:"updated_timestamp"`
 }
+
+// BalanceOf returns the balance of coin c.
+func (a *AccountInfo) BalanceOf(c Coin) Amount {
+	switch c {
+	case BRL:
+		return a.Balance.BRL
+	case BTC:
+		return a.Balance.BTC.Amount
+	case LTC:
+		return a.Balance.LTC.Amount
+	case BCH:
+		return a.Balance.BCH.Amount
+	case XRP:
+		return a.Balance.XRP.Amount
+	case ETH:
+		return a.Balance.ETH.Amount
+	}
+	return Amount{}
+}
+
+// WithdrawalLimit returns the withdrawal limit of coin c.
+func (a *AccountInfo) WithdrawalLimit(c Coin) Amount {
+	switch c {
+	case BRL:
+		return a.WithdrawalLimits.BRL
+	case BTC:
+		return a.WithdrawalLimits.BTC
+	case LTC:
+		return a.WithdrawalLimits.LTC
+	case BCH:
+		return a.WithdrawalLimits.BCH
+	case XRP:
+		return a.WithdrawalLimits.XRP
+	case ETH:
+		return a.WithdrawalLimits.ETH
+	}
+	return Amount{}
+}
@@ -0,0 +1,533 @@
+package tapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultServiceV4 is the default endpoint to the v4 REST API.
+const DefaultServiceV4 = "https://api.mercadobitcoin.net/api/v4"
+
+// ErrUnsupported is returned by the methods that the v4 API does not
+// have.
+var ErrUnsupported = errors.New("not supported by the v4 API")
+
+// ClientV4 is a client of the v4 REST API with the same methods as
+// Client. The credentials are exchanged for a bearer token that is
+// renewed when it expires or the credentials change.
+//
+// The v4 API identifies orders by strings, so ClientV4 gives them int
+// IDs as they are seen and keeps the string in the Ref of the Order. The
+// int IDs are only valid for the ClientV4 that returned them; orders
+// persisted across processes must be read and cancelled with GetOrderRef
+// and CancelOrderRef.
+//
+// A 5xx status or a failure to read the response is returned as an
+// *AmbiguousError, since the request may have been executed.
+type ClientV4 struct {
+	service string
+	client  *http.Client
+	creds   CredentialProvider
+
+	mu        sync.Mutex
+	accountID string
+	token     string
+	tokenID   string
+	expiry    time.Time
+	now       func() time.Time
+
+	orders idMap
+	ops    idMap
+
+	orderRules
+}
+
+var (
+	_ API        = (*ClientV4)(nil)
+	_ Normalizer = (*ClientV4)(nil)
+)
+
+// NewClientV4 creates a v4 client. An empty accountID uses the first
+// account of the credentials.
+func NewClientV4(service string, creds CredentialProvider, accountID string, client *http.Client) *ClientV4 {
+	c := &ClientV4{
+		service:   strings.TrimSuffix(service, "/"),
+		client:    client,
+		creds:     creds,
+		accountID: accountID,
+		now:       time.Now,
+	}
+	c.markets = DefaultMarkets
+	if c.client == nil {
+		c.client = http.DefaultClient
+	}
+	return c
+}
+
+// String describes the client without its credentials.
+func (c *ClientV4) String() string {
+	return fmt.Sprintf("tapi.ClientV4{service: %q}", c.service)
+}
+
+// GoString is like String, so %#v does not print the credentials.
+func (c *ClientV4) GoString() string {
+	return c.String()
+}
+
+// authorize returns a valid bearer token, asking a new one if force is
+// set, the token expired or the credentials changed.
+func (c *ClientV4) authorize(force bool) (string, error) {
+	creds, err := c.creds.Credentials()
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !force && c.token != "" && c.tokenID == creds.ID && c.now().Add(time.Minute).Before(c.expiry) {
+		return c.token, nil
+	}
+	body, err := json.Marshal(map[string]string{"login": creds.ID, "password": creds.Key})
+	if err != nil {
+		return "", err
+	}
+	r, err := http.NewRequest("POST", c.service+"/authorize", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	var auth v4Authorize
+	if err := c.send(r, &auth); err != nil {
+		return "", err
+	}
+	c.token, c.tokenID, c.expiry = auth.AccessToken, creds.ID, time.Unix(auth.Expiration, 0)
+	return c.token, nil
+}
+
+// do makes an authorized request to path and decodes the response into
+// out. A request rejected with 401 is retried once with a new token.
+func (c *ClientV4) do(method, path string, query url.Values, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	u := c.service + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	for retry := false; ; retry = true {
+		token, err := c.authorize(retry)
+		if err != nil {
+			return err
+		}
+		r, err := http.NewRequest(method, u, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		r.Header.Set("Authorization", "Bearer "+token)
+		if in != nil {
+			r.Header.Set("Content-Type", "application/json")
+		}
+		err = c.send(r, out)
+		var e *Error
+		if !retry && errors.As(err, &e) && e.Code == http.StatusUnauthorized {
+			continue
+		}
+		return err
+	}
+}
+
+func (c *ClientV4) send(r *http.Request, out interface{}) error {
+	resp, err := c.client.Do(r)
+	if err != nil {
+		return &AmbiguousError{Err: err}
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return &AmbiguousError{Err: err}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var e v4Error
+		json.Unmarshal(b, &e)
+		msg := e.Message
+		if msg == "" {
+			msg = http.StatusText(resp.StatusCode)
+		}
+		if e.Code != "" {
+			msg = e.Code + ": " + msg
+		}
+		if resp.StatusCode >= 500 {
+			return &AmbiguousError{Err: &Error{Code: resp.StatusCode, Err: msg}}
+		}
+		return &Error{Code: resp.StatusCode, Err: msg}
+	}
+	if out == nil {
+		return nil
+	}
+	if raw, ok := out.(*[]byte); ok {
+		*raw = b
+		return nil
+	}
+	return json.Unmarshal(b, out)
+}
+
+// account returns the account ID, reading the first account of the
+// credentials if it is not set.
+func (c *ClientV4) account() (string, error) {
+	c.mu.Lock()
+	id := c.accountID
+	c.mu.Unlock()
+	if id != "" {
+		return id, nil
+	}
+	var accs []v4Account
+	if err := c.do("GET", "/accounts", nil, nil, &accs); err != nil {
+		return "", err
+	}
+	if len(accs) == 0 {
+		return "", errors.New("v4: no accounts")
+	}
+	c.mu.Lock()
+	c.accountID = accs[0].ID
+	c.mu.Unlock()
+	return accs[0].ID, nil
+}
+
+// ListSystemMessages is not supported by the v4 API.
+func (c *ClientV4) ListSystemMessages(lvl string) ([]SystemMessage, error) {
+	return nil, ErrUnsupported
+}
+
+// GetAccountInfo returns the balances of the account. The v4 API does
+// not report the withdrawal limits, so WithdrawalLimits is empty and the
+// amount of open orders is zero.
+func (c *ClientV4) GetAccountInfo() (*AccountInfo, error) {
+	acc, err := c.account()
+	if err != nil {
+		return nil, err
+	}
+	var b []byte
+	if err := c.do("GET", "/accounts/"+acc+"/balances", nil, nil, &b); err != nil {
+		return nil, err
+	}
+	return unmarshalV4Balances(b)
+}
+
+func (c *ClientV4) orderRef(id int) (string, error) {
+	ref, ok := c.orders.ref(id)
+	if !ok {
+		return "", fmt.Errorf("v4: unknown order id %d, use the Ref of the order", id)
+	}
+	return ref, nil
+}
+
+func (c *ClientV4) getOrder(c1, c2 Coin, ref string) (*Order, error) {
+	acc, err := c.account()
+	if err != nil {
+		return nil, err
+	}
+	var o v4Order
+	path := "/accounts/" + acc + "/" + v4Symbol(c1, c2) + "/orders/" + url.PathEscape(ref)
+	if err := c.do("GET", path, nil, nil, &o); err != nil {
+		return nil, err
+	}
+	return v4ToOrder(&o, &c.orders, &c.ops)
+}
+
+// GetOrder returns the order data according to the given id.
+func (c *ClientV4) GetOrder(c1, c2 Coin, id int) (*Order, error) {
+	ref, err := c.orderRef(id)
+	if err != nil {
+		return nil, err
+	}
+	return c.getOrder(c1, c2, ref)
+}
+
+// GetOrderRef returns the order data according to the v4 id ref, the
+// Ref of an Order.
+func (c *ClientV4) GetOrderRef(c1, c2 Coin, ref string) (*Order, error) {
+	return c.getOrder(c1, c2, ref)
+}
+
+// ListOrders returns the orders filtered by opts, sorted by Ref, which
+// is the creation order. The pages of the v4 API are all read, following
+// the id_to cursor with the oldest order of each page. FromID and ToID
+// filter the int IDs given by this client, so they only match the IDs it
+// returned before.
+func (c *ClientV4) ListOrders(c1, c2 Coin, opts *ListOrdersOpts) ([]Order, error) {
+	acc, err := c.account()
+	if err != nil {
+		return nil, err
+	}
+	q := make(url.Values)
+	if opts != nil {
+		switch t := opts.OrderType; {
+		case t < 0:
+			q.Set("side", "sell")
+		case t > 0:
+			q.Set("side", "buy")
+		}
+		var status []string
+		for i, s := range []string{"working", "cancelled", "filled"} {
+			if opts.StatusList[i] != 0 {
+				status = append(status, s)
+			}
+		}
+		if len(status) > 0 {
+			q.Set("status", strings.Join(status, ","))
+		}
+		switch h := opts.HasFills; {
+		case h < 0:
+			q.Set("has_executions", "false")
+		case h > 0:
+			q.Set("has_executions", "true")
+		}
+		from, to := opts.timestamps(0)
+		if from != "" {
+			q.Set("created_at_from", from)
+		}
+		if to != "" {
+			q.Set("created_at_to", to)
+		}
+	}
+	var list []v4Order
+	seen := make(map[v4Num]bool)
+	for {
+		var page []v4Order
+		if err := c.do("GET", "/accounts/"+acc+"/"+v4Symbol(c1, c2)+"/orders", q, nil, &page); err != nil {
+			return nil, err
+		}
+		// The cursor is inclusive, so a page without new orders is
+		// the last one.
+		var oldest v4Num
+		for _, o := range page {
+			if seen[o.ID] {
+				continue
+			}
+			seen[o.ID] = true
+			list = append(list, o)
+			if oldest == "" || o.ID < oldest {
+				oldest = o.ID
+			}
+		}
+		if oldest == "" {
+			break
+		}
+		q.Set("id_to", string(oldest))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	orders := make([]Order, 0, len(list))
+	for i := range list {
+		o, err := v4ToOrder(&list[i], &c.orders, &c.ops)
+		if err != nil {
+			return nil, err
+		}
+		if opts != nil && (opts.FromID != 0 && o.ID < opts.FromID || opts.ToID != 0 && o.ID > opts.ToID) {
+			continue
+		}
+		orders = append(orders, *o)
+	}
+	return orders, nil
+}
+
+// ListOrderbook returns the order book of the pair c1 and c2. The v4
+// order book has no order IDs, so OrderID, IsOwner and LatestOrderID are
+// zero.
+func (c *ClientV4) ListOrderbook(c1, c2 Coin, full bool) (*Orderbook, error) {
+	q := make(url.Values)
+	if !full {
+		q.Set("limit", "20")
+	}
+	var b []byte
+	if err := c.do("GET", "/"+v4Symbol(c1, c2)+"/orderbook", q, nil, &b); err != nil {
+		return nil, err
+	}
+	return unmarshalV4Orderbook(b)
+}
+
+type v4PlaceOrder struct {
+	Side       string      `json:"side"`
+	Type       string      `json:"type"`
+	Qty        string      `json:"qty,omitempty"`
+	LimitPrice json.Number `json:"limitPrice,omitempty"`
+	Cost       json.Number `json:"cost,omitempty"`
+}
+
+func (c *ClientV4) placeOrder(c1, c2 Coin, p v4PlaceOrder) (*Order, error) {
+	acc, err := c.account()
+	if err != nil {
+		return nil, err
+	}
+	var resp v4PlaceOrderResponse
+	if err := c.do("POST", "/accounts/"+acc+"/"+v4Symbol(c1, c2)+"/orders", nil, p, &resp); err != nil {
+		return nil, err
+	}
+	ref := string(resp.OrderID)
+	if o, err := c.getOrder(c1, c2, ref); err == nil {
+		return o, nil
+	}
+	// The order was placed even if it can't be read now, so it is
+	// returned as open with the params of the request.
+	o := &Order{
+		ID:         c.orders.id(ref),
+		Ref:        ref,
+		CoinPair:   c1.String() + c2.String(),
+		Type:       OrderTypeSell,
+		Status:     OrderStatusOpen,
+		Quantity:   p.Qty,
+		LimitPrice: string(p.LimitPrice),
+		Operations: []Operation{},
+	}
+	if p.Side == "buy" {
+		o.Type = OrderTypeBuy
+	}
+	return o, nil
+}
+
+// PlaceBuyOrder opens a buy order of coin pair c1 and c2 with quantity qt of
+// digital coin and unit limit price limit.
+func (c *ClientV4) PlaceBuyOrder(c1, c2 Coin, qt, limit string) (*Order, error) {
+	qt, limit, _, err := c.checkOrder(c1, c2, true, qt, limit, "")
+	if err != nil {
+		return nil, err
+	}
+	return c.placeOrder(c1, c2, v4PlaceOrder{Side: "buy", Type: "limit", Qty: qt, LimitPrice: json.Number(limit)})
+}
+
+// PlaceSellOrder opens a sell order of coin pair c1 and c2 with quantity qt of
+// digital coin and unit limit price limit.
+func (c *ClientV4) PlaceSellOrder(c1, c2 Coin, qt, limit string) (*Order, error) {
+	qt, limit, _, err := c.checkOrder(c1, c2, false, qt, limit, "")
+	if err != nil {
+		return nil, err
+	}
+	return c.placeOrder(c1, c2, v4PlaceOrder{Side: "sell", Type: "limit", Qty: qt, LimitPrice: json.Number(limit)})
+}
+
+// PlaceMarketBuyOrder opens a buy order of coin pair c1 and c2 with limit
+// volume cost in BRL.
+func (c *ClientV4) PlaceMarketBuyOrder(c1, c2 Coin, cost string) (*Order, error) {
+	_, _, cost, err := c.checkOrder(c1, c2, true, "", "", cost)
+	if err != nil {
+		return nil, err
+	}
+	return c.placeOrder(c1, c2, v4PlaceOrder{Side: "buy", Type: "market", Cost: json.Number(cost)})
+}
+
+// PlaceMarketSellOrder opens a sell order of coin pair c1 and c2 with qt
+// quantity of digital coin.
+func (c *ClientV4) PlaceMarketSellOrder(c1, c2 Coin, qt string) (*Order, error) {
+	qt, _, _, err := c.checkOrder(c1, c2, false, qt, "", "")
+	if err != nil {
+		return nil, err
+	}
+	return c.placeOrder(c1, c2, v4PlaceOrder{Side: "sell", Type: "market", Qty: qt})
+}
+
+// CancelOrder cancels a buy or sell order by coin pair and id of order.
+func (c *ClientV4) CancelOrder(c1, c2 Coin, id int) (*Order, error) {
+	ref, err := c.orderRef(id)
+	if err != nil {
+		return nil, err
+	}
+	return c.CancelOrderRef(c1, c2, ref)
+}
+
+// CancelOrderRef cancels an order by coin pair and v4 id ref, the Ref of
+// an Order.
+func (c *ClientV4) CancelOrderRef(c1, c2 Coin, ref string) (*Order, error) {
+	acc, err := c.account()
+	if err != nil {
+		return nil, err
+	}
+	path := "/accounts/" + acc + "/" + v4Symbol(c1, c2) + "/orders/" + url.PathEscape(ref)
+	if err := c.do("DELETE", path, nil, nil, nil); err != nil {
+		return nil, err
+	}
+	return c.getOrder(c1, c2, ref)
+}
+
+// GetWithdrawal returns the data of a transfer of digital coin or
+// a withdrawal of BRL.
+func (c *ClientV4) GetWithdrawal(coin Coin, id int) (*Withdrawal, error) {
+	acc, err := c.account()
+	if err != nil {
+		return nil, err
+	}
+	var b []byte
+	path := "/accounts/" + acc + "/wallet/" + coin.String() + "/withdraw/" + strconv.Itoa(id)
+	if err := c.do("GET", path, nil, nil, &b); err != nil {
+		return nil, err
+	}
+	return unmarshalV4Withdrawal(b)
+}
+
+// WithdrawBRL requests a BRL withdrawal to the registered account
+// accRef.
+func (c *ClientV4) WithdrawBRL(desc, qt, accRef string) (*Withdrawal, error) {
+	return c.withdraw(BRL, map[string]interface{}{
+		"quantity":    qt,
+		"account_ref": accRef,
+		"description": desc,
+	})
+}
+
+// WithdrawCrypto requests a digital coin transfer. The address is
+// validated with ValidateAddress before the request is made. The v4 API
+// has no TxNotAggregate and ViaBlockchain, so setting them fails with
+// ErrUnsupported.
+func (c *ClientV4) WithdrawCrypto(coin Coin, desc string, i *WithdrawInfo) (*Withdrawal, error) {
+	if i == nil {
+		return nil, errors.New("nil WithdrawInfo")
+	}
+	if i.TxNotAggregate || i.ViaBlockchain {
+		return nil, fmt.Errorf("TxNotAggregate and ViaBlockchain: %w", ErrUnsupported)
+	}
+	if coin == BRL {
+		return nil, errors.New("use WithdrawBRL for BRL")
+	}
+	if err := ValidateAddress(coin, i.Address); err != nil {
+		return nil, err
+	}
+	body := map[string]interface{}{
+		"address":     i.Address,
+		"quantity":    i.Quantity,
+		"description": desc,
+	}
+	if i.TxFee != "" {
+		body["tx_fee"] = i.TxFee
+	}
+	if coin == XRP {
+		if err := validateDestinationTag(i.Address, i.DestinationTag); err != nil {
+			return nil, err
+		}
+		body["destination_tag"] = i.DestinationTag
+	}
+	return c.withdraw(coin, body)
+}
+
+func (c *ClientV4) withdraw(coin Coin, body map[string]interface{}) (*Withdrawal, error) {
+	acc, err := c.account()
+	if err != nil {
+		return nil, err
+	}
+	var b []byte
+	if err := c.do("POST", "/accounts/"+acc+"/wallet/"+coin.String()+"/withdraw", nil, body, &b); err != nil {
+		return nil, err
+	}
+	return unmarshalV4Withdrawal(b)
+}
@@ -0,0 +1,423 @@
+package tapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const v4OrderJSON = `{
+	"id": "01FCN7ZQ4TP1SCM2ZN1TW3KQXC",
+	"instrument": "BTC-BRL",
+	"side": "buy",
+	"type": "limit",
+	"status": "filled",
+	"qty": "0.001",
+	"filledQty": "0.001",
+	"limitPrice": 250000.5,
+	"avgPrice": 249000,
+	"fee": "0.000003",
+	"created_at": 1628518000,
+	"updated_at": 1628518100,
+	"executions": [{
+		"id": "exec-1",
+		"instrument": "BTC-BRL",
+		"price": 249000,
+		"qty": "0.001",
+		"side": "buy",
+		"fee_rate": "0.30",
+		"liquidity": "maker",
+		"executed_at": 1628518100
+	}]
+}`
+
+const v4OrderbookJSON = `{
+	"asks": [["250100.00000", "0.50000000"], ["250200.00000", "1.20000000"]],
+	"bids": [["249900.00000", "0.30000000"]],
+	"timestamp": 1628518000
+}`
+
+const v4BalancesJSON = `[
+	{"symbol": "BRL", "available": "1000.50", "on_hold": "10.00", "total": "1010.50"},
+	{"symbol": "BTC", "available": "0.5", "on_hold": "0", "total": "0.5"},
+	{"symbol": "USDC", "available": "1", "on_hold": "0", "total": "1"}
+]`
+
+const v4WithdrawalJSON = `{
+	"id": 27,
+	"coin": "XRP",
+	"quantity": "10.00000000",
+	"net_quantity": "9.99000000",
+	"fee": "0.01000000",
+	"address": "rPT1Sjq2YGrBMTttX4GZHjKu9dyfzbpAYe",
+	"destination_tag": 42,
+	"status": 2,
+	"tx": "ABC",
+	"created_at": "1628518000",
+	"updated_at": "1628518100"
+}`
+
+type v4Server struct {
+	authCalls int
+	expired   bool
+	placed    map[string]interface{}
+	query     string
+	cancelled bool
+	getFails  bool
+	status    int
+	refs      []string // listed orders, pages of 2 newest first.
+}
+
+func (s *v4Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/authorize" {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["login"] != "id" || body["password"] != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"code":"AUTH|INVALID","message":"invalid login"}`))
+			return
+		}
+		s.authCalls++
+		s.expired = false
+		w.Write([]byte(`{"access_token":"tok","expiration":9999999999}`))
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer tok" || s.expired {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if s.status != 0 {
+		w.WriteHeader(s.status)
+		return
+	}
+	switch p := r.URL.Path; {
+	case p == "/accounts":
+		w.Write([]byte(`[{"id":"acc1","name":"main"}]`))
+	case p == "/accounts/acc1/balances":
+		w.Write([]byte(v4BalancesJSON))
+	case p == "/BTC-BRL/orderbook":
+		w.Write([]byte(v4OrderbookJSON))
+	case p == "/accounts/acc1/BTC-BRL/orders" && r.Method == "POST":
+		s.placed = nil
+		json.NewDecoder(r.Body).Decode(&s.placed)
+		w.Write([]byte(`{"orderId":"01FCN7ZQ4TP1SCM2ZN1TW3KQXC"}`))
+	case p == "/accounts/acc1/BTC-BRL/orders" && s.refs != nil:
+		s.query = r.URL.RawQuery
+		to := r.URL.Query().Get("id_to")
+		var page []string
+		for i := len(s.refs) - 1; i >= 0 && len(page) < 2; i-- {
+			if to == "" || s.refs[i] <= to {
+				page = append(page, strings.Replace(v4OrderJSON, "01FCN7ZQ4TP1SCM2ZN1TW3KQXC", s.refs[i], 1))
+			}
+		}
+		w.Write([]byte("[" + strings.Join(page, ",") + "]"))
+	case p == "/accounts/acc1/BTC-BRL/orders":
+		if s.query == "" {
+			s.query = r.URL.RawQuery
+		}
+		w.Write([]byte("[" + v4OrderJSON + "]"))
+	case p == "/accounts/acc1/BTC-BRL/orders/01FCN7ZQ4TP1SCM2ZN1TW3KQXC" && r.Method == "DELETE":
+		s.cancelled = true
+		w.Write([]byte(`{"status":"cancelled"}`))
+	case p == "/accounts/acc1/BTC-BRL/orders/01FCN7ZQ4TP1SCM2ZN1TW3KQXC" && !s.getFails:
+		w.Write([]byte(v4OrderJSON))
+	case strings.HasPrefix(p, "/accounts/acc1/wallet/XRP/withdraw"):
+		w.Write([]byte(v4WithdrawalJSON))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"code":"NOT_FOUND","message":"not found"}`))
+	}
+}
+
+func newTestV4(t *testing.T) (*ClientV4, *v4Server, func()) {
+	s := &v4Server{}
+	srv := httptest.NewServer(s)
+	api, err := Open(Config{
+		Version:     V4,
+		Service:     srv.URL,
+		Credentials: NewStaticProvider("id", "secret"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return api.(*ClientV4), s, srv.Close
+}
+
+func TestV4Order(t *testing.T) {
+	c, s, done := newTestV4(t)
+	defer done()
+	orders, err := c.ListOrders(BRL, BTC, &ListOrdersOpts{OrderType: 1, StatusList: [3]int{0, 1, 1}, HasFills: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.query != "has_executions=true&side=buy&status=cancelled%2Cfilled" {
+		t.Errorf("got query %s", s.query)
+	}
+	expected := Order{
+		ID:               1,
+		CoinPair:         "BRLBTC",
+		Type:             OrderTypeBuy,
+		Status:           OrderStatusFilled,
+		HasFills:         true,
+		Quantity:         "0.001",
+		LimitPrice:       "250000.5",
+		ExecutedQuantity: "0.001",
+		ExecutedPriceAvg: "249000",
+		Fee:              "0.000003",
+		CreatedTimestamp: "1628518000",
+		UpdatedTimestamp: "1628518100",
+		Operations: []Operation{{
+			ID:                1,
+			Quantity:          "0.001",
+			Price:             "249000",
+			FeeRate:           "0.30",
+			ExecutedTimestamp: "1628518100",
+		}},
+		Ref: "01FCN7ZQ4TP1SCM2ZN1TW3KQXC",
+	}
+	if len(orders) != 1 || !reflect.DeepEqual(orders[0], expected) {
+		t.Fatalf("got %+v, expected %+v", orders, expected)
+	}
+	o, err := c.GetOrder(BRL, BTC, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*o, expected) {
+		t.Errorf("got %+v, expected %+v", *o, expected)
+	}
+	if _, err := c.GetOrder(BRL, BTC, 2); err == nil {
+		t.Error("expected error of unknown order id")
+	}
+	if orders, err := c.ListOrders(BRL, BTC, &ListOrdersOpts{FromID: 2}); err != nil || len(orders) != 0 {
+		t.Errorf("got %d orders and error %v from id 2, expected none", len(orders), err)
+	}
+
+	// Another client reads the order by its Ref.
+	c2, _, done2 := newTestV4(t)
+	defer done2()
+	if o, err := c2.GetOrderRef(BRL, BTC, expected.Ref); err != nil || o.Ref != expected.Ref {
+		t.Errorf("got %+v and error %v, expected order %s", o, err, expected.Ref)
+	}
+
+	o, err = c.PlaceBuyOrder(BRL, BTC, "0.001", "250000.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.ID != 1 {
+		t.Errorf("got id %d, expected 1", o.ID)
+	}
+	placed := map[string]interface{}{"side": "buy", "type": "limit", "qty": "0.001", "limitPrice": 250000.5}
+	if !reflect.DeepEqual(s.placed, placed) {
+		t.Errorf("got body %v, expected %v", s.placed, placed)
+	}
+	if _, err := c.PlaceMarketBuyOrder(BRL, BTC, "100"); err != nil {
+		t.Fatal(err)
+	}
+	placed = map[string]interface{}{"side": "buy", "type": "market", "cost": float64(100)}
+	if !reflect.DeepEqual(s.placed, placed) {
+		t.Errorf("got body %v, expected %v", s.placed, placed)
+	}
+	// The order is returned even if it can't be read after placed.
+	s.getFails = true
+	o, err = c.PlaceSellOrder(BRL, BTC, "0.002", "251000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.ID != 1 || o.Ref != expected.Ref || o.Type != OrderTypeSell || o.Status != OrderStatusOpen || o.Quantity != "0.002" {
+		t.Errorf("got %+v, expected open sell order %s", o, expected.Ref)
+	}
+	s.getFails = false
+
+	if _, err := c.CancelOrder(BRL, BTC, 1); err != nil || !s.cancelled {
+		t.Errorf("got error %v and cancelled %v, expected cancelled", err, s.cancelled)
+	}
+	if _, err := c.ListOrderbook(BRL, ETH, true); !errors.Is(err, &Error{Code: 404}) {
+		t.Errorf("got error %v, expected 404", err)
+	}
+}
+
+func TestV4ListOrdersPages(t *testing.T) {
+	c, s, done := newTestV4(t)
+	defer done()
+	s.refs = []string{"01A", "01B", "01C", "01D", "01E"}
+	var refs []string
+	err := WalkOrders(c, BRL, BTC, nil, func(orders []Order) error {
+		for _, o := range orders {
+			refs = append(refs, o.Ref)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(refs, s.refs) {
+		t.Errorf("got refs %v, expected %v", refs, s.refs)
+	}
+	if s.query != "id_to=01A" {
+		t.Errorf("got last query %s, expected id_to=01A", s.query)
+	}
+	orders, err := c.ListOrders(BRL, BTC, &ListOrdersOpts{FromID: 2, ToID: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 2 || orders[0].Ref != "01B" || orders[1].Ref != "01C" {
+		t.Errorf("got %+v, expected orders 01B and 01C", orders)
+	}
+}
+
+func TestV4Ambiguous(t *testing.T) {
+	c, s, done := newTestV4(t)
+	defer done()
+	if _, err := c.GetAccountInfo(); err != nil {
+		t.Fatal(err)
+	}
+	s.status = http.StatusBadGateway
+	_, err := c.PlaceBuyOrder(BRL, BTC, "0.001", "250000")
+	if !Ambiguous(err) || !errors.Is(err, &Error{Code: http.StatusBadGateway}) {
+		t.Errorf("got error %v, expected ambiguous 502", err)
+	}
+	s.status = 0
+	if _, err := c.ListOrderbook(BRL, ETH, true); Ambiguous(err) {
+		t.Errorf("got ambiguous error %v on 404", err)
+	}
+	done()
+	if _, err := c.GetOrderRef(BRL, BTC, "01A"); !Ambiguous(err) {
+		t.Errorf("got error %v on a closed server, expected ambiguous", err)
+	}
+}
+
+func TestV4Orderbook(t *testing.T) {
+	c, _, done := newTestV4(t)
+	defer done()
+	b, err := c.ListOrderbook(BRL, BTC, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Orderbook{
+		Asks: []OrderInfo{
+			{LimitPrice: "250100.00000", Quantity: "0.50000000"},
+			{LimitPrice: "250200.00000", Quantity: "1.20000000"},
+		},
+		Bids: []OrderInfo{{LimitPrice: "249900.00000", Quantity: "0.30000000"}},
+	}
+	if !reflect.DeepEqual(b, expected) {
+		t.Errorf("got %+v, expected %+v", b, expected)
+	}
+}
+
+func TestV4AccountInfo(t *testing.T) {
+	c, _, done := newTestV4(t)
+	defer done()
+	info, err := c.GetAccountInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := info.BalanceOf(BRL); b.Available != "1000.50" || b.Total != "1010.50" {
+		t.Errorf("got BRL %+v", b)
+	}
+	if b := info.BalanceOf(BTC); b.Available != "0.5" || b.Total != "0.5" {
+		t.Errorf("got BTC %+v", b)
+	}
+}
+
+func TestV4Withdrawal(t *testing.T) {
+	c, _, done := newTestV4(t)
+	defer done()
+	expected := &Withdrawal{
+		ID:               27,
+		Coin:             "XRP",
+		Quantity:         "10.00000000",
+		NetQuantity:      "9.99000000",
+		Fee:              "0.01000000",
+		Address:          "rPT1Sjq2YGrBMTttX4GZHjKu9dyfzbpAYe",
+		DestinationTag:   42,
+		Status:           WithdrawalStatusDone,
+		Tx:               "ABC",
+		CreatedTimestamp: "1628518000",
+		UpdatedTimestamp: "1628518100",
+	}
+	w, err := c.GetWithdrawal(XRP, 27)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(w, expected) {
+		t.Errorf("got %+v, expected %+v", w, expected)
+	}
+	i := &WithdrawInfo{Address: expected.Address, Quantity: "10", DestinationTag: 42}
+	if _, err := c.WithdrawCrypto(XRP, "", i); err != nil {
+		t.Error(err)
+	}
+	i.ViaBlockchain = true
+	if _, err := c.WithdrawCrypto(XRP, "", i); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("got error %v, expected %v", err, ErrUnsupported)
+	}
+	i.ViaBlockchain = false
+	i.Address = "rInvalid"
+	var aerr *AddressError
+	if _, err := c.WithdrawCrypto(XRP, "", i); !errors.As(err, &aerr) {
+		t.Errorf("got error %v, expected *AddressError", err)
+	}
+}
+
+func TestV4Token(t *testing.T) {
+	c, s, done := newTestV4(t)
+	defer done()
+	if _, err := c.GetAccountInfo(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetAccountInfo(); err != nil {
+		t.Fatal(err)
+	}
+	if s.authCalls != 1 {
+		t.Errorf("got %d authorizations, expected 1", s.authCalls)
+	}
+	s.expired = true
+	if _, err := c.GetAccountInfo(); err != nil {
+		t.Fatal(err)
+	}
+	if s.authCalls != 2 {
+		t.Errorf("got %d authorizations, expected 2", s.authCalls)
+	}
+	c.creds = NewStaticProvider("id", "wrong")
+	c.token = ""
+	if _, err := c.GetAccountInfo(); !errors.Is(err, &Error{Code: 401}) {
+		t.Errorf("got error %v, expected 401", err)
+	}
+	if _, err := c.ListSystemMessages(""); err != ErrUnsupported {
+		t.Errorf("got error %v, expected %v", err, ErrUnsupported)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	p := NewStaticProvider("id", "key")
+	api, err := Open(Config{Credentials: p})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := api.(*Client); !ok {
+		t.Errorf("got %T, expected *Client", api)
+	}
+	if _, err := Open(Config{Version: 5, Credentials: p}); err == nil {
+		t.Error("expected error of invalid version")
+	}
+	if _, err := Open(Config{Version: V4}); err == nil {
+		t.Error("expected error of nil credentials")
+	}
+
+	// The market rules are checked by the v4 client too.
+	api, err = Open(Config{Version: V4, Credentials: p, Options: []Option{WithMarkets(DefaultMarkets)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var verr *ValidationError
+	if _, err := api.PlaceBuyOrder(BRL, BTC, "0.0001", "250000"); !errors.As(err, &verr) {
+		t.Errorf("got error %v, expected *ValidationError", err)
+	}
+	if _, err := Open(Config{Version: V4, Credentials: p, Options: []Option{WithClock(NewClock(ClockConfig{}))}}); err == nil {
+		t.Error("expected error of option not supported by v4")
+	}
+}
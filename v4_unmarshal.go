@@ -0,0 +1,274 @@
+package tapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// v4Num is a decimal that the v4 API sends either as a JSON string or
+// as a JSON number. It keeps the exact text.
+type v4Num string
+
+func (n *v4Num) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	switch {
+	case s == "null":
+		*n = ""
+	case strings.HasPrefix(s, `"`):
+		u, err := strconv.Unquote(s)
+		if err != nil {
+			return err
+		}
+		*n = v4Num(u)
+	default:
+		*n = v4Num(s)
+	}
+	return nil
+}
+
+type v4Authorize struct {
+	AccessToken string `json:"access_token"`
+	Expiration  int64  `json:"expiration"`
+}
+
+type v4Account struct {
+	ID string `json:"id"`
+}
+
+type v4Balance struct {
+	Symbol    string `json:"symbol"`
+	Available v4Num  `json:"available"`
+	OnHold    v4Num  `json:"on_hold"`
+	Total     v4Num  `json:"total"`
+}
+
+type v4Execution struct {
+	ID         v4Num  `json:"id"`
+	Price      v4Num  `json:"price"`
+	Qty        v4Num  `json:"qty"`
+	FeeRate    v4Num  `json:"fee_rate"`
+	ExecutedAt int64  `json:"executed_at"`
+	Liquidity  string `json:"liquidity"`
+}
+
+type v4Order struct {
+	ID         v4Num         `json:"id"`
+	Instrument string        `json:"instrument"`
+	Side       string        `json:"side"`
+	Type       string        `json:"type"`
+	Status     string        `json:"status"`
+	Qty        v4Num         `json:"qty"`
+	FilledQty  v4Num         `json:"filledQty"`
+	LimitPrice v4Num         `json:"limitPrice"`
+	AvgPrice   v4Num         `json:"avgPrice"`
+	Fee        v4Num         `json:"fee"`
+	CreatedAt  int64         `json:"created_at"`
+	UpdatedAt  int64         `json:"updated_at"`
+	Executions []v4Execution `json:"executions"`
+}
+
+type v4PlaceOrderResponse struct {
+	OrderID v4Num `json:"orderId"`
+}
+
+type v4Orderbook struct {
+	Asks [][]v4Num `json:"asks"`
+	Bids [][]v4Num `json:"bids"`
+}
+
+type v4Withdrawal struct {
+	ID             v4Num  `json:"id"`
+	Coin           string `json:"coin"`
+	Quantity       v4Num  `json:"quantity"`
+	NetQuantity    v4Num  `json:"net_quantity"`
+	Fee            v4Num  `json:"fee"`
+	Account        string `json:"account"`
+	Address        string `json:"address"`
+	Status         int    `json:"status"`
+	Tx             string `json:"tx"`
+	DestinationTag v4Num  `json:"destination_tag"`
+	CreatedAt      v4Num  `json:"created_at"`
+	UpdatedAt      v4Num  `json:"updated_at"`
+}
+
+type v4Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// idMap gives int IDs, as used by the v3 types, to the string IDs of
+// the v4 API. The IDs are only valid for the ClientV4 that created them.
+type idMap struct {
+	mu    sync.Mutex
+	toInt map[string]int
+	toStr map[int]string
+}
+
+func (m *idMap) id(s string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.toInt == nil {
+		m.toInt = make(map[string]int)
+		m.toStr = make(map[int]string)
+	}
+	if id, ok := m.toInt[s]; ok {
+		return id
+	}
+	id := len(m.toInt) + 1
+	m.toInt[s] = id
+	m.toStr[id] = s
+	return id
+}
+
+func (m *idMap) ref(id int) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.toStr[id]
+	return s, ok
+}
+
+// v4Symbol returns the v4 instrument of the pair c1 and c2, such as
+// "BTC-BRL".
+func v4Symbol(c1, c2 Coin) string {
+	return c2.String() + "-" + c1.String()
+}
+
+// v4ToOrder maps o to an Order with the IDs of orders and ops.
+func v4ToOrder(o *v4Order, orders, ops *idMap) (*Order, error) {
+	pair := strings.Split(o.Instrument, "-")
+	if len(pair) != 2 {
+		return nil, fmt.Errorf("invalid instrument %q", o.Instrument)
+	}
+	order := &Order{
+		ID:               orders.id(string(o.ID)),
+		Ref:              string(o.ID),
+		CoinPair:         pair[1] + pair[0],
+		Quantity:         string(o.Qty),
+		LimitPrice:       string(o.LimitPrice),
+		ExecutedQuantity: string(o.FilledQty),
+		ExecutedPriceAvg: string(o.AvgPrice),
+		Fee:              string(o.Fee),
+		CreatedTimestamp: strconv.FormatInt(o.CreatedAt, 10),
+		UpdatedTimestamp: strconv.FormatInt(o.UpdatedAt, 10),
+		Operations:       []Operation{},
+	}
+	switch o.Side {
+	case "buy":
+		order.Type = OrderTypeBuy
+	case "sell":
+		order.Type = OrderTypeSell
+	default:
+		return nil, fmt.Errorf("invalid order side %q", o.Side)
+	}
+	switch o.Status {
+	case "filled":
+		order.Status = OrderStatusFilled
+	case "cancelled":
+		order.Status = OrderStatusCancelled
+	default:
+		order.Status = OrderStatusOpen
+	}
+	for _, e := range o.Executions {
+		order.Operations = append(order.Operations, Operation{
+			ID:                ops.id(string(e.ID)),
+			Quantity:          string(e.Qty),
+			Price:             string(e.Price),
+			FeeRate:           string(e.FeeRate),
+			ExecutedTimestamp: strconv.FormatInt(e.ExecutedAt, 10),
+		})
+	}
+	order.HasFills = len(order.Operations) > 0
+	return order, nil
+}
+
+func v4OrderbookInfo(levels [][]v4Num) ([]OrderInfo, error) {
+	out := make([]OrderInfo, 0, len(levels))
+	for _, l := range levels {
+		if len(l) < 2 {
+			return nil, fmt.Errorf("invalid orderbook level %v", l)
+		}
+		out = append(out, OrderInfo{LimitPrice: string(l[0]), Quantity: string(l[1])})
+	}
+	return out, nil
+}
+
+func unmarshalV4Orderbook(data []byte) (*Orderbook, error) {
+	var b v4Orderbook
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, err
+	}
+	asks, err := v4OrderbookInfo(b.Asks)
+	if err != nil {
+		return nil, err
+	}
+	bids, err := v4OrderbookInfo(b.Bids)
+	if err != nil {
+		return nil, err
+	}
+	return &Orderbook{Asks: asks, Bids: bids}, nil
+}
+
+func unmarshalV4Balances(data []byte) (*AccountInfo, error) {
+	var bs []v4Balance
+	if err := json.Unmarshal(data, &bs); err != nil {
+		return nil, err
+	}
+	info := &AccountInfo{}
+	for _, b := range bs {
+		c, err := ParseCoin(strings.ToUpper(b.Symbol))
+		if err != nil {
+			// Coins not supported by this package.
+			continue
+		}
+		amt := Amount{Available: string(b.Available), Total: string(b.Total)}
+		switch c {
+		case BRL:
+			info.Balance.BRL = amt
+		case BTC:
+			info.Balance.BTC.Amount = amt
+		case LTC:
+			info.Balance.LTC.Amount = amt
+		case BCH:
+			info.Balance.BCH.Amount = amt
+		case XRP:
+			info.Balance.XRP.Amount = amt
+		case ETH:
+			info.Balance.ETH.Amount = amt
+		}
+	}
+	return info, nil
+}
+
+func unmarshalV4Withdrawal(data []byte) (*Withdrawal, error) {
+	var w v4Withdrawal
+	if err := json.Unmarshal(data, &w); err != nil {
+		return nil, err
+	}
+	id, err := strconv.Atoi(string(w.ID))
+	if err != nil {
+		return nil, fmt.Errorf("invalid withdrawal id %q", w.ID)
+	}
+	var tag int
+	if w.DestinationTag != "" {
+		if tag, err = strconv.Atoi(string(w.DestinationTag)); err != nil {
+			return nil, fmt.Errorf("invalid destination tag %q", w.DestinationTag)
+		}
+	}
+	return &Withdrawal{
+		ID:               id,
+		Coin:             w.Coin,
+		Quantity:         string(w.Quantity),
+		NetQuantity:      string(w.NetQuantity),
+		Fee:              string(w.Fee),
+		Account:          w.Account,
+		Address:          w.Address,
+		Status:           w.Status,
+		Tx:               w.Tx,
+		DestinationTag:   tag,
+		CreatedTimestamp: string(w.CreatedAt),
+		UpdatedTimestamp: string(w.UpdatedAt),
+	}, nil
+}
@@ -0,0 +1,161 @@
+package tapi
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Defaults of VerifierConfig.
+const (
+	DefaultNonceWindow = time.Minute
+	DefaultMaxBody     = 64 << 10
+)
+
+// Errors of VerifyRequest.
+var (
+	ErrMissingAuth  = errors.New("missing TAPI-ID or TAPI-MAC")
+	ErrInvalidMAC   = errors.New("invalid TAPI-MAC")
+	ErrInvalidNonce = errors.New("invalid tapi_nonce")
+	ErrNonceWindow  = errors.New("tapi_nonce out of window")
+	ErrReplay       = errors.New("tapi_nonce already used")
+	ErrBodyTooLarge = errors.New("request body too large")
+)
+
+// KeyLookup returns the key of the TAPI-ID id.
+type KeyLookup func(id string) (string, error)
+
+// VerifierConfig configures a Verifier. Zero fields have defaults.
+type VerifierConfig struct {
+	// Window is the max distance between a nonce and the time of the
+	// verifier, DefaultNonceWindow by default. It must cover the skew
+	// between the clocks of the clients and of the verifier.
+	Window time.Duration
+
+	// Now returns the time the nonces are compared to. Clients with a
+	// Clock make the nonces with the time of the exchange, so a gateway
+	// should use the Now of its upstream Client. Nil means time.Now.
+	Now func() time.Time
+
+	// MaxBody is the max size of the body in bytes, DefaultMaxBody by
+	// default.
+	MaxBody int64
+}
+
+// Verifier checks the signature and the nonce of tapi requests. The
+// nonces must be unix times in nanoseconds, as created by Client.Nonce
+// from Client.Now. It is safe for concurrent use.
+type Verifier struct {
+	window  time.Duration
+	maxBody int64
+	now     func() time.Time
+
+	mu   sync.Mutex
+	seen map[string]map[int64]bool
+}
+
+// NewVerifier creates a Verifier configured by cfg.
+func NewVerifier(cfg VerifierConfig) *Verifier {
+	v := &Verifier{
+		window:  cfg.Window,
+		maxBody: cfg.MaxBody,
+		now:     cfg.Now,
+		seen:    make(map[string]map[int64]bool),
+	}
+	if v.window <= 0 {
+		v.window = DefaultNonceWindow
+	}
+	if v.maxBody <= 0 {
+		v.maxBody = DefaultMaxBody
+	}
+	if v.now == nil {
+		v.now = time.Now
+	}
+	return v
+}
+
+var defaultVerifier = NewVerifier(VerifierConfig{})
+
+// VerifyRequest verifies r with the default Verifier, which accepts
+// nonces within DefaultNonceWindow of the local time. See
+// Verifier.Verify.
+func VerifyRequest(r *http.Request, keys KeyLookup) (string, url.Values, error) {
+	return defaultVerifier.Verify(r, keys)
+}
+
+// Verify checks the TAPI-MAC of r with the key of its TAPI-ID, that the
+// nonce is within the window and that it was not used before. It returns
+// the TAPI-ID and the params of the body. The body is only read if the
+// TAPI-ID has a key, and can be read again after Verify.
+func (v *Verifier) Verify(r *http.Request, keys KeyLookup) (string, url.Values, error) {
+	id, mac := r.Header.Get("TAPI-ID"), r.Header.Get("TAPI-MAC")
+	if id == "" || mac == "" {
+		return "", nil, ErrMissingAuth
+	}
+	key, err := keys(id)
+	if err != nil {
+		return "", nil, err
+	}
+	var body []byte
+	if r.Body != nil {
+		body, err = ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, v.maxBody))
+		r.Body.Close()
+		if err != nil {
+			if int64(len(body)) >= v.maxBody {
+				return "", nil, ErrBodyTooLarge
+			}
+			return "", nil, err
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	expected := sign(key, r.URL.Path+"?"+string(body))
+	if !hmac.Equal([]byte(mac), []byte(expected)) {
+		return "", nil, ErrInvalidMAC
+	}
+	params, err := url.ParseQuery(string(body))
+	if err != nil {
+		return "", nil, err
+	}
+	nonce, err := strconv.ParseInt(params.Get("tapi_nonce"), 10, 64)
+	if err != nil {
+		return "", nil, ErrInvalidNonce
+	}
+	if err := v.useNonce(id, nonce); err != nil {
+		return "", nil, err
+	}
+	return id, params, nil
+}
+
+// useNonce checks the window and records the nonce of id. Nonces out of
+// the window are forgotten, as they are rejected anyway.
+func (v *Verifier) useNonce(id string, nonce int64) error {
+	now := v.now().UnixNano()
+	w := int64(v.window)
+	if nonce < now-w || nonce > now+w {
+		return fmt.Errorf("%w: %v from now", ErrNonceWindow, time.Duration(nonce-now))
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	seen := v.seen[id]
+	if seen == nil {
+		seen = make(map[int64]bool)
+		v.seen[id] = seen
+	}
+	if seen[nonce] {
+		return ErrReplay
+	}
+	for n := range seen {
+		if n < now-w {
+			delete(seen, n)
+		}
+	}
+	seen[nonce] = true
+	return nil
+}
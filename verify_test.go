@@ -0,0 +1,119 @@
+package tapi
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testKeys(id string) (string, error) {
+	if id != fakeID {
+		return "", errors.New("unknown id")
+	}
+	return fakeKey, nil
+}
+
+func TestVerifyRequest(t *testing.T) {
+	var gotID string
+	var gotParams url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		gotID, gotParams, err = VerifyRequest(r, testKeys)
+		if err != nil {
+			w.Write([]byte(`{"status_code":203,"error_message":"` + err.Error() + `"}`))
+			return
+		}
+		w.Write(jsonGetOrder)
+	}))
+	defer srv.Close()
+	c := NewClient(srv.URL+"/tapi/v3/", fakeID, fakeKey, nil)
+	if _, err := c.GetOrder(BRL, BTC, 7); err != nil {
+		t.Fatal(err)
+	}
+	if gotID != fakeID {
+		t.Errorf("got id %s, expected %s", gotID, fakeID)
+	}
+	if gotParams.Get("tapi_method") != "get_order" || gotParams.Get("order_id") != "7" {
+		t.Errorf("got params %v", gotParams)
+	}
+	c = NewClient(srv.URL+"/tapi/v3/", fakeID, "wrong", nil)
+	if _, err := c.GetOrder(BRL, BTC, 7); err == nil || err.Error() != ErrInvalidMAC.Error() {
+		t.Errorf("got error %v, expected %v", err, ErrInvalidMAC)
+	}
+}
+
+func signedRequest(path, key string, nonce int64) *http.Request {
+	body := "tapi_method=get_order&tapi_nonce=" + strconv.FormatInt(nonce, 10)
+	r := httptest.NewRequest("POST", path, strings.NewReader(body))
+	r.Header.Set("TAPI-ID", fakeID)
+	r.Header.Set("TAPI-MAC", sign(key, path+"?"+body))
+	return r
+}
+
+func TestVerifierNonce(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	v := NewVerifier(VerifierConfig{Window: time.Minute, Now: func() time.Time { return now }})
+	tests := []struct {
+		nonce int64
+		err   error
+	}{
+		{now.UnixNano(), nil},
+		{now.UnixNano(), ErrReplay},
+		{now.Add(-30 * time.Second).UnixNano(), nil},
+		{now.Add(-2 * time.Minute).UnixNano(), ErrNonceWindow},
+		{now.Add(2 * time.Minute).UnixNano(), ErrNonceWindow},
+	}
+	for _, tt := range tests {
+		r := signedRequest("/tapi/v3/", fakeKey, tt.nonce)
+		if _, _, err := v.Verify(r, testKeys); !errors.Is(err, tt.err) {
+			t.Errorf("nonce %d: got error %v, expected %v", tt.nonce, err, tt.err)
+		}
+	}
+	r := signedRequest("/tapi/v3/", fakeKey, now.UnixNano()+1)
+	r.Header.Del("TAPI-MAC")
+	if _, _, err := v.Verify(r, testKeys); err != ErrMissingAuth {
+		t.Errorf("got error %v, expected %v", err, ErrMissingAuth)
+	}
+
+	// The nonces out of the window are forgotten.
+	now = now.Add(time.Hour)
+	v.Verify(signedRequest("/tapi/v3/", fakeKey, now.UnixNano()), testKeys)
+	if n := len(v.seen[fakeID]); n != 1 {
+		t.Errorf("got %d nonces, expected 1", n)
+	}
+}
+
+type countReader struct {
+	n int
+}
+
+func (r *countReader) Read(p []byte) (int, error) {
+	r.n += len(p)
+	for i := range p {
+		p[i] = 'a'
+	}
+	return len(p), nil
+}
+
+func TestVerifyBody(t *testing.T) {
+	v := NewVerifier(VerifierConfig{MaxBody: 1024})
+	body := &countReader{}
+	r := httptest.NewRequest("POST", "/tapi/v3/", body)
+	r.Header.Set("TAPI-ID", "unknown")
+	r.Header.Set("TAPI-MAC", "mac")
+	if _, _, err := v.Verify(r, testKeys); err == nil || body.n != 0 {
+		t.Errorf("got error %v and %d bytes read, expected unknown id before reading", err, body.n)
+	}
+	r.Header.Set("TAPI-ID", fakeID)
+	if _, _, err := v.Verify(r, testKeys); err != ErrBodyTooLarge {
+		t.Errorf("got error %v, expected %v", err, ErrBodyTooLarge)
+	}
+	if body.n > 4096 {
+		t.Errorf("got %d bytes read, expected about 1024", body.n)
+	}
+}